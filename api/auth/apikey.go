@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// KindToken credentials coming from a JWT access token
+	KindToken = "token"
+	// KindAPIKey credentials coming from an API key
+	KindAPIKey = "api_key"
+
+	apiKeyMarker = "ak"
+)
+
+// ErrAPIKeyInvalid the API key is unknown, revoked or expired
+var ErrAPIKeyInvalid = errors.New("Invalid API Key")
+
+// APIKeyStore authenticates API keys presented instead of a token
+type APIKeyStore interface {
+	Authenticate(key string) (Claims, error)
+}
+
+var apiKeys APIKeyStore
+
+// SetAPIKeyStore set the store API keys are checked against, without one API keys are rejected
+func SetAPIKeyStore(store APIKeyStore) {
+	apiKeys = store
+}
+
+// NewAPIKey generate an API key as ak_<prefix>_<secret>, with the prefix to find it by and the hash to store
+func NewAPIKey() (string, string, string, error) {
+	b := make([]byte, 6)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", "", err
+	}
+	prefix := hex.EncodeToString(b)
+	secret, _, err := NewOpaqueToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key := apiKeyMarker + "_" + prefix + "_" + secret
+	return key, prefix, HashToken(key), nil
+}
+
+// ExtractAPIKey to extract the API key from the X-API-Key header or an "ApiKey" authorization
+func ExtractAPIKey(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if key != "" {
+		return key
+	}
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) == 2 && strings.EqualFold(parts[0], "ApiKey") {
+		return parts[1]
+	}
+	return ""
+}
+
+// apiKeyPrefix the prefix of a well-formed key
+func apiKeyPrefix(key string) (string, bool) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyMarker || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// DBAPIKeyStore an APIKeyStore backed by the api_keys table
+type DBAPIKeyStore struct {
+	DB *gorm.DB
+}
+
+// NewDBAPIKeyStore create a DBAPIKeyStore
+func NewDBAPIKeyStore(db *gorm.DB) *DBAPIKeyStore {
+	return &DBAPIKeyStore{DB: db}
+}
+
+// Authenticate implements APIKeyStore. The key acts as an ordinary user whatever the role of its
+// owner, with the default scopes when it was stored without any.
+func (s *DBAPIKeyStore) Authenticate(key string) (Claims, error) {
+	prefix, ok := apiKeyPrefix(key)
+	if !ok {
+		return Claims{}, ErrAPIKeyInvalid
+	}
+	apiKey := models.APIKey{}
+	_, err := apiKey.FindAPIKeyByPrefix(s.DB, prefix)
+	if err != nil {
+		return Claims{}, ErrAPIKeyInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(HashToken(key))) != 1 || !apiKey.Usable(time.Now()) {
+		return Claims{}, ErrAPIKeyInvalid
+	}
+
+	user := models.User{}
+	_, err = user.FindUserByID(s.DB, apiKey.UserID)
+	if err != nil {
+		return Claims{}, ErrAPIKeyInvalid
+	}
+	err = apiKey.TouchAPIKey(s.DB, apiKey.ID)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	scopes := apiKey.ScopeList
+	if len(scopes) == 0 {
+		scopes = models.DefaultAPIKeyScopes
+	}
+	claims := Claims{
+		UserID:        apiKey.UserID,
+		Role:          models.RoleUser,
+		EmailVerified: user.EmailVerified,
+		TokenID:       apiKey.ID.String(),
+		Kind:          KindAPIKey,
+		Scopes:        scopes,
+	}
+	if apiKey.ExpiresAt != nil {
+		claims.ExpiresAt = *apiKey.ExpiresAt
+	}
+	return claims, nil
+}
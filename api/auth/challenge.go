@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+const (
+	// typeTwoFactorChallenge the typ claim of the token a login with 2FA returns
+	typeTwoFactorChallenge = "2fa_challenge"
+	// ChallengeTokenTTL how long the second step of a login may take
+	ChallengeTokenTTL = 5 * time.Minute
+)
+
+// ErrChallengeInvalid the challenge token is invalid or expired
+var ErrChallengeInvalid = errors.New("Invalid Challenge Token")
+
+// CreateChallengeToken generate the token that proves the password was right.
+// It only can be exchanged for an access token together with a second factor.
+func CreateChallengeToken(userID uuid.UUID) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	claims["typ"] = typeTwoFactorChallenge
+	claims["user_id"] = userID.String()
+	claims["jti"] = uuid.Must(uuid.NewRandom()).String()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ChallengeTokenTTL).Unix()
+	return signClaims(claims)
+}
+
+// ParseChallengeToken validate a challenge token and return its user
+func ParseChallengeToken(tokenString string) (uuid.UUID, error) {
+	claims, err := currentVerifier().Verify(tokenString)
+	if err != nil {
+		return uuid.Nil, ErrChallengeInvalid
+	}
+	if typ, _ := claims["typ"].(string); typ != typeTwoFactorChallenge {
+		return uuid.Nil, ErrChallengeInvalid
+	}
+	uid, err := uuid.Parse(fmt.Sprintf("%s", claims["user_id"]))
+	if err != nil {
+		return uuid.Nil, ErrChallengeInvalid
+	}
+	return uid, nil
+}
@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// AlgRS256 sign tokens with 2048 bit RSA keys
+	AlgRS256 = "RS256"
+	// AlgEdDSA sign tokens with Ed25519 keys
+	AlgEdDSA = "EdDSA"
+)
+
+// ErrNoSigningKey the key set has no key that may sign new tokens
+var ErrNoSigningKey = errors.New("No Signing Key")
+
+// SigningKey a key pair used to sign or verify tokens, picked by its kid
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Private   crypto.Signer
+	Public    crypto.PublicKey
+	CreatedAt time.Time
+	// RetiredAt is zero while the key signs new tokens.
+	// A retired key only verifies until the tokens it signed have expired.
+	RetiredAt time.Time
+}
+
+// KeySet the keys the API signs and verifies tokens with.
+// The newest key signs, every key that is not expired verifies.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []*SigningKey
+	alg  string
+	dir  string
+}
+
+// NewKeySet create an empty KeySet that generates keys for the alg, kept in memory only
+func NewKeySet(alg string) *KeySet {
+	if alg == "" {
+		alg = AlgRS256
+	}
+	return &KeySet{alg: alg}
+}
+
+// keyCreatedHeader the PEM header a key file keeps the time the key was created in, so
+// copying or restoring the file does not change its age
+const keyCreatedHeader = "Created"
+
+// keyIDTimeLayout the creation time every generated kid starts with
+const keyIDTimeLayout = "20060102T150405"
+
+// LoadKeySet load the PEM encoded private keys of dir, one file per key named after its kid.
+// With an empty dir, or a dir without keys, a new key is generated.
+func LoadKeySet(dir, alg string) (*KeySet, error) {
+	ks := NewKeySet(alg)
+	if ks.alg != AlgRS256 && ks.alg != AlgEdDSA {
+		return nil, fmt.Errorf("Unsupported signing algorithm: %s", alg)
+	}
+	ks.dir = dir
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			key, err := readSigningKey(file)
+			if err != nil {
+				return nil, err
+			}
+			ks.keys = append(ks.keys, key)
+		}
+		sort.Slice(ks.keys, func(i, j int) bool {
+			return ks.keys[i].CreatedAt.Before(ks.keys[j].CreatedAt)
+		})
+		for i := 0; i < len(ks.keys)-1; i++ {
+			ks.keys[i].RetiredAt = ks.keys[i+1].CreatedAt
+		}
+		ks.prune(time.Now())
+	}
+
+	if len(ks.keys) == 0 {
+		_, err := ks.Rotate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+// Rotate generate a new signing key and retire the current one.
+// Retired keys keep verifying until the tokens they signed have expired.
+func (ks *KeySet) Rotate() (*SigningKey, error) {
+	key, err := generateSigningKey(ks.alg)
+	if err != nil {
+		return nil, err
+	}
+	if ks.dir != "" {
+		err = writeSigningKey(ks.dir, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for _, k := range ks.keys {
+		if k.RetiredAt.IsZero() {
+			k.RetiredAt = key.CreatedAt
+		}
+	}
+	ks.keys = append(ks.keys, key)
+	ks.prune(key.CreatedAt)
+	return key, nil
+}
+
+// StartRotation rotate the keys in the background whenever the signing key is interval old.
+// The age counts from the creation time stored with the key, not from when the API started.
+func (ks *KeySet) StartRotation(interval time.Duration) {
+	go func() {
+		for {
+			wait := interval
+			if current, err := ks.SigningKey(); err == nil {
+				wait = interval - time.Since(current.CreatedAt)
+			}
+			if wait > 0 {
+				time.Sleep(wait)
+			}
+			key, err := ks.Rotate()
+			if err != nil {
+				log.Printf("cannot rotate signing key: %v", err)
+				time.Sleep(time.Minute)
+				continue
+			}
+			log.Printf("rotated signing key, new kid %s", key.ID)
+		}
+	}()
+}
+
+// SigningKey the key new tokens are signed with
+func (ks *KeySet) SigningKey() (*SigningKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for i := len(ks.keys) - 1; i >= 0; i-- {
+		if ks.keys[i].RetiredAt.IsZero() {
+			return ks.keys[i], nil
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+// Key find a key that may still verify tokens by its kid
+func (ks *KeySet) Key(kid string) (*SigningKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	now := time.Now()
+	for _, k := range ks.keys {
+		if k.ID == kid && !k.expired(now) {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// Keys the keys that may still verify tokens, oldest first
+func (ks *KeySet) Keys() []*SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	now := time.Now()
+	keys := []*SigningKey{}
+	for _, k := range ks.keys {
+		if !k.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// prune drop the keys no token can still be signed with, callers hold the lock
+func (ks *KeySet) prune(now time.Time) {
+	keys := ks.keys[:0]
+	for _, k := range ks.keys {
+		if !k.expired(now) {
+			keys = append(keys, k)
+			continue
+		}
+		if ks.dir != "" {
+			err := os.Remove(filepath.Join(ks.dir, k.ID+".pem"))
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("cannot remove expired signing key %s: %v", k.ID, err)
+			}
+		}
+	}
+	ks.keys = keys
+}
+
+// expired a key is expired once every token it signed has expired
+func (k *SigningKey) expired(now time.Time) bool {
+	return !k.RetiredAt.IsZero() && now.After(k.RetiredAt.Add(AccessTokenTTL()))
+}
+
+func generateSigningKey(alg string) (*SigningKey, error) {
+	now := time.Now()
+	key := &SigningKey{ID: newKeyID(now), CreatedAt: now}
+	switch alg {
+	case AlgRS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.Private = privateKey
+		key.Public = &privateKey.PublicKey
+	case AlgEdDSA:
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key.Method = SigningMethodEd25519
+		key.Private = privateKey
+		key.Public = publicKey
+	default:
+		return nil, fmt.Errorf("Unsupported signing algorithm: %s", alg)
+	}
+	return key, nil
+}
+
+func newKeyID(createdAt time.Time) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return createdAt.UTC().Format(keyIDTimeLayout) + "-" + hex.EncodeToString(b)
+}
+
+// keyCreatedAt the time the key of the file was created: the Created header, or the time its
+// kid starts with for files written before the header. The modification time is the last
+// resort, for keys put in the directory by hand.
+func keyCreatedAt(file string, id string, block *pem.Block) (time.Time, error) {
+	if created, ok := block.Headers[keyCreatedHeader]; ok {
+		t, err := time.Parse(time.RFC3339Nano, created)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s has an invalid %s header: %v", file, keyCreatedHeader, err)
+		}
+		return t, nil
+	}
+	if i := strings.Index(id, "-"); i > 0 {
+		if t, err := time.Parse(keyIDTimeLayout, id[:i]); err == nil {
+			return t, nil
+		}
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func readSigningKey(file string) (*SigningKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", file)
+	}
+	var parsed interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", file, err)
+	}
+	id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	createdAt, err := keyCreatedAt(file, id, block)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{
+		ID:        id,
+		CreatedAt: createdAt,
+	}
+	switch privateKey := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.Private = privateKey
+		key.Public = &privateKey.PublicKey
+	case ed25519.PrivateKey:
+		key.Method = SigningMethodEd25519
+		key.Private = privateKey
+		key.Public = privateKey.Public()
+	default:
+		return nil, fmt.Errorf("%s holds an unsupported key type", file)
+	}
+	return key, nil
+}
+
+func writeSigningKey(dir string, key *SigningKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{keyCreatedHeader: key.CreatedAt.UTC().Format(time.RFC3339Nano)},
+		Bytes:   der,
+	})
+	return ioutil.WriteFile(filepath.Join(dir, key.ID+".pem"), data, 0600)
+}
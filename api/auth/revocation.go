@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// RevocationStore keeps track of access tokens that must stop working before they expire
+type RevocationStore interface {
+	// RevokeToken revoke a single token by its jti until it expires
+	RevokeToken(jti string, userID uuid.UUID, expiresAt time.Time) error
+	// RevokeUserTokens revoke every token of the user issued before the given time
+	RevokeUserTokens(userID uuid.UUID, issuedBefore time.Time) error
+	// IsRevoked report whether the token was revoked
+	IsRevoked(jti string, userID uuid.UUID, issuedAt time.Time) (bool, error)
+}
+
+var revocations RevocationStore = NewMemoryRevocationStore()
+
+// SetRevocationStore replace the store the token checks use, the default keeps revocations in memory
+func SetRevocationStore(store RevocationStore) {
+	revocations = store
+}
+
+// RevokeToken revoke a single token through the configured store
+func RevokeToken(jti string, userID uuid.UUID, expiresAt time.Time) error {
+	return revocations.RevokeToken(jti, userID, expiresAt)
+}
+
+// RevokeUserTokens revoke every token the user holds right now through the configured store.
+// The cutoff has the millisecond precision of the iat claim, so tokens issued right after still work.
+func RevokeUserTokens(userID uuid.UUID) error {
+	return revocations.RevokeUserTokens(userID, time.Now().Truncate(time.Millisecond))
+}
+
+// MemoryRevocationStore a RevocationStore for a single instance, development and tests
+type MemoryRevocationStore struct {
+	mu      sync.RWMutex
+	tokens  map[string]time.Time
+	cutoffs map[uuid.UUID]time.Time
+}
+
+// NewMemoryRevocationStore create an empty MemoryRevocationStore
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens:  map[string]time.Time{},
+		cutoffs: map[uuid.UUID]time.Time{},
+	}
+}
+
+// RevokeToken implements RevocationStore
+func (s *MemoryRevocationStore) RevokeToken(jti string, userID uuid.UUID, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, exp := range s.tokens {
+		if exp.Before(now) {
+			delete(s.tokens, id)
+		}
+	}
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+// RevokeUserTokens implements RevocationStore
+func (s *MemoryRevocationStore) RevokeUserTokens(userID uuid.UUID, issuedBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if issuedBefore.After(s.cutoffs[userID]) {
+		s.cutoffs[userID] = issuedBefore
+	}
+	return nil
+}
+
+// IsRevoked implements RevocationStore
+func (s *MemoryRevocationStore) IsRevoked(jti string, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.tokens[jti]; ok {
+		return true, nil
+	}
+	return issuedAt.Before(s.cutoffs[userID]), nil
+}
+
+// DBRevocationStore a RevocationStore backed by the revoked_tokens and token_cutoffs tables
+type DBRevocationStore struct {
+	DB *gorm.DB
+}
+
+// NewDBRevocationStore create a DBRevocationStore
+func NewDBRevocationStore(db *gorm.DB) *DBRevocationStore {
+	return &DBRevocationStore{DB: db}
+}
+
+// RevokeToken implements RevocationStore
+func (s *DBRevocationStore) RevokeToken(jti string, userID uuid.UUID, expiresAt time.Time) error {
+	revoked := models.RevokedToken{JTI: jti, UserID: userID, ExpiresAt: expiresAt}
+	_, err := revoked.DeleteExpiredRevokedTokens(s.DB)
+	if err != nil {
+		return err
+	}
+	_, err = revoked.SaveRevokedToken(s.DB)
+	return err
+}
+
+// RevokeUserTokens implements RevocationStore
+func (s *DBRevocationStore) RevokeUserTokens(userID uuid.UUID, issuedBefore time.Time) error {
+	cutoff := models.TokenCutoff{UserID: userID, IssuedBefore: issuedBefore}
+	_, err := cutoff.SaveTokenCutoff(s.DB)
+	return err
+}
+
+// IsRevoked implements RevocationStore
+func (s *DBRevocationStore) IsRevoked(jti string, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	revoked := models.RevokedToken{}
+	isRevoked, err := revoked.IsTokenRevoked(s.DB, jti)
+	if err != nil || isRevoked {
+		return isRevoked, err
+	}
+	cutoff := models.TokenCutoff{}
+	issuedBefore, err := cutoff.FindTokenCutoff(s.DB, userID)
+	if err != nil {
+		return false, err
+	}
+	return issuedAt.Before(issuedBefore), nil
+}
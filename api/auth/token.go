@@ -1,11 +1,12 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -13,15 +14,52 @@ import (
 	"github.com/google/uuid"
 )
 
-//CreateToken to generate the token
-func CreateToken(userID uuid.UUID) (string, error) {
+//defaultRole the role of tokens issued before roles were added to the claims
+const defaultRole = "user"
+
+var (
+	//ErrTokenInvalid the token could not be validated
+	ErrTokenInvalid = errors.New("Invalid Token")
+	//ErrTokenRevoked the token was revoked before it expired
+	ErrTokenRevoked = errors.New("Token Revoked")
+)
+
+//CreateToken to generate a short-lived access token
+func CreateToken(userID uuid.UUID, role string, emailVerified bool) (string, error) {
 	convertID := userID
+	now := time.Now()
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["user_id"] = convertID.String()
-	claims["exp"] = time.Now().Add(time.Hour * 1999999).Unix() //Token expires after x hour
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+	claims["role"] = role
+	claims["email_verified"] = emailVerified
+	claims["jti"] = uuid.Must(uuid.NewRandom()).String()
+	claims["iat"] = float64(now.UnixNano()/int64(time.Millisecond)) / 1000 //Millisecond precision, so revocations are exact
+	claims["exp"] = now.Add(AccessTokenTTL()).Unix()                       //Token expires after ACCESS_TOKEN_TTL
+	return signClaims(claims)
+}
+
+//signClaims sign the claims with the current signing key, named by the kid header
+func signClaims(claims jwt.MapClaims) (string, error) {
+	key, err := Keys().SigningKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+//Claims the claims of a valid access token
+type Claims struct {
+	UserID        uuid.UUID
+	Role          string
+	EmailVerified bool
+	TokenID       string
+	IssuedAt      time.Time
+	ExpiresAt     time.Time
+	Kind          string
+	Scopes        []string // Only API keys are limited by scopes, a key may do only what its scopes allow
 }
 
 //ExtractToken to extract the token
@@ -32,52 +70,108 @@ func ExtractToken(r *http.Request) string {
 		return token
 	}
 	bearerToken := r.Header.Get("Authorization")
-	if len(strings.Split(bearerToken, " ")) == 2 {
+	if len(strings.Split(bearerToken, " ")) == 2 && !strings.EqualFold(strings.Split(bearerToken, " ")[0], "ApiKey") {
 		return strings.Split(bearerToken, " ")[1]
 	}
 	return ""
 }
 
-//TokenValid to validate the token
+//TokenValid to validate the token or the API key
 func TokenValid(r *http.Request) error {
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+	claims, err := ExtractClaims(r)
 	if err != nil {
 		return err
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		Pretty(claims)
-	}
+	Pretty(claims)
 	return nil
 }
 
 //ExtractTokenID to extract the token ID
 func ExtractTokenID(r *http.Request) (uuid.UUID, error) {
+	claims, err := ExtractClaims(r)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
 
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+//ExtractClaims to extract the claims of a valid, not revoked token or API key
+func ExtractClaims(r *http.Request) (Claims, error) {
+
+	if claims, ok := r.Context().Value(claimsContextKey{}).(Claims); ok {
+		return claims, nil
+	}
+
+	if key := ExtractAPIKey(r); key != "" {
+		if apiKeys == nil {
+			return Claims{}, ErrAPIKeyInvalid
 		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+		return apiKeys.Authenticate(key)
+	}
+
+	tokenString := ExtractToken(r)
+	claims, err := currentVerifier().Verify(tokenString)
 	if err != nil {
-		return uuid.Nil, err
+		return Claims{}, err
+	}
+	return checkClaims(claims)
+}
+
+//checkClaims read the claims we rely on and reject revoked tokens
+func checkClaims(mapClaims jwt.MapClaims) (Claims, error) {
+	//Access tokens have no typ, other tokens such as 2FA challenges never authenticate a request
+	if typ, ok := mapClaims["typ"]; ok && typ != "" {
+		return Claims{}, ErrTokenInvalid
+	}
+	uid, err := uuid.Parse(fmt.Sprintf("%s", mapClaims["user_id"]))
+	if err != nil {
+		return Claims{}, err
+	}
+	claims := Claims{UserID: uid, Kind: KindToken}
+	claims.Role, _ = mapClaims["role"].(string)
+	if claims.Role == "" {
+		claims.Role = defaultRole
+	}
+	claims.EmailVerified, _ = mapClaims["email_verified"].(bool)
+	claims.TokenID, _ = mapClaims["jti"].(string)
+	if iat, ok := mapClaims["iat"].(float64); ok {
+		claims.IssuedAt = time.Unix(0, int64(iat*1000)*int64(time.Millisecond))
+	}
+	if exp, ok := mapClaims["exp"].(float64); ok {
+		claims.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+
+	revoked, err := revocations.IsRevoked(claims.TokenID, claims.UserID, claims.IssuedAt)
+	if err != nil {
+		return Claims{}, err
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
-		uid, err := uuid.Parse(fmt.Sprintf("%s", claims["user_id"]))
-		if err != nil {
-			return uuid.Nil, err
+	if revoked {
+		return Claims{}, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+//HasRole check if the claims carry one of the roles
+func (c Claims) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if c.Role == role {
+			return true
 		}
-		return uid, nil
 	}
-	return uuid.Nil, nil
+	return false
+}
+
+//HasScope check if the credentials allow the scope, tokens allow every scope
+func (c Claims) HasScope(scope string) bool {
+	if c.Kind != KindAPIKey {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
 }
 
 //Pretty display the claims licely in the terminal
@@ -90,3 +184,10 @@ func Pretty(data interface{}) {
 
 	fmt.Println(string(b))
 }
+
+type claimsContextKey struct{}
+
+//WithClaims remember the claims of an authenticated request, so later checks don't verify again
+func WithClaims(r *http.Request, claims Claims) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, claims))
+}
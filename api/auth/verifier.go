@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Verifier checks the signature and expiry of a token and returns its claims
+type Verifier interface {
+	Verify(tokenString string) (jwt.MapClaims, error)
+}
+
+var (
+	keySet     *KeySet
+	keySetOnce sync.Once
+	verifier   Verifier
+)
+
+// SetKeySet replace the keys tokens are signed and verified with
+func SetKeySet(ks *KeySet) {
+	keySetOnce.Do(func() {})
+	keySet = ks
+}
+
+// SetVerifier replace the verifier, by default tokens are verified against the key set
+func SetVerifier(v Verifier) {
+	verifier = v
+}
+
+// Keys the key set in use. Without a configured one, a key is generated in memory,
+// so tokens stop verifying when the process restarts.
+func Keys() *KeySet {
+	keySetOnce.Do(func() {
+		ks, err := LoadKeySet("", AlgRS256)
+		if err != nil {
+			log.Fatalf("cannot generate signing key: %v", err)
+		}
+		keySet = ks
+	})
+	return keySet
+}
+
+func currentVerifier() Verifier {
+	if verifier != nil {
+		return verifier
+	}
+	return Keys()
+}
+
+// Verify implements Verifier, the key is picked by the kid header
+func (ks *KeySet) Verify(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, ks.Keyfunc)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, ErrTokenInvalid
+	}
+	return claims, nil
+}
+
+// Keyfunc a jwt.Keyfunc returning the public key named by the kid header
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.Key(kid)
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing key: %v", token.Header["kid"])
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+// JWK the public part of a signing key, as published in the JWKS
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS the public keys other services verify our tokens with
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.Keys() {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch publicKey := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
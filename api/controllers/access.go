@@ -0,0 +1,13 @@
+package controllers
+
+import (
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/google/uuid"
+)
+
+// canManage check if the token allows managing what belongs to ownerID.
+// Admins manage everything, ordinary users only what they own.
+func canManage(claims auth.Claims, ownerID uuid.UUID) bool {
+	return claims.UserID == ownerID || claims.HasRole(models.RoleAdmin)
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/arikardnoir/asiwaju/api/utils/formaterror"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// CreateAPIKey create an API key for the user, the key itself is only shown in this response
+func (server *Server) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	uid, ok := server.apiKeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	apiKey := models.APIKey{}
+	err = json.Unmarshal(body, &apiKey)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	apiKey.Prepare()
+	err = apiKey.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	key, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	apiKey.ID = uuid.Must(uuid.NewRandom())
+	apiKey.UserID = uid
+	apiKey.Prefix = prefix
+	apiKey.KeyHash = hash
+
+	apiKeyCreated, err := apiKey.SaveAPIKey(server.DB)
+	if err != nil {
+		formattedError := formaterror.FormatError(err.Error())
+		responses.ERROR(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%s", r.Host, r.URL.Path, apiKeyCreated.ID))
+	responses.JSON(w, http.StatusCreated, map[string]interface{}{
+		"data": apiKeyCreated,
+		"key":  key,
+	})
+}
+
+// GetAPIKeys list the API keys of the user
+func (server *Server) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+
+	uid, ok := server.apiKeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	apiKey := models.APIKey{}
+	apiKeys, err := apiKey.FindUserAPIKeys(server.DB, uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, apiKeys)
+}
+
+// RevokeAPIKey revoke an API key of the user
+func (server *Server) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	uid, ok := server.apiKeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	kid, err := uuid.Parse(mux.Vars(r)["keyID"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	apiKey := models.APIKey{}
+	revoked, err := apiKey.RevokeAPIKey(server.DB, kid, uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	if revoked == 0 {
+		responses.ERROR(w, http.StatusNotFound, errors.New("API Key not found"))
+		return
+	}
+	responses.JSON(w, http.StatusNoContent, "")
+}
+
+// apiKeyOwner the user of the {id} route variable, when the request may manage their keys.
+// API keys cannot be used to manage API keys.
+func (server *Server) apiKeyOwner(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	uid, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return uuid.Nil, false
+	}
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return uuid.Nil, false
+	}
+	if claims.Kind == auth.KindAPIKey {
+		responses.ERROR(w, http.StatusForbidden, errors.New("Forbidden"))
+		return uuid.Nil, false
+	}
+	if !canManage(claims, uid) {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return uuid.Nil, false
+	}
+	return uid, true
+}
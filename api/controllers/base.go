@@ -4,19 +4,39 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
 
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/exchange"
+	"github.com/arikardnoir/asiwaju/api/loginguard"
+	"github.com/arikardnoir/asiwaju/api/mailer"
+	"github.com/arikardnoir/asiwaju/api/migrations"
 	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/renditions"
+	"github.com/arikardnoir/asiwaju/api/storage"
 
 	_ "github.com/jinzhu/gorm/dialects/postgres" //postgres database driver
 )
 
 //Server our DB & Route setup
 type Server struct {
-	DB     *gorm.DB
-	Router *mux.Router
+	DB         *gorm.DB
+	Router     *mux.Router
+	Mailer     mailer.Sender
+	LoginGuard *loginguard.Guard
+	// ExchangeRates converts the prices of product reads to the currency asked for
+	ExchangeRates *exchange.Provider
+	// Blobs keeps the uploaded product images
+	Blobs storage.BlobStore
+	// Renditions resizes the uploaded product images in the background
+	Renditions *renditions.Pipeline
+
+	exchangeRatesOnce sync.Once
 }
 
 //Initialize start app
@@ -45,7 +65,85 @@ func (server *Server) Initialize(Dbdriver, DbUser, DbPassword, DbPort, DbHost, D
 		}
 	}
 
-	server.DB.Debug().AutoMigrate(&models.User{}, &models.Product{}) //database migration
+	server.DB.Debug().AutoMigrate(&models.User{}, &models.Product{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.TokenCutoff{}, &models.APIKey{}, &models.OneTimeToken{}, &models.RecoveryCode{}, &models.LoginAttempt{}, &models.Category{}, &models.Tag{}, &models.ProductVariant{}, &models.StockMovement{}, &models.StockReservation{}, &models.ExchangeRate{}, &models.ProductImage{}, &models.ImageRendition{}, &models.ImportJob{}, &models.ProductRevision{}, &models.PriceChange{}) //database migration
+
+	err = models.MigrateProductSearch(server.DB)
+	if err != nil {
+		log.Printf("Product search will not work: %v", err)
+	}
+
+	err = migrations.Run(server.DB)
+	if err != nil {
+		log.Fatal("Cannot migrate the data:", err)
+	}
+
+	// Jobs that made no progress for an hour were running on an instance that stopped
+	if count, err := models.FailStaleImportJobs(server.DB, time.Now().Add(-time.Hour)); err != nil {
+		log.Printf("cannot fail the interrupted import jobs: %v", err)
+	} else if count > 0 {
+		log.Printf("failed %d interrupted import jobs", count)
+	}
+
+	auth.SetRevocationStore(auth.NewDBRevocationStore(server.DB))
+	auth.SetAPIKeyStore(auth.NewDBAPIKeyStore(server.DB))
+
+	if os.Getenv("LOGIN_GUARD_STORE") == "memory" {
+		server.LoginGuard = loginguard.New(loginguard.NewMemoryStore(), loginguard.ConfigFromEnv())
+	} else {
+		server.LoginGuard = loginguard.New(loginguard.NewDBStore(server.DB), loginguard.ConfigFromEnv())
+	}
+	loginSweep := time.Minute
+	if d, err := time.ParseDuration(os.Getenv("LOGIN_SWEEP_INTERVAL")); err == nil && d > 0 {
+		loginSweep = d
+	}
+	server.LoginGuard.StartSweeper(loginSweep)
+
+	outbox, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal("Cannot configure the mailer:", err)
+	}
+	server.Mailer = outbox
+
+	server.ExchangeRates, err = exchange.FromEnv(server.DB)
+	if err != nil {
+		log.Fatal("Cannot configure the exchange rates:", err)
+	}
+
+	server.Blobs, err = storage.FromEnv()
+	if err != nil {
+		log.Fatal("Cannot configure the blob store:", err)
+	}
+	server.Renditions, err = renditions.FromEnv(server.DB, server.Blobs)
+	if err != nil {
+		log.Fatal("Cannot configure the image renditions:", err)
+	}
+
+	keys, err := auth.LoadKeySet(os.Getenv("JWT_KEY_DIR"), os.Getenv("JWT_SIGNING_ALG"))
+	if err != nil {
+		log.Fatal("Cannot load the signing keys:", err)
+	}
+	auth.SetKeySet(keys)
+	if rotation, err := time.ParseDuration(os.Getenv("JWT_KEY_ROTATION")); err == nil && rotation > 0 {
+		keys.StartRotation(rotation)
+	}
+
+	sweep := time.Minute
+	if d, err := time.ParseDuration(os.Getenv("RESERVATION_SWEEP_INTERVAL")); err == nil && d > 0 {
+		sweep = d
+	}
+	server.startReservationSweeper(sweep)
+
+	purge := time.Hour
+	if d, err := time.ParseDuration(os.Getenv("TRASH_PURGE_INTERVAL")); err == nil && d > 0 {
+		purge = d
+	}
+	server.startTrashPurger(purge, trashRetention())
+
+	renditionInterval := time.Minute
+	if d, err := time.ParseDuration(os.Getenv("IMAGE_RENDITION_INTERVAL")); err == nil && d > 0 {
+		renditionInterval = d
+	}
+	server.Renditions.Start(renditionInterval)
 
 	server.Router = mux.NewRouter()
 
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// GetOpenProducts list the public products for anonymous visitors,
+// with the same filters, sorts and cursors as GetProducts
+func (server *Server) GetOpenProducts(w http.ResponseWriter, r *http.Request) {
+
+	query, err := productQueryFromRequest(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	query.PublicOnly = true
+
+	product := models.Product{}
+	page, err := product.FindProducts(server.DB, query)
+	if err == models.ErrInvalidSort || err == models.ErrInvalidCursor || err == models.ErrCategoryNotFound {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	err = models.LoadProductDetails(server.DB, page.Products)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !server.convertPrices(w, r, page.Products) {
+		return
+	}
+
+	products := []models.CatalogProduct{}
+	for _, p := range page.Products {
+		products = append(products, models.CatalogView(p))
+	}
+	responses.JSON(w, http.StatusOK, pageResponse(r, products, page.Total, page.Next, page.Prev))
+}
+
+// GetOpenProduct get a public product for anonymous visitors
+func (server *Server) GetOpenProduct(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	pid, err := uuid.Parse(vars["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	product := models.Product{}
+
+	productReceived, err := product.FindOpenProductByID(server.DB, pid)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("Product not found"))
+		return
+	}
+	err = server.loadProductDetails(productReceived)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	products := []models.Product{*productReceived}
+	if !server.convertPrices(w, r, products) {
+		return
+	}
+	responses.JSON(w, http.StatusOK, models.CatalogView(products[0]))
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/arikardnoir/asiwaju/api/utils/formaterror"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// CreateCategory create a Category, below the parent_id when one is given
+func (server *Server) CreateCategory(w http.ResponseWriter, r *http.Request) {
+
+	category, ok := readCategory(w, r)
+	if !ok {
+		return
+	}
+	category.ID = uuid.Must(uuid.NewRandom())
+
+	categoryCreated, err := category.SaveCategory(server.DB)
+	if err != nil {
+		server.categoryError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%s", r.Host, r.URL.Path, categoryCreated.ID))
+	responses.JSON(w, http.StatusCreated, categoryCreated)
+}
+
+// GetCategories list every Category, each one after its parent
+func (server *Server) GetCategories(w http.ResponseWriter, r *http.Request) {
+
+	category := models.Category{}
+
+	categories, err := category.FindAllCategories(server.DB)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, categories)
+}
+
+// GetCategory get a Category with its breadcrumb and children
+func (server *Server) GetCategory(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	cid, err := uuid.Parse(vars["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	category := models.Category{}
+
+	categoryReceived, err := category.FindCategoryByID(server.DB, cid)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	breadcrumb, err := categoryReceived.Breadcrumb(server.DB)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	children, err := categoryReceived.FindCategoryChildren(server.DB, cid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, struct {
+		*models.Category
+		Breadcrumb []models.CategoryCrumb `json:"breadcrumb"`
+		Children   *[]models.Category     `json:"children"`
+	}{categoryReceived, breadcrumb, children})
+}
+
+// UpdateCategory rename a Category or move it, with its subtree, below another parent
+func (server *Server) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	cid, err := uuid.Parse(vars["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	category, ok := readCategory(w, r)
+	if !ok {
+		return
+	}
+
+	categoryUpdated, err := category.UpdateACategory(server.DB, cid)
+	if err != nil {
+		server.categoryError(w, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, categoryUpdated)
+}
+
+// DeleteCategory delete a Category without children, its products are left uncategorized
+func (server *Server) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	cid, err := uuid.Parse(vars["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+	category := models.Category{ActorID: &claims.UserID}
+
+	deleted, err := category.DeleteACategory(server.DB, cid)
+	if err == models.ErrCategoryHasChildren {
+		responses.ERROR(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	if deleted == 0 {
+		responses.ERROR(w, http.StatusNotFound, models.ErrCategoryNotFound)
+		return
+	}
+	w.Header().Set("Entity", cid.String())
+	responses.JSON(w, http.StatusNoContent, "")
+}
+
+// readCategory read and validate the Category of the request body
+func readCategory(w http.ResponseWriter, r *http.Request) (*models.Category, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return nil, false
+	}
+	category := models.Category{}
+	err = json.Unmarshal(body, &category)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return nil, false
+	}
+	category.Prepare()
+	err = category.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return nil, false
+	}
+	return &category, true
+}
+
+func (server *Server) categoryError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrCategoryNotFound:
+		responses.ERROR(w, http.StatusNotFound, err)
+	case models.ErrCategoryCycle, models.ErrParentCategoryNotFound:
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+	default:
+		responses.ERROR(w, http.StatusInternalServerError, formaterror.FormatError(err.Error()))
+	}
+}
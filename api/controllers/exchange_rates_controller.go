@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arikardnoir/asiwaju/api/exchange"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
+	"github.com/arikardnoir/asiwaju/api/responses"
+)
+
+// GetExchangeRates list the rates prices are converted with
+func (server *Server) GetExchangeRates(w http.ResponseWriter, r *http.Request) {
+
+	rates, err := server.exchangeRates().Rates()
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, rates)
+}
+
+// UploadExchangeRates replace every rate by the ones of the body, {"base": "USD", "rates": {"BRL": 5.25}}
+func (server *Server) UploadExchangeRates(w http.ResponseWriter, r *http.Request) {
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	rates := exchange.Rates{}
+	err = json.Unmarshal(body, &rates)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	rates.Prepare()
+	err = rates.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	err = server.exchangeRates().Upload(rates)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, rates)
+}
+
+// exchangeRates the provider of the server, the one of the database when none was configured
+func (server *Server) exchangeRates() *exchange.Provider {
+	server.exchangeRatesOnce.Do(func() {
+		if server.ExchangeRates == nil {
+			server.ExchangeRates = exchange.NewProvider(exchange.NewDBSource(server.DB), 0)
+		}
+	})
+	return server.ExchangeRates
+}
+
+// convertPrices add the converted prices to the products when the request asks for a currency,
+// with ?currency=XXX or an Accept-Currency header. An unknown or unconvertible ?currency is
+// refused, the header is only a preference and products it cannot convert keep their price.
+// It reports false when the response was already written with an error.
+func (server *Server) convertPrices(w http.ResponseWriter, r *http.Request, products []models.Product) bool {
+	w.Header().Add("Vary", "Accept-Currency")
+
+	if currency := r.URL.Query().Get("currency"); currency != "" {
+		if !money.ValidCurrency(currency) {
+			responses.ERROR(w, http.StatusBadRequest, money.ErrUnknownCurrency)
+			return false
+		}
+		err := models.ConvertProductPrices(products, currency, server.exchangeRates())
+		if err == money.ErrNoExchangeRate || err == money.ErrUnknownCurrency {
+			responses.ERROR(w, http.StatusUnprocessableEntity, err)
+			return false
+		}
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return false
+		}
+		return true
+	}
+
+	rates, err := server.exchangeRates().Rates()
+	if err != nil {
+		log.Printf("cannot read the exchange rates: %v", err)
+		return true
+	}
+	for _, currency := range acceptedCurrencies(r.Header.Get("Accept-Currency")) {
+		if rates.Has(currency) {
+			_ = models.ConvertProductPrices(products, currency, rates)
+			return true
+		}
+	}
+	return true
+}
+
+// acceptedCurrencies the currencies of an Accept-Currency header such as "EUR, USD;q=0.5",
+// most wanted first, leaving out unknown codes and q=0
+func acceptedCurrencies(header string) []string {
+	type accepted struct {
+		currency string
+		q        float64
+	}
+	list := []accepted{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		currency := strings.ToUpper(strings.TrimSpace(fields[0]))
+		if !money.ValidCurrency(currency) {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = value
+				}
+			}
+		}
+		if q > 0 {
+			list = append(list, accepted{currency, q})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].q > list[j].q })
+	currencies := []string{}
+	for _, a := range list {
+		currencies = append(currencies, a.currency)
+	}
+	return currencies
+}
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/google/uuid"
+)
+
+// variantStockLevel the counts of one variant in the inventory response
+type variantStockLevel struct {
+	VariantID  uuid.UUID                `json:"variant_id"`
+	SKU        string                   `json:"sku"`
+	Attributes models.VariantAttributes `json:"attributes"`
+	models.StockLevel
+}
+
+// GetInventory get the stock, reserved and available units of a Product and its variants
+func (server *Server) GetInventory(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	variant := models.ProductVariant{}
+	variants, err := variant.FindProductVariants(server.DB, product.ID)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	levels := []variantStockLevel{}
+	for _, v := range *variants {
+		levels = append(levels, variantStockLevel{
+			VariantID:  v.ID,
+			SKU:        v.SKU,
+			Attributes: v.Attributes,
+			StockLevel: models.StockLevel{Stock: v.Stock, Reserved: v.Reserved, Available: v.Stock - v.Reserved},
+		})
+	}
+	responses.JSON(w, http.StatusOK, struct {
+		ProductID uuid.UUID `json:"product_id"`
+		models.StockLevel
+		Variants []variantStockLevel `json:"variants"`
+	}{
+		ProductID:  product.ID,
+		StockLevel: models.StockLevel{Stock: product.Stock, Reserved: product.Reserved, Available: product.Stock - product.Reserved},
+		Variants:   levels,
+	})
+}
+
+// GetStockMovements list the latest stock movements of a Product and its variants
+func (server *Server) GetStockMovements(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid Limit"))
+			return
+		}
+		limit = n
+	}
+	movement := models.StockMovement{}
+
+	movements, err := movement.FindStockMovements(server.DB, product.ID, limit)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, movements)
+}
+
+// CreateStockMovement receive units into the stock of a Product or variant, or adjust it
+func (server *Server) CreateStockMovement(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	movement := models.StockMovement{}
+	err = json.Unmarshal(body, &movement)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	movement.Prepare()
+	err = movement.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	movement.ProductID = product.ID
+	movement.ActorID = &claims.UserID
+	movement.ReservationID = nil
+
+	movementCreated, err := movement.SaveStockMovement(server.DB)
+	if err != nil {
+		stockError(w, err)
+		return
+	}
+	responses.JSON(w, http.StatusCreated, movementCreated)
+}
+
+// stockError answer the errors of the stock and reservation changes
+func stockError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrVariantNotFound, models.ErrReservationNotFound:
+		responses.ERROR(w, http.StatusNotFound, err)
+	case models.ErrInsufficientStock, models.ErrReservationClosed, models.ErrReservationExpired:
+		responses.ERROR(w, http.StatusConflict, err)
+	case models.ErrInvalidQuantity:
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+	default:
+		responses.ERROR(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/responses"
+)
+
+// JWKS publish the public keys other services verify our tokens with
+func (server *Server) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	responses.JSON(w, http.StatusOK, auth.Keys().JWKS())
+}
+
+// RotateKeys let an admin start signing with a new key, the old one keeps verifying until it expires
+func (server *Server) RotateKeys(w http.ResponseWriter, r *http.Request) {
+	key, err := auth.Keys().Rotate()
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusCreated, map[string]interface{}{
+		"kid":        key.ID,
+		"alg":        key.Method.Alg(),
+		"created_at": key.CreatedAt,
+	})
+}
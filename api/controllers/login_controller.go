@@ -2,17 +2,25 @@ package controllers
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/logging"
 	"github.com/arikardnoir/asiwaju/api/models"
 	"github.com/arikardnoir/asiwaju/api/responses"
-	"github.com/arikardnoir/asiwaju/api/utils/formaterror"
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrInvalidCredentials the one answer to a login with a wrong email or password
+var ErrInvalidCredentials = errors.New("Invalid email or password")
+
 // Login that make login
 func (server *Server) Login(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
@@ -34,25 +42,115 @@ func (server *Server) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := clientIP(r)
+	if server.loginBlocked(w, user.Email, ip) {
+		return
+	}
+
 	token, users, err := server.SignIn(user.Email, user.Password)
+	if err == ErrTwoFactorRequired {
+		challengeToken, err := auth.CreateChallengeToken(users.ID)
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		responses.JSON(w, http.StatusOK, map[string]interface{}{
+			"two_factor_required": true,
+			"challenge_token":     challengeToken,
+			"expires_in":          int64(auth.ChallengeTokenTTL / time.Second),
+		})
+		return
+	}
+	if errors.Is(err, ErrInvalidCredentials) {
+		server.loginFailed(user.Email, ip)
+		fields := logging.Fields{"email": user.Email, "ip": ip}
+		var signInErr *signInError
+		if errors.As(err, &signInErr) {
+			fields["reason"] = signInErr.reason
+			if signInErr.err != nil {
+				fields["error"] = signInErr.err
+			}
+		}
+		logging.Warn("login_failed", fields)
+		responses.ERROR(w, http.StatusUnauthorized, ErrInvalidCredentials)
+		return
+	}
+	if err != nil {
+		logging.Error("login_failed", logging.Fields{"email": user.Email, "ip": ip, "reason": "internal_error", "error": err})
+		responses.ERROR(w, http.StatusInternalServerError, errors.New("Internal Server Error"))
+		return
+	}
+
+	server.loginSucceeded(user.Email, ip)
+
+	response, err := server.loginResponse(users, token)
 	if err != nil {
-		formattedError := formaterror.FormatError(err.Error())
-		fmt.Println(formattedError)
-		responses.ERROR(w, http.StatusUnprocessableEntity, formattedError)
+		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	responseuser := models.SanitizeUser(users)
+	responses.JSON(w, http.StatusOK, response)
+}
+
+// loginResponse the answer to a successful login, with a refresh token of a new family
+func (server *Server) loginResponse(user models.User, token string) (map[string]interface{}, error) {
+	refreshToken, err := server.IssueRefreshToken(user.ID, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+
+	responseuser := models.SanitizeUser(user)
+
+	return map[string]interface{}{
+		"data":          responseuser,
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int64(auth.AccessTokenTTL() / time.Second),
+	}, nil
+}
+
+// RefreshToken exchange a refresh token for a new access token and a new refresh token
+func (server *Server) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	request := struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if request.RefreshToken == "" {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required Refresh Token"))
+		return
+	}
+
+	token, refreshToken, err := server.RotateRefreshToken(request.RefreshToken)
+	if err == models.ErrRefreshTokenInvalid || err == models.ErrRefreshTokenReused {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
 
 	response := map[string]interface{}{
-		"data":  responseuser,
-		"token": token,
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int64(auth.AccessTokenTTL() / time.Second),
 	}
 
 	responses.JSON(w, http.StatusOK, response)
 }
 
-// SignIn that make sign in
+// SignIn that make sign in, ErrTwoFactorRequired means the password was right but 2FA is on.
+// An unknown email and a wrong password give the same ErrInvalidCredentials and take the same time,
+// so the answer does not tell which emails are registered.
 func (server *Server) SignIn(email, password string) (string, models.User, error) {
 
 	var err error
@@ -60,19 +158,144 @@ func (server *Server) SignIn(email, password string) (string, models.User, error
 	user := models.User{}
 
 	err = server.DB.Debug().Model(models.User{}).Where("email = ?", email).Take(&user).Error
+	if gorm.IsRecordNotFoundError(err) {
+		// Compare anyway, so a missing user costs as much as a wrong password
+		_ = models.VerifyPassword(dummyPasswordHash(), password)
+		return "", models.User{}, &signInError{reason: "unknown_email"}
+	}
 	if err != nil {
-		return "", user, err
+		return "", models.User{}, err
 	}
 
 	err = models.VerifyPassword(user.Password, password)
-	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
-		return "", user, err
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return "", models.User{}, &signInError{reason: "wrong_password"}
+	}
+	if err != nil {
+		return "", models.User{}, &signInError{reason: "password_check_failed", err: err}
+	}
+
+	// With 2FA the password alone gets no token, only a challenge for the second step
+	if user.TOTPEnabled {
+		return "", user, ErrTwoFactorRequired
 	}
 
-	token, err := auth.CreateToken(user.ID)
+	token, err := auth.CreateToken(user.ID, user.Role, user.EmailVerified)
 	if err != nil {
 		return "", user, err
 	}
 
 	return token, user, nil
 }
+
+// signInError a failed login. It reads as ErrInvalidCredentials, the reason is only for the logs.
+type signInError struct {
+	reason string
+	err    error
+}
+
+func (e *signInError) Error() string {
+	return ErrInvalidCredentials.Error()
+}
+
+func (e *signInError) Unwrap() error {
+	return ErrInvalidCredentials
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// dummyPasswordHash a hash with the cost of real ones, compared when the user does not exist
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		hash, err := models.Hash(uuid.Must(uuid.NewRandom()).String())
+		if err != nil {
+			log.Fatalf("cannot hash the dummy password: %v", err)
+		}
+		dummyHash = hash
+	})
+	return string(dummyHash)
+}
+
+// IssueRefreshToken create and store a refresh token for the user.
+// A uuid.Nil family starts a new family, as on login.
+func (server *Server) IssueRefreshToken(userID, familyID uuid.UUID) (string, error) {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	if familyID == uuid.Nil {
+		familyID = uuid.Must(uuid.NewRandom())
+	}
+	refreshToken := models.RefreshToken{
+		ID:        uuid.Must(uuid.NewRandom()),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(auth.RefreshTokenTTL()),
+	}
+	_, err = refreshToken.SaveRefreshToken(server.DB)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken validate a refresh token and replace it with a new one of the same family.
+// Presenting a token that was already rotated revokes the whole family.
+func (server *Server) RotateRefreshToken(presented string) (string, string, error) {
+
+	current := models.RefreshToken{}
+	_, err := current.FindRefreshTokenByHash(server.DB, auth.HashToken(presented))
+	if err != nil {
+		return "", "", err
+	}
+
+	if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		return "", "", models.ErrRefreshTokenInvalid
+	}
+	if current.RotatedAt != nil {
+		_, err = current.RevokeRefreshTokenFamily(server.DB, current.FamilyID)
+		if err != nil {
+			return "", "", err
+		}
+		return "", "", models.ErrRefreshTokenReused
+	}
+
+	user := models.User{}
+	_, err = user.FindUserByID(server.DB, current.UserID)
+	if err != nil {
+		return "", "", models.ErrRefreshTokenInvalid
+	}
+
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	next := models.RefreshToken{
+		ID:        uuid.Must(uuid.NewRandom()),
+		UserID:    current.UserID,
+		FamilyID:  current.FamilyID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(auth.RefreshTokenTTL()),
+	}
+	_, err = current.RotateRefreshToken(server.DB, &next)
+	if err == models.ErrRefreshTokenReused {
+		_, revokeErr := current.RevokeRefreshTokenFamily(server.DB, current.FamilyID)
+		if revokeErr != nil {
+			return "", "", revokeErr
+		}
+		return "", "", err
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := auth.CreateToken(user.ID, user.Role, user.EmailVerified)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, token, nil
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/arikardnoir/asiwaju/api/logging"
+	"github.com/arikardnoir/asiwaju/api/loginguard"
+	"github.com/arikardnoir/asiwaju/api/responses"
+)
+
+// loginBlocked answer 423 for a locked account and 429 for a client that has to slow down.
+// Without a LoginGuard every attempt is allowed.
+func (server *Server) loginBlocked(w http.ResponseWriter, account, ip string) bool {
+	if server.LoginGuard == nil {
+		return false
+	}
+	err := server.LoginGuard.Check(account, ip)
+	if err == nil {
+		return false
+	}
+	blocked, ok := err.(*loginguard.BlockedError)
+	if !ok {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return true
+	}
+	logging.Warn("login_blocked", logging.Fields{"email": account, "ip": ip, "locked": blocked.Locked, "retry_after": blocked.RetryAfter.String()})
+	w.Header().Set("Retry-After", loginguard.RetryAfterSeconds(blocked.RetryAfter))
+	if blocked.Locked {
+		responses.ERROR(w, http.StatusLocked, blocked)
+		return true
+	}
+	responses.ERROR(w, http.StatusTooManyRequests, blocked)
+	return true
+}
+
+// loginFailed count a failed login attempt
+func (server *Server) loginFailed(account, ip string) {
+	if server.LoginGuard == nil {
+		return
+	}
+	err := server.LoginGuard.Fail(account, ip)
+	if err != nil {
+		logging.Error("login_guard_failed", logging.Fields{"email": account, "ip": ip, "error": err})
+	}
+}
+
+// loginSucceeded forget the failed attempts of the account
+func (server *Server) loginSucceeded(account, ip string) {
+	if server.LoginGuard == nil {
+		return
+	}
+	err := server.LoginGuard.Succeed(account, ip)
+	if err != nil {
+		logging.Error("login_guard_failed", logging.Fields{"email": account, "ip": ip, "error": err})
+	}
+}
+
+// clientIP the address of the client. X-Forwarded-For is only trusted behind
+// a proxy that sets it, as configured by LOGIN_TRUST_PROXY.
+func clientIP(r *http.Request) string {
+	if os.Getenv("LOGIN_TRUST_PROXY") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/google/uuid"
+)
+
+// Logout revoke the token of the request and, when given, the refresh token family
+func (server *Server) Logout(w http.ResponseWriter, r *http.Request) {
+
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+	if claims.Kind == auth.KindAPIKey {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("API keys are revoked, not logged out"))
+		return
+	}
+
+	request := struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &request)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+
+	err = auth.RevokeToken(claims.TokenID, claims.UserID, claims.ExpiresAt)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if request.RefreshToken != "" {
+		refreshToken := models.RefreshToken{}
+		_, err = refreshToken.FindRefreshTokenByHash(server.DB, auth.HashToken(request.RefreshToken))
+		if err == nil && refreshToken.UserID == claims.UserID {
+			_, err = refreshToken.RevokeRefreshTokenFamily(server.DB, refreshToken.FamilyID)
+		}
+		if err != nil && err != models.ErrRefreshTokenInvalid {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	responses.JSON(w, http.StatusNoContent, "")
+}
+
+// LogoutAll revoke every token and refresh token of the authenticated user
+func (server *Server) LogoutAll(w http.ResponseWriter, r *http.Request) {
+
+	uid, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	err = server.RevokeAllTokens(uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusNoContent, "")
+}
+
+// RevokeAllTokens make every access and refresh token of the user stop working
+func (server *Server) RevokeAllTokens(uid uuid.UUID) error {
+	err := auth.RevokeUserTokens(uid)
+	if err != nil {
+		return err
+	}
+	refreshToken := models.RefreshToken{}
+	_, err = refreshToken.RevokeUserRefreshTokens(server.DB, uid)
+	return err
+}
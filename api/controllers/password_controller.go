@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/mailer"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/google/uuid"
+)
+
+const defaultPasswordResetTTL = time.Hour
+
+// ForgotPassword email a password reset link. The answer is the same whether or not the email is registered.
+func (server *Server) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	request := struct {
+		Email string `json:"email"`
+	}{}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if strings.TrimSpace(request.Email) == "" {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required Email"))
+		return
+	}
+
+	user := models.User{}
+	_, err = user.FindUserByEmail(server.DB, strings.TrimSpace(request.Email))
+	if err == nil {
+		err = server.sendPasswordReset(r, user)
+		if err != nil {
+			log.Printf("cannot send password reset to %s: %v", user.ID, err)
+		}
+	}
+
+	responses.JSON(w, http.StatusAccepted, map[string]string{
+		"message": "If the email is registered, a password reset link was sent to it",
+	})
+}
+
+// ResetPassword set a new password with a token from ForgotPassword, every session of the user ends
+func (server *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	request := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if request.Token == "" {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required Token"))
+		return
+	}
+	if request.Password == "" {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required Password"))
+		return
+	}
+
+	user := models.User{}
+	uid, err := user.ResetPassword(server.DB, auth.HashToken(request.Token), request.Password)
+	if err == models.ErrOneTimeTokenInvalid {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	err = server.RevokeAllTokens(uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(w, http.StatusOK, map[string]string{
+		"message": "Password updated, please login again",
+	})
+}
+
+func (server *Server) sendPasswordReset(r *http.Request, user models.User) error {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	ttl := defaultPasswordResetTTL
+	if d, err := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL")); err == nil && d > 0 {
+		ttl = d
+	}
+	oneTimeToken := models.OneTimeToken{
+		ID:        uuid.Must(uuid.NewRandom()),
+		UserID:    user.ID,
+		Purpose:   models.PurposePasswordReset,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	_, err = oneTimeToken.SaveOneTimeToken(server.DB)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s?token=%s", passwordResetURL(r), url.QueryEscape(token))
+	return server.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nSomeone asked to reset the password of your account. "+
+			"If it was you, open the link below within %s:\n\n%s\n\n"+
+			"If it was not you, you can ignore this email.\n", user.Fullname, ttl, link),
+	})
+}
+
+// sendMail send an email through the configured mailer
+func (server *Server) sendMail(msg mailer.Message) error {
+	if server.Mailer == nil {
+		return errors.New("No mailer configured")
+	}
+	return server.Mailer.Send(msg)
+}
+
+// passwordResetURL the page of the frontend the reset link opens, PASSWORD_RESET_URL or
+// /reset-password under APP_URL. The page asks for the new password and sends it with the
+// token to POST /password/reset.
+func passwordResetURL(r *http.Request) string {
+	if page := os.Getenv("PASSWORD_RESET_URL"); page != "" {
+		return page
+	}
+	return appURL(r) + "/reset-password"
+}
+
+// appURL the base URL links in emails point to, APP_URL or the host of the request
+func appURL(r *http.Request) string {
+	if base := os.Getenv("APP_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
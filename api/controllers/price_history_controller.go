@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/google/uuid"
+)
+
+// defaultPriceMoversPeriod the period of the price movers report when it gives no from
+const defaultPriceMoversPeriod = 30 * 24 * time.Hour
+
+// GetPriceHistory the changes of the price of a Product between from and to, paged with after and
+// limit, or with an interval of day, week or month, its prices in buckets of that interval. The
+// buckets go from, or models.DefaultPriceBuckets back but not before the creation of the product,
+// to now, or to.
+func (server *Server) GetPriceHistory(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	values := r.URL.Query()
+	from, err := timeParam(values.Get("from"), false)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid from"))
+		return
+	}
+	to, err := timeParam(values.Get("to"), true)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid to"))
+		return
+	}
+	if from != nil && to != nil && to.Before(*from) {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid Range"))
+		return
+	}
+
+	interval := values.Get("interval")
+	if interval == "" {
+		var after *uuid.UUID
+		if value := values.Get("after"); value != "" {
+			id, err := uuid.Parse(value)
+			if err != nil {
+				responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid After"))
+				return
+			}
+			after = &id
+		}
+		limit := 0
+		if value := values.Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid Limit"))
+				return
+			}
+		}
+		changes, more, err := models.FindPriceChanges(server.DB, product.ID, from, to, after, limit)
+		if err == models.ErrPriceChangeNotFound {
+			responses.ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		links := map[string]interface{}{"next": nil}
+		if more {
+			next := r.URL.Query()
+			next.Set("after", (*changes)[len(*changes)-1].ID.String())
+			links["next"] = r.URL.Path + "?" + next.Encode()
+		}
+		responses.JSON(w, http.StatusOK, map[string]interface{}{
+			"data":  changes,
+			"links": links,
+		})
+		return
+	}
+
+	end := time.Now()
+	if to != nil {
+		end = *to
+	}
+	start := product.CreatedAt
+	if from != nil {
+		start = *from
+	} else if earliest := models.DefaultPriceHistoryStart(end, interval); earliest.After(start) {
+		start = earliest
+	}
+	buckets, err := models.AggregatePriceHistory(server.DB, product.ID, start, end, interval)
+	if err == models.ErrInvalidPriceInterval || err == models.ErrPriceRangeTooLong {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, map[string]interface{}{
+		"interval": interval,
+		"from":     start,
+		"to":       end,
+		"data":     buckets,
+	})
+}
+
+// GetPriceMovers the products whose price moved the most between from and to, the last 30 days
+// by default. Admins may report on the products of any owner.
+func (server *Server) GetPriceMovers(w http.ResponseWriter, r *http.Request) {
+
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+	oid := claims.UserID
+	values := r.URL.Query()
+	if ownerID := values.Get("owner_id"); ownerID != "" && claims.HasRole(models.RoleAdmin) {
+		oid, err = uuid.Parse(ownerID)
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	end := time.Now()
+	to, err := timeParam(values.Get("to"), true)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid to"))
+		return
+	}
+	if to != nil {
+		end = *to
+	}
+	start := end.Add(-defaultPriceMoversPeriod)
+	from, err := timeParam(values.Get("from"), false)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid from"))
+		return
+	}
+	if from != nil {
+		start = *from
+	}
+	if end.Before(start) {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid Range"))
+		return
+	}
+	limit := 10
+	if value := values.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid Limit"))
+			return
+		}
+	}
+
+	movers, err := models.FindPriceMovers(server.DB, oid, start, end, limit)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, map[string]interface{}{
+		"from": start,
+		"to":   end,
+		"data": movers,
+	})
+}
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/arikardnoir/asiwaju/api/spreadsheet"
+	"github.com/google/uuid"
+)
+
+// exportFlushRows how many rows are written between flushes to the client
+const exportFlushRows = 500
+
+// exportContentTypes the formats products are exported in, with their content type
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"xlsx":   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// productWriter writes exported products in a format
+type productWriter interface {
+	WriteProduct(models.ProductExport) error
+	Flush() error
+	Close() error
+}
+
+// ExportProducts stream every product matching the filters of the listing as a CSV, NDJSON or
+// XLSX download. Rows are written as they are read from the database.
+func (server *Server) ExportProducts(w http.ResponseWriter, r *http.Request) {
+
+	product := models.Product{}
+
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	// Admins may export the products of any owner
+	oid := claims.UserID
+	if ownerID := r.URL.Query().Get("owner_id"); ownerID != "" && claims.HasRole(models.RoleAdmin) {
+		oid, err = uuid.Parse(ownerID)
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid format, expected csv, ndjson or xlsx"))
+		return
+	}
+	query, err := productQueryFromRequest(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	query.OwnerID = oid
+
+	// Nothing is sent before the first product, or the end of an empty export, so a query the
+	// database refuses still gets an error response
+	var writer productWriter
+	started := false
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="products-%s.%s"`, time.Now().UTC().Format("20060102"), format))
+		w.WriteHeader(http.StatusOK)
+		var err error
+		writer, err = newProductWriter(format, w)
+		return err
+	}
+	rows := 0
+	err = product.EachProduct(server.DB, query, func(p models.Product) error {
+		err := start()
+		if err != nil {
+			return err
+		}
+		err = writer.WriteProduct(models.ExportView(p))
+		if err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows == 0 {
+			return writer.Flush()
+		}
+		return nil
+	})
+	if !started {
+		if err == models.ErrInvalidSort || err == models.ErrCategoryNotFound {
+			responses.ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		err = start()
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		// The status is sent, breaking the connection is the only way left to tell the client
+		// the file is incomplete
+		log.Printf("cannot export the products of %s: %v", oid, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func newProductWriter(format string, w http.ResponseWriter) (productWriter, error) {
+	switch format {
+	case "ndjson":
+		return &ndjsonProductWriter{w: w, encoder: json.NewEncoder(w)}, nil
+	case "xlsx":
+		sheet, err := spreadsheet.NewXLSXWriter(w, "Products")
+		if err != nil {
+			return nil, err
+		}
+		return &xlsxProductWriter{w: w, sheet: sheet}, sheet.WriteRow(columnCells())
+	default:
+		records := csv.NewWriter(w)
+		return &csvProductWriter{w: w, records: records}, records.Write(models.ProductColumns)
+	}
+}
+
+// flushClient send what was written so far, when the connection allows it
+func flushClient(w io.Writer) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func columnCells() []interface{} {
+	cells := []interface{}{}
+	for _, column := range models.ProductColumns {
+		cells = append(cells, column)
+	}
+	return cells
+}
+
+type csvProductWriter struct {
+	w       http.ResponseWriter
+	records *csv.Writer
+}
+
+func (c *csvProductWriter) WriteProduct(e models.ProductExport) error {
+	return c.records.Write(e.CSVRecord())
+}
+
+func (c *csvProductWriter) Flush() error {
+	c.records.Flush()
+	flushClient(c.w)
+	return c.records.Error()
+}
+
+func (c *csvProductWriter) Close() error {
+	return c.Flush()
+}
+
+// ndjsonProductWriter writes one ProductExport per line, the price as the API writes it
+type ndjsonProductWriter struct {
+	w       http.ResponseWriter
+	encoder *json.Encoder
+}
+
+func (n *ndjsonProductWriter) WriteProduct(e models.ProductExport) error {
+	return n.encoder.Encode(e)
+}
+
+func (n *ndjsonProductWriter) Flush() error {
+	flushClient(n.w)
+	return nil
+}
+
+func (n *ndjsonProductWriter) Close() error {
+	return n.Flush()
+}
+
+type xlsxProductWriter struct {
+	w     http.ResponseWriter
+	sheet *spreadsheet.XLSXWriter
+}
+
+func (x *xlsxProductWriter) WriteProduct(e models.ProductExport) error {
+	return x.sheet.WriteRow(e.Record())
+}
+
+func (x *xlsxProductWriter) Flush() error {
+	err := x.sheet.Flush()
+	flushClient(x.w)
+	return err
+}
+
+func (x *xlsxProductWriter) Close() error {
+	err := x.sheet.Close()
+	flushClient(x.w)
+	return err
+}
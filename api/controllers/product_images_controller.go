@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // decode the size of GIF uploads
+	_ "image/jpeg" // decode the size of JPEG uploads
+	_ "image/png"  // decode the size of PNG uploads
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/renditions"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/arikardnoir/asiwaju/api/storage"
+	"github.com/arikardnoir/asiwaju/api/utils/formaterror"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// imageTypes the image types accepted for upload, by sniffed content type, with their file extension
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var (
+	errImageTooLarge   = errors.New("Image Too Large")
+	errTooManyPixels   = errors.New("Image Has Too Many Pixels")
+	errUnsupportedType = errors.New("Unsupported Image Type, expected JPEG, PNG, GIF or WebP")
+)
+
+// UploadProductImage add an image to the Product, from the image field of a multipart form.
+// The type is sniffed from the content, whatever the client declared. Its renditions are made
+// in the background.
+func (server *Server) UploadProductImage(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	blobs, ok := server.blobStore(w)
+	if !ok {
+		return
+	}
+
+	maxBytes := imageMaxBytes()
+	// Leave room for the rest of the form around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		if strings.Contains(err.Error(), "http: request body too large") {
+			responses.ERROR(w, http.StatusRequestEntityTooLarge, errImageTooLarge)
+			return
+		}
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required image file"))
+		return
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	if int64(len(data)) > maxBytes {
+		responses.ERROR(w, http.StatusRequestEntityTooLarge, errImageTooLarge)
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, ok := imageTypes[contentType]
+	if !ok {
+		responses.ERROR(w, http.StatusUnsupportedMediaType, errUnsupportedType)
+		return
+	}
+	productImage := models.ProductImage{
+		ID:          uuid.Must(uuid.NewRandom()),
+		ProductID:   product.ID,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+	// The standard library cannot decode WebP, its size stays unknown
+	if contentType != "image/webp" {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Invalid Image"))
+			return
+		}
+		if renditions.TooManyPixels(config.Width, config.Height) {
+			responses.ERROR(w, http.StatusRequestEntityTooLarge, errTooManyPixels)
+			return
+		}
+		productImage.Width, productImage.Height = config.Width, config.Height
+	}
+	productImage.Key = fmt.Sprintf("products/%s/%s%s", product.ID, productImage.ID, ext)
+	productImage.URL = blobs.URL(productImage.Key)
+
+	err = blobs.Put(productImage.Key, data, contentType)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	imageCreated, err := productImage.SaveImage(server.DB)
+	if err != nil {
+		server.deleteBlob(blobs, productImage.Key)
+		if err == models.ErrTooManyImages {
+			responses.ERROR(w, http.StatusConflict, err)
+			return
+		}
+		responses.ERROR(w, http.StatusInternalServerError, formaterror.FormatError(err.Error()))
+		return
+	}
+	if server.Renditions != nil {
+		server.Renditions.Notify()
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%s", r.Host, r.URL.Path, imageCreated.ID))
+	responses.JSON(w, http.StatusCreated, imageCreated)
+}
+
+// GetProductImages list the images of the Product, in their order
+func (server *Server) GetProductImages(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	productImage := models.ProductImage{}
+
+	images, err := productImage.FindProductImages(server.DB, product.ID)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, images)
+}
+
+// ReorderProductImages put the images of the Product in a new order, {"image_ids": [...]}
+// lists every image of the product, the cover first
+func (server *Server) ReorderProductImages(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	order := struct {
+		ImageIDs []uuid.UUID `json:"image_ids"`
+	}{}
+	err = json.Unmarshal(body, &order)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	productImage := models.ProductImage{}
+
+	images, err := productImage.ReorderImages(server.DB, product.ID, order.ImageIDs)
+	if err == models.ErrInvalidImageOrder {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, images)
+}
+
+// DeleteProductImage delete an image of the Product and its blob
+func (server *Server) DeleteProductImage(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	iid, err := uuid.Parse(mux.Vars(r)["imageID"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	productImage := models.ProductImage{}
+
+	imageReceived, err := productImage.FindImageByID(server.DB, product.ID, iid)
+	if err == models.ErrImageNotFound {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	_, err = imageReceived.DeleteAnImage(server.DB, product.ID, iid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	if server.Blobs != nil {
+		for _, key := range imageReceived.BlobKeys() {
+			server.deleteBlob(server.Blobs, key)
+		}
+	}
+	w.Header().Set("Entity", iid.String())
+	responses.JSON(w, http.StatusNoContent, "")
+}
+
+// RegenerateProductImages make the renditions of the images of the Product again, in the background
+func (server *Server) RegenerateProductImages(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	server.regenerateImages(w, &product.ID)
+}
+
+// RegenerateAllImages make the renditions of every image again, in the background, after the
+// size presets changed
+func (server *Server) RegenerateAllImages(w http.ResponseWriter, r *http.Request) {
+	server.regenerateImages(w, nil)
+}
+
+func (server *Server) regenerateImages(w http.ResponseWriter, pid *uuid.UUID) {
+	if server.Renditions == nil {
+		responses.ERROR(w, http.StatusInternalServerError, errors.New("No image renditions configured"))
+		return
+	}
+	queued, err := models.MarkImagesPending(server.DB, pid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	server.Renditions.Notify()
+	responses.JSON(w, http.StatusAccepted, map[string]int64{"queued": queued})
+}
+
+// blobStore the configured BlobStore, it writes the error when there is none
+func (server *Server) blobStore(w http.ResponseWriter) (storage.BlobStore, bool) {
+	if server.Blobs == nil {
+		responses.ERROR(w, http.StatusInternalServerError, errors.New("No blob store configured"))
+		return nil, false
+	}
+	return server.Blobs, true
+}
+
+// deleteBlob delete a blob nothing points to anymore, a failure only leaves an orphan behind
+func (server *Server) deleteBlob(blobs storage.BlobStore, key string) {
+	err := blobs.Delete(key)
+	if err != nil {
+		log.Printf("cannot delete the blob %s: %v", key, err)
+	}
+}
+
+// imageMaxBytes the largest image accepted for upload, IMAGE_MAX_BYTES or 10 MiB
+func imageMaxBytes() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("IMAGE_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 10 << 20
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// importSlots how many import jobs run at the same time, the others wait for a slot
+var importSlots = make(chan struct{}, 2)
+
+var errImportTooLarge = errors.New("Import Too Large")
+
+// ImportProducts create or update products from the CSV or NDJSON body. The query string gives
+// the format, when the content type does not, the key to update products by, dry_run and async.
+// Files larger than IMPORT_SYNC_MAX_BYTES always run as a job.
+func (server *Server) ImportProducts(w http.ResponseWriter, r *http.Request) {
+
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+	values := r.URL.Query()
+	productImport := models.ProductImport{
+		OwnerID: claims.UserID,
+		Format:  importFormat(r),
+		Key:     values.Get("key"),
+		DryRun:  values.Get("dry_run") == "true",
+	}
+	err = productImport.Validate()
+	if err == models.ErrInvalidImportFormat {
+		responses.ERROR(w, http.StatusUnsupportedMediaType, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// The file is kept on disk, a job reads it after the request is over
+	file, err := ioutil.TempFile("", "import-*")
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	size, err := io.Copy(file, http.MaxBytesReader(w, r.Body, importMaxBytes()))
+	if err != nil {
+		if strings.Contains(err.Error(), "http: request body too large") {
+			responses.ERROR(w, http.StatusRequestEntityTooLarge, errImportTooLarge)
+			return
+		}
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if values.Get("async") == "true" || size > importSyncMaxBytes() {
+		job := models.ImportJob{
+			ID:      uuid.Must(uuid.NewRandom()),
+			OwnerID: claims.UserID,
+			Status:  models.ImportJobQueued,
+			Format:  productImport.Format,
+			Key:     productImport.Key,
+		}
+		job.DryRun = productImport.DryRun
+		jobCreated, err := job.SaveImportJob(server.DB)
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		keep = true
+		go server.runImportJob(jobCreated, productImport, file)
+		w.Header().Set("Location", fmt.Sprintf("%s%s/%s", r.Host, r.URL.Path, jobCreated.ID))
+		responses.JSON(w, http.StatusAccepted, jobCreated)
+		return
+	}
+
+	report, err := productImport.Run(server.DB, file)
+	if _, ok := err.(models.ImportFileError); ok {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, report)
+}
+
+// GetImportJob the status and the report of an import job
+func (server *Server) GetImportJob(w http.ResponseWriter, r *http.Request) {
+
+	jid, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+	job := models.ImportJob{}
+
+	jobReceived, err := job.FindImportJobByID(server.DB, jid)
+	if err == nil && !canManage(claims, jobReceived.OwnerID) {
+		err = models.ErrImportJobNotFound
+	}
+	if err == models.ErrImportJobNotFound {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, jobReceived)
+}
+
+// runImportJob import the file of the job, saving the report after each batch, and remove the file
+func (server *Server) runImportJob(job *models.ImportJob, productImport models.ProductImport, file *os.File) {
+	defer os.Remove(file.Name())
+	defer file.Close()
+	importSlots <- struct{}{}
+	defer func() { <-importSlots }()
+
+	save := func() {
+		err := job.UpdateImportJob(server.DB)
+		if err != nil {
+			log.Printf("cannot save the import job %s: %v", job.ID, err)
+		}
+	}
+	job.Status = models.ImportJobRunning
+	save()
+	productImport.Progress = func(report models.ImportReport) {
+		job.ImportReport = report
+		save()
+	}
+
+	report, err := productImport.Run(server.DB, file)
+	job.ImportReport = *report
+	job.Status = models.ImportJobDone
+	if err != nil {
+		job.Status = models.ImportJobFailed
+		job.Error = err.Error()
+	}
+	now := time.Now()
+	job.FinishedAt = &now
+	save()
+}
+
+// importFormat the format of the import, from the format parameter or the content type
+func importFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return strings.ToLower(format)
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "text/csv":
+		return models.ImportFormatCSV
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return models.ImportFormatNDJSON
+	}
+	return ""
+}
+
+// importMaxBytes the largest file accepted for import, IMPORT_MAX_BYTES or 50 MiB
+func importMaxBytes() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("IMPORT_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 50 << 20
+}
+
+// importSyncMaxBytes the largest file imported during the request, IMPORT_SYNC_MAX_BYTES or 1 MiB
+func importSyncMaxBytes() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("IMPORT_SYNC_MAX_BYTES"), 10, 64); err == nil && n >= 0 {
+		return n
+	}
+	return 1 << 20
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
+	"github.com/google/uuid"
+)
+
+// productQueryFromRequest read the filters, sort and page of a product listing from the query string:
+// brand, model, min_price, max_price, exp_from, exp_to, category, tag, sort, limit and cursor.
+// The price range is in price_currency, DEFAULT_CURRENCY when it is not given.
+func productQueryFromRequest(r *http.Request) (models.ProductQuery, error) {
+	values := r.URL.Query()
+	query := models.ProductQuery{
+		Brand: values.Get("brand"),
+		Model: values.Get("model"),
+		Sort:  values.Get("sort"),
+	}
+
+	var err error
+	currency := values.Get("price_currency")
+	if currency == "" {
+		currency = money.DefaultCurrency()
+	}
+	if query.MinPrice, err = moneyParam(values.Get("min_price"), currency); err != nil {
+		return query, errors.New("Invalid min_price")
+	}
+	if query.MaxPrice, err = moneyParam(values.Get("max_price"), currency); err != nil {
+		return query, errors.New("Invalid max_price")
+	}
+	if query.ExpFrom, err = timeParam(values.Get("exp_from"), false); err != nil {
+		return query, errors.New("Invalid exp_from")
+	}
+	if query.ExpTo, err = timeParam(values.Get("exp_to"), true); err != nil {
+		return query, errors.New("Invalid exp_to")
+	}
+	if limit := values.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 {
+			return query, errors.New("Invalid limit")
+		}
+	}
+	if category := values.Get("category"); category != "" {
+		cid, err := uuid.Parse(category)
+		if err != nil {
+			return query, errors.New("Invalid category")
+		}
+		query.CategoryID = &cid
+	}
+	query.Tag = values.Get("tag")
+	if cursor := values.Get("cursor"); cursor != "" {
+		query.Cursor, err = models.DecodeProductCursor(cursor)
+		if err != nil {
+			return query, err
+		}
+	}
+	return query, nil
+}
+
+// pageResponse the body of a paginated listing, with links to the pages around it
+func pageResponse(r *http.Request, data interface{}, total int, next, prev *models.ProductCursor) map[string]interface{} {
+	links := map[string]interface{}{"next": nil, "prev": nil}
+	if next != nil {
+		links["next"] = pageLink(r, next)
+	}
+	if prev != nil {
+		links["prev"] = pageLink(r, prev)
+	}
+	return map[string]interface{}{
+		"data":  data,
+		"total": total,
+		"links": links,
+	}
+}
+
+// pageLink the URL of the request, moved to the cursor
+func pageLink(r *http.Request, cursor *models.ProductCursor) string {
+	values := r.URL.Query()
+	values.Set("cursor", cursor.Encode())
+	return r.URL.Path + "?" + values.Encode()
+}
+
+func moneyParam(value, currency string) (*money.Money, error) {
+	if value == "" {
+		return nil, nil
+	}
+	m, err := money.Parse(value, currency)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// timeParam read an RFC 3339 time, or a plain date. With endOfDay a plain date
+// means its last instant, so a range ending on that date includes it.
+func timeParam(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return &t, nil
+	}
+	t, err = time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return &t, nil
+}
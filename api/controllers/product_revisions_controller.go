@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/arikardnoir/asiwaju/api/utils/formaterror"
+	"github.com/gorilla/mux"
+)
+
+// GetProductRevisions list the revisions of a Product, the last first, before and limit page
+// through them. Given from and to revision numbers, it is the field-level diff between them.
+func (server *Server) GetProductRevisions(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	values := r.URL.Query()
+	if values.Get("from") != "" || values.Get("to") != "" {
+		from, err := strconv.Atoi(values.Get("from"))
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid from"))
+			return
+		}
+		to, err := strconv.Atoi(values.Get("to"))
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid to"))
+			return
+		}
+		changes, err := models.DiffProductRevisions(server.DB, *product, from, to)
+		if err == models.ErrRevisionNotFound {
+			responses.ERROR(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+		responses.JSON(w, http.StatusOK, map[string]interface{}{
+			"from":    from,
+			"to":      to,
+			"changes": changes,
+		})
+		return
+	}
+
+	before, limit := 0, 0
+	if value := values.Get("before"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid Before"))
+			return
+		}
+		before = n
+	}
+	if value := values.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid Limit"))
+			return
+		}
+		limit = n
+	}
+	revision := models.ProductRevision{}
+
+	revisions, err := revision.FindProductRevisions(server.DB, product.ID, before, limit)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, revisions)
+}
+
+// RevertProduct set a Product back to how it was at one of its revisions, which records a new one
+func (server *Server) RevertProduct(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	number, err := strconv.Atoi(mux.Vars(r)["rev"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid Revision"))
+		return
+	}
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	product.ActorID = &claims.UserID
+	productReverted, err := product.RevertAProduct(server.DB, product.ID, number)
+	if err == models.ErrRevisionNotFound {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	if err == models.ErrCategoryNotFound {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err == models.ErrRevertSKUTaken || err == models.ErrRevertExternalIDTaken {
+		responses.ERROR(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		formattedError := formaterror.FormatError(err.Error())
+		responses.ERROR(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+	err = server.loadProductResponse(productReverted)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, productReverted)
+}
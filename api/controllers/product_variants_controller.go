@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/arikardnoir/asiwaju/api/utils/formaterror"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// CreateVariant add a variant to a Product the user manages
+func (server *Server) CreateVariant(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	variant, ok := readVariant(w, r, product)
+	if !ok {
+		return
+	}
+	variant.ID = uuid.Must(uuid.NewRandom())
+
+	variantCreated, err := variant.SaveVariant(server.DB)
+	if err != nil {
+		formattedError := formaterror.FormatError(err.Error())
+		responses.ERROR(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%s", r.Host, r.URL.Path, variantCreated.ID))
+	responses.JSON(w, http.StatusCreated, variantCreated)
+}
+
+// GetVariants list the variants of a Product the user manages
+func (server *Server) GetVariants(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	variant := models.ProductVariant{}
+
+	variants, err := variant.FindProductVariants(server.DB, product.ID)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, variants)
+}
+
+// GetVariant get a variant of a Product the user manages
+func (server *Server) GetVariant(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	vid, err := uuid.Parse(mux.Vars(r)["variantID"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	variant := models.ProductVariant{}
+
+	variantReceived, err := variant.FindVariantByID(server.DB, product.ID, vid)
+	if err == models.ErrVariantNotFound {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, variantReceived)
+}
+
+// UpdateVariant update a variant of a Product the user manages
+func (server *Server) UpdateVariant(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	vid, err := uuid.Parse(mux.Vars(r)["variantID"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	variant, ok := readVariant(w, r, product)
+	if !ok {
+		return
+	}
+
+	variantUpdated, err := variant.UpdateAVariant(server.DB, product.ID, vid)
+	if err == models.ErrVariantNotFound {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		formattedError := formaterror.FormatError(err.Error())
+		responses.ERROR(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+	responses.JSON(w, http.StatusOK, variantUpdated)
+}
+
+// DeleteVariant delete a variant of a Product the user manages
+func (server *Server) DeleteVariant(w http.ResponseWriter, r *http.Request) {
+
+	product, ok := server.managedProduct(w, r)
+	if !ok {
+		return
+	}
+	vid, err := uuid.Parse(mux.Vars(r)["variantID"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	variant := models.ProductVariant{}
+
+	deleted, err := variant.DeleteAVariant(server.DB, product.ID, vid)
+	if err == models.ErrVariantReserved {
+		responses.ERROR(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	if deleted == 0 {
+		responses.ERROR(w, http.StatusNotFound, models.ErrVariantNotFound)
+		return
+	}
+	w.Header().Set("Entity", vid.String())
+	responses.JSON(w, http.StatusNoContent, "")
+}
+
+// managedProduct the Product of the route, when the token may manage it
+func (server *Server) managedProduct(w http.ResponseWriter, r *http.Request) (*models.Product, bool) {
+	pid, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return nil, false
+	}
+	product, err := server.findManagedProduct(claims, pid)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("Product not found"))
+		return nil, false
+	}
+	return product, true
+}
+
+// readVariant read and validate the variant of the request body
+func readVariant(w http.ResponseWriter, r *http.Request, product *models.Product) (*models.ProductVariant, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return nil, false
+	}
+	variant := models.ProductVariant{}
+	err = json.Unmarshal(body, &variant)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return nil, false
+	}
+	variant.ProductID = product.ID
+	variant.Prepare()
+	err = variant.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return nil, false
+	}
+	if !variant.PriceOverride.IsZero() && variant.PriceOverride.Currency != product.Price.Currency {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Price Override Must Be In The Product Currency"))
+		return nil, false
+	}
+	return &variant, true
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/arikardnoir/asiwaju/api/auth"
 	"github.com/arikardnoir/asiwaju/api/models"
@@ -13,6 +15,7 @@ import (
 	"github.com/arikardnoir/asiwaju/api/utils/formaterror"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 )
 
 func (server *Server) CreateProduct(w http.ResponseWriter, r *http.Request) {
@@ -38,17 +41,36 @@ func (server *Server) CreateProduct(w http.ResponseWriter, r *http.Request) {
 
 	product.OwnerID = oid
 	product.Prepare()
+	product.ActorID = &oid
 	err = product.Validate("")
 	if err != nil {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
 	}
-	productCreated, err := product.SaveProduct(server.DB)
+	tags, err := server.productRelations(&product)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	var productCreated *models.Product
+	err = server.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		productCreated, err = product.SaveProduct(tx)
+		if err != nil {
+			return err
+		}
+		return replaceProductTags(tx, productCreated, tags)
+	})
 	if err != nil {
 		formattedError := formaterror.FormatError(err.Error())
 		responses.ERROR(w, http.StatusInternalServerError, formattedError)
 		return
 	}
+	err = server.loadProductResponse(productCreated)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
 	w.Header().Set("Lacation", fmt.Sprintf("%s%s/%d", r.Host, r.URL.Path, productCreated.ID))
 	responses.JSON(w, http.StatusCreated, productCreated)
 }
@@ -57,18 +79,106 @@ func (server *Server) GetProducts(w http.ResponseWriter, r *http.Request) {
 
 	product := models.Product{}
 
-	oid, err := auth.ExtractTokenID(r)
+	claims, err := auth.ExtractClaims(r)
 	if err != nil {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
 
-	products, err := product.FindAllProducts(server.DB, oid)
+	// Admins may list the products of any owner
+	oid := claims.UserID
+	if ownerID := r.URL.Query().Get("owner_id"); ownerID != "" && claims.HasRole(models.RoleAdmin) {
+		oid, err = uuid.Parse(ownerID)
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	query, err := productQueryFromRequest(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	query.OwnerID = oid
+
+	page, err := product.FindProducts(server.DB, query)
+	if err == models.ErrInvalidSort || err == models.ErrInvalidCursor || err == models.ErrCategoryNotFound {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	err = models.LoadProductDetails(server.DB, page.Products)
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
-	responses.JSON(w, http.StatusOK, products)
+	if !server.convertPrices(w, r, page.Products) {
+		return
+	}
+	responses.JSON(w, http.StatusOK, pageResponse(r, page.Products, page.Total, page.Next, page.Prev))
+}
+
+// SearchProducts full-text search of the products, most relevant first.
+// q is the text, lang the language its words are stemmed in, limit the number of results.
+func (server *Server) SearchProducts(w http.ResponseWriter, r *http.Request) {
+
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	values := r.URL.Query()
+	search := models.ProductSearch{
+		OwnerID: claims.UserID,
+		Text:    strings.TrimSpace(values.Get("q")),
+		Lang:    values.Get("lang"),
+	}
+	if search.Text == "" {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("Required q"))
+		return
+	}
+	if !models.ValidSearchLanguage(search.Lang) {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid lang"))
+		return
+	}
+	if limit := values.Get("limit"); limit != "" {
+		search.Limit, err = strconv.Atoi(limit)
+		if err != nil || search.Limit < 1 {
+			responses.ERROR(w, http.StatusBadRequest, errors.New("Invalid limit"))
+			return
+		}
+	}
+	// Admins may search the products of any owner
+	if ownerID := values.Get("owner_id"); ownerID != "" && claims.HasRole(models.RoleAdmin) {
+		search.OwnerID, err = uuid.Parse(ownerID)
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	product := models.Product{}
+	products, err := product.SearchProducts(server.DB, search)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	err = models.LoadProductDetails(server.DB, *products)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !server.convertPrices(w, r, *products) {
+		return
+	}
+	responses.JSON(w, http.StatusOK, map[string]interface{}{
+		"data": products,
+	})
 }
 
 func (server *Server) GetProduct(w http.ResponseWriter, r *http.Request) {
@@ -79,20 +189,27 @@ func (server *Server) GetProduct(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusBadRequest, err)
 		return
 	}
-	product := models.Product{}
-
-	oid, err := auth.ExtractTokenID(r)
+	claims, err := auth.ExtractClaims(r)
 	if err != nil {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
 
-	productReceived, err := product.FindProductByID(server.DB, pid, oid)
+	productReceived, err := server.findManagedProduct(claims, pid)
 	if err != nil {
 		responses.ERROR(w, http.StatusNotFound, err)
 		return
 	}
-	responses.JSON(w, http.StatusOK, productReceived)
+	err = server.loadProductDetails(productReceived)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	products := []models.Product{*productReceived}
+	if !server.convertPrices(w, r, products) {
+		return
+	}
+	responses.JSON(w, http.StatusOK, products[0])
 }
 
 func (server *Server) UpdateProduct(w http.ResponseWriter, r *http.Request) {
@@ -105,14 +222,13 @@ func (server *Server) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	//Check if the auth token is valid and  get the user id from it
-	oid, err := auth.ExtractTokenID(r)
+	claims, err := auth.ExtractClaims(r)
 	if err != nil {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
 
-	productFinder := models.Product{}
-	productChecker, err := productFinder.FindProductByID(server.DB, pid, oid)
+	productChecker, err := server.findManagedProduct(claims, pid)
 	if err != nil {
 		formattedError := formaterror.FormatError(err.Error())
 		responses.ERROR(w, http.StatusInternalServerError, formattedError)
@@ -120,7 +236,7 @@ func (server *Server) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// If a user attempt to update a Product not belonging to him
-	if oid != productChecker.OwnerID {
+	if !canManage(claims, productChecker.OwnerID) {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
@@ -141,18 +257,37 @@ func (server *Server) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	product.Prepare()
+	product.ActorID = &claims.UserID
 	err = product.Validate("update")
 	if err != nil {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
 	}
+	tags, err := server.productRelations(&product)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
 
-	productUpdated, err := product.UpdateAProduct(server.DB, pid)
+	var productUpdated *models.Product
+	err = server.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		productUpdated, err = product.UpdateAProduct(tx, pid)
+		if err != nil {
+			return err
+		}
+		return replaceProductTags(tx, productUpdated, tags)
+	})
 	if err != nil {
 		formattedError := formaterror.FormatError(err.Error())
 		responses.ERROR(w, http.StatusInternalServerError, formattedError)
 		return
 	}
+	err = server.loadProductResponse(productUpdated)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
 	responses.JSON(w, http.StatusOK, productUpdated)
 }
 
@@ -168,7 +303,7 @@ func (server *Server) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Is this user authenticated?
-	oid, err := auth.ExtractTokenID(r)
+	claims, err := auth.ExtractClaims(r)
 	if err != nil {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
@@ -182,12 +317,14 @@ func (server *Server) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Is the authenticated user, the owner of this Product?
-	if oid != product.OwnerID {
+	// Is the authenticated user, the owner of this Product or an admin?
+	if !canManage(claims, product.OwnerID) {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
-	_, err = product.DeleteAProduct(server.DB, pid, oid)
+	// The images stay in the blob store until the trash is purged
+	product.ActorID = &claims.UserID
+	_, err = product.DeleteAProduct(server.DB, pid, product.OwnerID)
 	if err != nil {
 		responses.ERROR(w, http.StatusBadRequest, err)
 		return
@@ -196,20 +333,52 @@ func (server *Server) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	responses.JSON(w, http.StatusNoContent, "")
 }
 
-func (server *Server) GetOpenProduct(w http.ResponseWriter, r *http.Request) {
+// findManagedProduct find a Product the token may manage, admins see every owner's products
+func (server *Server) findManagedProduct(claims auth.Claims, pid uuid.UUID) (*models.Product, error) {
+	product := models.Product{}
+	if claims.HasRole(models.RoleAdmin) {
+		return product.FindAnyProductByID(server.DB, pid)
+	}
+	return product.FindProductByID(server.DB, pid, claims.UserID)
+}
 
-	vars := mux.Vars(r)
-	pid, err := uuid.Parse(vars["id"])
-	if err != nil {
-		responses.ERROR(w, http.StatusBadRequest, err)
-		return
+// productRelations check the category of the product exists, and find the tags of its tag_ids
+func (server *Server) productRelations(product *models.Product) ([]models.Tag, error) {
+	if product.CategoryID != nil {
+		category := models.Category{}
+		_, err := category.FindCategoryByID(server.DB, *product.CategoryID)
+		if err != nil {
+			return nil, err
+		}
 	}
-	product := models.Product{}
+	if product.TagIDs == nil {
+		return nil, nil
+	}
+	tag := models.Tag{}
+	return tag.FindTagsByID(server.DB, product.TagIDs)
+}
 
-	productReceived, err := product.FindProductByID(server.DB, pid, uuid.Nil)
+// replaceProductTags replace the tags of the product when tag_ids were given, in the
+// transaction the product is written in
+func replaceProductTags(tx *gorm.DB, product *models.Product, tags []models.Tag) error {
+	if tags == nil {
+		return nil
+	}
+	return product.ReplaceProductTags(tx, tags)
+}
+
+// loadProductResponse load the tags and breadcrumb of the response of a written product
+func (server *Server) loadProductResponse(product *models.Product) error {
+	product.TagIDs = nil
+	return server.loadProductDetails(product)
+}
+
+func (server *Server) loadProductDetails(product *models.Product) error {
+	products := []models.Product{*product}
+	err := models.LoadProductDetails(server.DB, products)
 	if err != nil {
-		responses.ERROR(w, http.StatusInternalServerError, err)
-		return
+		return err
 	}
-	responses.JSON(w, http.StatusOK, productReceived)
+	*product = products[0]
+	return nil
 }
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultReservationTTL = 15 * time.Minute
+	maxReservationTTL     = 24 * time.Hour
+)
+
+// CreateReservation set units of a Product or variant aside for ttl_seconds, or
+// RESERVATION_TTL when none is given, until they are confirmed or released. Any user may
+// reserve units of a public product, e.g. during their checkout.
+func (server *Server) CreateReservation(w http.ResponseWriter, r *http.Request) {
+
+	product, claims, ok := server.reservableProduct(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	request := struct {
+		models.StockReservation
+		TTLSeconds int `json:"ttl_seconds"`
+	}{}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	reservation := request.StockReservation
+	reservation.Prepare()
+	err = reservation.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	ttl := reservationTTL()
+	if request.TTLSeconds != 0 {
+		ttl = time.Duration(request.TTLSeconds) * time.Second
+		if ttl <= 0 || ttl > maxReservationTTL {
+			responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Invalid TTL"))
+			return
+		}
+	}
+	reservation.ProductID = product.ID
+	reservation.UserID = claims.UserID
+
+	reservationCreated, err := reservation.ReserveStock(server.DB, ttl)
+	if err != nil {
+		stockError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%s", r.Host, r.URL.Path, reservationCreated.ID))
+	responses.JSON(w, http.StatusCreated, reservationCreated)
+}
+
+// GetReservation get a reservation of a Product, for the user who made it or the owner
+func (server *Server) GetReservation(w http.ResponseWriter, r *http.Request) {
+
+	reservationReceived, ok := server.ownReservation(w, r)
+	if !ok {
+		return
+	}
+	responses.JSON(w, http.StatusOK, reservationReceived)
+}
+
+// ConfirmReservation sell the units of a pending reservation
+func (server *Server) ConfirmReservation(w http.ResponseWriter, r *http.Request) {
+	server.closeReservation(w, r, models.ReservationConfirmed)
+}
+
+// ReleaseReservation give the units of a pending reservation back
+func (server *Server) ReleaseReservation(w http.ResponseWriter, r *http.Request) {
+	server.closeReservation(w, r, models.ReservationReleased)
+}
+
+// closeReservation confirm or release a reservation, only the user who made it or the owner may
+func (server *Server) closeReservation(w http.ResponseWriter, r *http.Request, status string) {
+	found, ok := server.ownReservation(w, r)
+	if !ok {
+		return
+	}
+	reservation := models.StockReservation{}
+
+	var reservationClosed *models.StockReservation
+	var err error
+	if status == models.ReservationConfirmed {
+		reservationClosed, err = reservation.ConfirmReservation(server.DB, found.ProductID, found.ID)
+	} else {
+		reservationClosed, err = reservation.ReleaseReservation(server.DB, found.ProductID, found.ID)
+	}
+	if err != nil {
+		stockError(w, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, reservationClosed)
+}
+
+// reservableProduct the Product of the request when the user may reserve units of it: a public
+// product, or one they manage
+func (server *Server) reservableProduct(w http.ResponseWriter, r *http.Request) (*models.Product, auth.Claims, bool) {
+	pid, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return nil, auth.Claims{}, false
+	}
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return nil, auth.Claims{}, false
+	}
+	product, err := server.findManagedProduct(claims, pid)
+	if err != nil {
+		open := models.Product{}
+		product, err = open.FindOpenProductByID(server.DB, pid)
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("Product not found"))
+		return nil, auth.Claims{}, false
+	}
+	return product, claims, true
+}
+
+// ownReservation the reservation of the request when it was made by the user, or is of a
+// product they manage. The others are not found.
+func (server *Server) ownReservation(w http.ResponseWriter, r *http.Request) (*models.StockReservation, bool) {
+	product, claims, ok := server.reservableProduct(w, r)
+	if !ok {
+		return nil, false
+	}
+	rid, err := uuid.Parse(mux.Vars(r)["reservationID"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	reservation := models.StockReservation{}
+	reservationFound, err := reservation.FindReservationByID(server.DB, product.ID, rid)
+	if err != nil {
+		stockError(w, err)
+		return nil, false
+	}
+	if reservationFound.UserID != claims.UserID && !canManage(claims, product.OwnerID) {
+		stockError(w, models.ErrReservationNotFound)
+		return nil, false
+	}
+	return reservationFound, true
+}
+
+// startReservationSweeper give back the units of expired reservations every interval
+func (server *Server) startReservationSweeper(interval time.Duration) {
+	go func() {
+		for now := range time.Tick(interval) {
+			count, err := models.ExpireStockReservations(server.DB, now)
+			if err != nil {
+				log.Printf("cannot expire the stock reservations: %v", err)
+				continue
+			}
+			if count > 0 {
+				log.Printf("expired %d stock reservations", count)
+			}
+		}
+	}()
+}
+
+func reservationTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("RESERVATION_TTL")); err == nil && d > 0 && d <= maxReservationTTL {
+		return d
+	}
+	return defaultReservationTTL
+}
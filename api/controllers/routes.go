@@ -1,26 +1,129 @@
 package controllers
 
-import "github.com/arikardnoir/asiwaju/api/middlewares"
+import (
+	"net/http"
+
+	"github.com/arikardnoir/asiwaju/api/middlewares"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/storage"
+)
 
 func (s *Server) initializeRoutes() {
 
 	// Home Route
 	s.Router.HandleFunc("/", middlewares.SetMiddlewareJSON(s.Home)).Methods("GET")
 
+	// Keys Routes
+	s.Router.HandleFunc("/.well-known/jwks.json", middlewares.SetMiddlewareJSON(s.JWKS)).Methods("GET")
+	s.Router.HandleFunc("/admin/keys/rotate", middlewares.SetMiddlewareJSON(middlewares.RequireRole(models.RoleAdmin)(s.RotateKeys))).Methods("POST")
+
 	// Login Route
 	s.Router.HandleFunc("/login", middlewares.SetMiddlewareJSON(s.Login)).Methods("POST")
+	s.Router.HandleFunc("/login/2fa", middlewares.SetMiddlewareJSON(s.LoginTwoFactor)).Methods("POST")
+	s.Router.HandleFunc("/token/refresh", middlewares.SetMiddlewareJSON(s.RefreshToken)).Methods("POST")
+	s.Router.HandleFunc("/logout", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.Logout))).Methods("POST")
+	s.Router.HandleFunc("/logout-all", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.LogoutAll))).Methods("POST")
+
+	// Password Routes
+	s.Router.HandleFunc("/password/forgot", middlewares.SetMiddlewareJSON(s.ForgotPassword)).Methods("POST")
+	s.Router.HandleFunc("/password/reset", middlewares.SetMiddlewareJSON(s.ResetPassword)).Methods("POST")
+
+	// Email verification Routes
+	s.Router.HandleFunc("/verify-email", middlewares.SetMiddlewareJSON(s.VerifyEmail)).Methods("GET")
+	s.Router.HandleFunc("/verify-email/resend", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.ResendVerificationEmail))).Methods("POST")
 
 	//Users routes
 	s.Router.HandleFunc("/users", middlewares.SetMiddlewareJSON(s.CreateUser)).Methods("POST")
-	s.Router.HandleFunc("/users", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.GetUsers))).Methods("GET")
+	s.Router.HandleFunc("/users", middlewares.SetMiddlewareJSON(middlewares.RequireRole(models.RoleAdmin)(s.GetUsers))).Methods("GET")
 	s.Router.HandleFunc("/users/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.GetUser))).Methods("GET")
-	s.Router.HandleFunc("/users/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.UpdateUser))).Methods("PUT")
+	s.Router.HandleFunc("/users/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireAccessToken(s.UpdateUser)))).Methods("PUT")
+	s.Router.HandleFunc("/users/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireAccessToken(s.DeleteUser)))).Methods("DELETE")
+	s.Router.HandleFunc("/users/{id}/role", middlewares.SetMiddlewareJSON(middlewares.RequireRole(models.RoleAdmin)(s.UpdateUserRole))).Methods("PUT")
+
+	//Two factor authentication routes
+	s.Router.HandleFunc("/users/{id}/2fa/enroll", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireAccessToken(s.EnrollTwoFactor)))).Methods("POST")
+	s.Router.HandleFunc("/users/{id}/2fa/confirm", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireAccessToken(s.ConfirmTwoFactor)))).Methods("POST")
+	s.Router.HandleFunc("/users/{id}/2fa/recovery-codes", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireAccessToken(s.RegenerateRecoveryCodes)))).Methods("POST")
+	s.Router.HandleFunc("/users/{id}/2fa", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireAccessToken(s.DisableTwoFactor)))).Methods("DELETE")
+
+	//API keys routes
+	s.Router.HandleFunc("/users/{id}/api-keys", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireAccessToken(s.CreateAPIKey)))).Methods("POST")
+	s.Router.HandleFunc("/users/{id}/api-keys", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireAccessToken(s.GetAPIKeys)))).Methods("GET")
+	s.Router.HandleFunc("/users/{id}/api-keys/{keyID}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireAccessToken(s.RevokeAPIKey)))).Methods("DELETE")
 
 	//Products routes
-	s.Router.HandleFunc("/products", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.CreateProduct))).Methods("POST")
-	s.Router.HandleFunc("/products", middlewares.SetMiddlewareJSON(s.GetProducts)).Methods("GET")
-	s.Router.HandleFunc("/products/{id}", middlewares.SetMiddlewareJSON(s.GetProduct)).Methods("GET")
-	s.Router.HandleFunc("/products/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(s.UpdateProduct))).Methods("PUT")
-	s.Router.HandleFunc("/products/{id}", middlewares.SetMiddlewareAuthentication(middlewares.SetMiddlewareAuthentication(s.DeleteProduct))).Methods("DELETE")
+	s.Router.HandleFunc("/products", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireVerifiedEmail(middlewares.RequireScope(models.ScopeProductsWrite)(s.CreateProduct))))).Methods("POST")
+	s.Router.HandleFunc("/products", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetProducts)))).Methods("GET")
+	s.Router.HandleFunc("/products/search", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.SearchProducts)))).Methods("GET")
+	s.Router.HandleFunc("/products/export", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.ExportProducts)))).Methods("GET")
+	s.Router.HandleFunc("/products/price-movers", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetPriceMovers)))).Methods("GET")
+	s.Router.HandleFunc("/products/import", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireVerifiedEmail(middlewares.RequireScope(models.ScopeProductsWrite)(s.ImportProducts))))).Methods("POST")
+	s.Router.HandleFunc("/products/import/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetImportJob)))).Methods("GET")
+	s.Router.HandleFunc("/products/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetProduct)))).Methods("GET")
+	s.Router.HandleFunc("/products/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.UpdateProduct)))).Methods("PUT")
+	s.Router.HandleFunc("/products/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.DeleteProduct)))).Methods("DELETE")
+
+	//Product revisions routes
+	s.Router.HandleFunc("/products/{id}/revisions", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetProductRevisions)))).Methods("GET")
+	s.Router.HandleFunc("/products/{id}/revisions/{rev}/restore", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.RevertProduct)))).Methods("POST")
+
+	//Price history routes
+	s.Router.HandleFunc("/products/{id}/price-history", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetPriceHistory)))).Methods("GET")
+
+	//Product variants routes
+	s.Router.HandleFunc("/products/{id}/variants", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.CreateVariant)))).Methods("POST")
+	s.Router.HandleFunc("/products/{id}/variants", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetVariants)))).Methods("GET")
+	s.Router.HandleFunc("/products/{id}/variants/{variantID}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetVariant)))).Methods("GET")
+	s.Router.HandleFunc("/products/{id}/variants/{variantID}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.UpdateVariant)))).Methods("PUT")
+	s.Router.HandleFunc("/products/{id}/variants/{variantID}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.DeleteVariant)))).Methods("DELETE")
+
+	//Product images routes
+	s.Router.HandleFunc("/products/{id}/images", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.UploadProductImage)))).Methods("POST")
+	s.Router.HandleFunc("/products/{id}/images", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetProductImages)))).Methods("GET")
+	s.Router.HandleFunc("/products/{id}/images/renditions", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.RegenerateProductImages)))).Methods("POST")
+	s.Router.HandleFunc("/products/{id}/images/order", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.ReorderProductImages)))).Methods("PUT")
+	s.Router.HandleFunc("/products/{id}/images/{imageID}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.DeleteProductImage)))).Methods("DELETE")
+	s.Router.HandleFunc("/admin/images/renditions", middlewares.SetMiddlewareJSON(middlewares.RequireRole(models.RoleAdmin)(s.RegenerateAllImages))).Methods("POST")
+
+	//Inventory routes
+	s.Router.HandleFunc("/products/{id}/inventory", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetInventory)))).Methods("GET")
+	s.Router.HandleFunc("/products/{id}/inventory/movements", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetStockMovements)))).Methods("GET")
+	s.Router.HandleFunc("/products/{id}/inventory/movements", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.CreateStockMovement)))).Methods("POST")
+	s.Router.HandleFunc("/products/{id}/reservations", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.CreateReservation)))).Methods("POST")
+	s.Router.HandleFunc("/products/{id}/reservations/{reservationID}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetReservation)))).Methods("GET")
+	s.Router.HandleFunc("/products/{id}/reservations/{reservationID}/confirm", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.ConfirmReservation)))).Methods("POST")
+	s.Router.HandleFunc("/products/{id}/reservations/{reservationID}/release", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.ReleaseReservation)))).Methods("POST")
+
+	//Trash routes, deleted products and users stay there until they are purged
+	s.Router.HandleFunc("/trash", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsRead)(s.GetTrash)))).Methods("GET")
+	s.Router.HandleFunc("/products/{id}/restore", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.RestoreProduct)))).Methods("POST")
+	s.Router.HandleFunc("/trash/users", middlewares.SetMiddlewareJSON(middlewares.RequireRole(models.RoleAdmin)(s.GetDeletedUsers))).Methods("GET")
+	s.Router.HandleFunc("/users/{id}/restore", middlewares.SetMiddlewareJSON(middlewares.RequireRole(models.RoleAdmin)(s.RestoreUser))).Methods("POST")
+
+	//Categories routes
+	s.Router.HandleFunc("/categories", middlewares.SetMiddlewareJSON(s.GetCategories)).Methods("GET")
+	s.Router.HandleFunc("/categories", middlewares.SetMiddlewareJSON(middlewares.RequireRole(models.RoleAdmin)(s.CreateCategory))).Methods("POST")
+	s.Router.HandleFunc("/categories/{id}", middlewares.SetMiddlewareJSON(s.GetCategory)).Methods("GET")
+	s.Router.HandleFunc("/categories/{id}", middlewares.SetMiddlewareJSON(middlewares.RequireRole(models.RoleAdmin)(s.UpdateCategory))).Methods("PUT")
+	s.Router.HandleFunc("/categories/{id}", middlewares.SetMiddlewareJSON(middlewares.RequireRole(models.RoleAdmin)(s.DeleteCategory))).Methods("DELETE")
+
+	//Tags routes
+	s.Router.HandleFunc("/tags", middlewares.SetMiddlewareJSON(s.GetTags)).Methods("GET")
+	s.Router.HandleFunc("/tags", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(middlewares.RequireScope(models.ScopeProductsWrite)(s.CreateTag)))).Methods("POST")
+	s.Router.HandleFunc("/tags/{id}", middlewares.SetMiddlewareJSON(middlewares.RequireRole(models.RoleAdmin)(s.UpdateTag))).Methods("PUT")
+	s.Router.HandleFunc("/tags/{id}", middlewares.SetMiddlewareJSON(middlewares.RequireRole(models.RoleAdmin)(s.DeleteTag))).Methods("DELETE")
+
+	//Catalog routes, open to anonymous visitors
+	s.Router.HandleFunc("/catalog", middlewares.SetMiddlewareJSON(s.GetOpenProducts)).Methods("GET")
+	s.Router.HandleFunc("/catalog/{id}", middlewares.SetMiddlewareJSON(s.GetOpenProduct)).Methods("GET")
+
+	//Uploaded files, when the blob store serves them itself
+	if media, ok := s.Blobs.(http.Handler); ok {
+		s.Router.PathPrefix(storage.MediaPath+"/").Handler(media).Methods("GET", "HEAD")
+	}
+
+	//Exchange rates routes, product reads convert prices with them
+	s.Router.HandleFunc("/exchange-rates", middlewares.SetMiddlewareJSON(s.GetExchangeRates)).Methods("GET")
+	s.Router.HandleFunc("/admin/exchange-rates", middlewares.SetMiddlewareJSON(middlewares.RequireRole(models.RoleAdmin)(s.UploadExchangeRates))).Methods("PUT")
 
 }
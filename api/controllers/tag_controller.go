@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/arikardnoir/asiwaju/api/utils/formaterror"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// CreateTag create a Tag, any signed in user may label their products with new tags
+func (server *Server) CreateTag(w http.ResponseWriter, r *http.Request) {
+
+	tag, ok := readTag(w, r)
+	if !ok {
+		return
+	}
+	tag.ID = uuid.Must(uuid.NewRandom())
+
+	tagCreated, err := tag.SaveTag(server.DB)
+	if err != nil {
+		formattedError := formaterror.FormatError(err.Error())
+		responses.ERROR(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%s", r.Host, r.URL.Path, tagCreated.ID))
+	responses.JSON(w, http.StatusCreated, tagCreated)
+}
+
+// GetTags list every Tag by name
+func (server *Server) GetTags(w http.ResponseWriter, r *http.Request) {
+
+	tag := models.Tag{}
+
+	tags, err := tag.FindAllTags(server.DB)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, tags)
+}
+
+// UpdateTag rename a Tag
+func (server *Server) UpdateTag(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	tid, err := uuid.Parse(vars["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tag, ok := readTag(w, r)
+	if !ok {
+		return
+	}
+
+	tagUpdated, err := tag.UpdateATag(server.DB, tid)
+	if err == models.ErrTagNotFound {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		formattedError := formaterror.FormatError(err.Error())
+		responses.ERROR(w, http.StatusInternalServerError, formattedError)
+		return
+	}
+	responses.JSON(w, http.StatusOK, tagUpdated)
+}
+
+// DeleteTag delete a Tag and take it off every product
+func (server *Server) DeleteTag(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	tid, err := uuid.Parse(vars["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	tag := models.Tag{}
+
+	deleted, err := tag.DeleteATag(server.DB, tid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	if deleted == 0 {
+		responses.ERROR(w, http.StatusNotFound, models.ErrTagNotFound)
+		return
+	}
+	w.Header().Set("Entity", tid.String())
+	responses.JSON(w, http.StatusNoContent, "")
+}
+
+// readTag read and validate the Tag of the request body
+func readTag(w http.ResponseWriter, r *http.Request) (*models.Tag, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return nil, false
+	}
+	tag := models.Tag{}
+	err = json.Unmarshal(body, &tag)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return nil, false
+	}
+	tag.Prepare()
+	err = tag.Validate()
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return nil, false
+	}
+	return &tag, true
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// defaultTrashRetention how long deleted products and users stay in the trash
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// GetTrash list the deleted products, until they are purged. Admins may list the trash of any
+// owner.
+func (server *Server) GetTrash(w http.ResponseWriter, r *http.Request) {
+
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+	oid := claims.UserID
+	if ownerID := r.URL.Query().Get("owner_id"); ownerID != "" && claims.HasRole(models.RoleAdmin) {
+		oid, err = uuid.Parse(ownerID)
+		if err != nil {
+			responses.ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	product := models.Product{}
+	products, err := product.FindDeletedProducts(server.DB, oid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, map[string]interface{}{
+		"data":      products,
+		"retention": trashRetention().String(),
+	})
+}
+
+// RestoreProduct take a deleted product out of the trash
+func (server *Server) RestoreProduct(w http.ResponseWriter, r *http.Request) {
+
+	pid, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	// Check if the Product is in the trash
+	product := models.Product{}
+	err = server.DB.Debug().Unscoped().Model(models.Product{}).Where("id = ? AND deleted_at IS NOT NULL", pid).Take(&product).Error
+	if err != nil || !canManage(claims, product.OwnerID) {
+		responses.ERROR(w, http.StatusNotFound, models.ErrNotInTrash)
+		return
+	}
+
+	product.ActorID = &claims.UserID
+	productRestored, err := product.RestoreAProduct(server.DB, pid, product.OwnerID)
+	if err == models.ErrNotInTrash {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	if err == models.ErrOwnerDeleted {
+		responses.ERROR(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	products := []models.Product{*productRestored}
+	err = models.LoadProductDetails(server.DB, products)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, products[0])
+}
+
+// GetDeletedUsers list the deleted users, until they are purged
+func (server *Server) GetDeletedUsers(w http.ResponseWriter, r *http.Request) {
+
+	user := models.User{}
+	users, err := user.FindDeletedUsers(server.DB)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, map[string]interface{}{
+		"data":      models.SanitizeUsers(*users),
+		"retention": trashRetention().String(),
+	})
+}
+
+// RestoreUser take a deleted user out of the trash, with the products deleted along with them
+func (server *Server) RestoreUser(w http.ResponseWriter, r *http.Request) {
+
+	uid, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+	user := models.User{ActorID: &claims.UserID}
+	userRestored, err := user.RestoreAUser(server.DB, uid)
+	if err == models.ErrNotInTrash {
+		responses.ERROR(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, models.SanitizeUser(*userRestored))
+}
+
+// startTrashPurger delete for good, every interval, what has been in the trash longer than the
+// retention
+func (server *Server) startTrashPurger(interval, retention time.Duration) {
+	go func() {
+		for now := range time.Tick(interval) {
+			server.purgeTrash(now.Add(-retention))
+		}
+	}()
+}
+
+// purgeTrash delete for good the products and users deleted before the time, and the blobs of
+// their images
+func (server *Server) purgeTrash(before time.Time) {
+	purge, err := models.PurgeTrash(server.DB, before)
+	if err != nil {
+		log.Printf("cannot purge the trash: %v", err)
+	}
+	// The rows are gone, the blobs of the images go after them
+	if server.Blobs != nil {
+		for _, key := range purge.BlobKeys {
+			server.deleteBlob(server.Blobs, key)
+		}
+	}
+	if purge.Products > 0 || purge.Users > 0 {
+		log.Printf("purged %d products and %d users from the trash", purge.Products, purge.Users)
+	}
+}
+
+// trashRetention how long deleted products and users are kept, TRASH_RETENTION or 30 days
+func trashRetention() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("TRASH_RETENTION")); err == nil && d > 0 {
+		return d
+	}
+	return defaultTrashRetention
+}
@@ -0,0 +1,345 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/logging"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultTOTPIssuer = "Asiwaju"
+	recoveryCodeCount = 10
+)
+
+var (
+	// ErrTwoFactorRequired the password was right, but the user has to give a second factor
+	ErrTwoFactorRequired = errors.New("Two Factor Authentication Required")
+	// ErrInvalidSecondFactor the TOTP code or recovery code was wrong or already used
+	ErrInvalidSecondFactor = errors.New("Invalid Code")
+)
+
+// secondFactor the body of the requests that need a TOTP code or a recovery code
+type secondFactor struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+// EnrollTwoFactor generate a TOTP secret for the user. 2FA is only on after ConfirmTwoFactor.
+func (server *Server) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := server.twoFactorOwner(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		responses.ERROR(w, http.StatusConflict, errors.New("Two Factor Authentication Already Enabled"))
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	err = user.SetTOTPSecret(server.DB, user.ID, secret)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = defaultTOTPIssuer
+	}
+	responses.JSON(w, http.StatusOK, map[string]string{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPURI(issuer, user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor turn 2FA on with a code of the enrolled secret, and return the recovery codes
+func (server *Server) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := server.twoFactorOwner(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		responses.ERROR(w, http.StatusConflict, errors.New("Two Factor Authentication Already Enabled"))
+		return
+	}
+	if user.TOTPSecret == "" {
+		responses.ERROR(w, http.StatusConflict, errors.New("Two Factor Authentication Not Enrolled"))
+		return
+	}
+	request, ok := readSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	counter, valid := auth.ValidateTOTP(user.TOTPSecret, request.Code, time.Now(), user.TOTPLastCounter)
+	if !valid {
+		responses.ERROR(w, http.StatusUnprocessableEntity, ErrInvalidSecondFactor)
+		return
+	}
+	err := user.EnableTOTP(server.DB, user.ID, counter)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	codes, err := server.newRecoveryCodes(user.ID)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, map[string]interface{}{
+		"totp_enabled":   true,
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replace the recovery codes, the old ones stop working
+func (server *Server) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := server.twoFactorOwner(w, r)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		responses.ERROR(w, http.StatusConflict, errors.New("Two Factor Authentication Not Enabled"))
+		return
+	}
+	request, ok := readSecondFactor(w, r)
+	if !ok {
+		return
+	}
+	err := server.verifySecondFactor(user, request)
+	if err == ErrInvalidSecondFactor {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	codes, err := server.newRecoveryCodes(user.ID)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor turn 2FA off. The user confirms with a code, admins may turn it off for
+// a user who lost their authenticator and their recovery codes.
+func (server *Server) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	uid, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+	if claims.Kind == auth.KindAPIKey {
+		responses.ERROR(w, http.StatusForbidden, errors.New("Forbidden"))
+		return
+	}
+	if !canManage(claims, uid) {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+	user := models.User{}
+	_, err = user.FindUserByID(server.DB, uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("User not found"))
+		return
+	}
+
+	if claims.UserID == uid && user.TOTPEnabled {
+		request, ok := readSecondFactor(w, r)
+		if !ok {
+			return
+		}
+		err = server.verifySecondFactor(user, request)
+		if err == ErrInvalidSecondFactor {
+			responses.ERROR(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	err = user.DisableTOTP(server.DB, uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	recoveryCode := models.RecoveryCode{}
+	err = recoveryCode.DeleteUserRecoveryCodes(server.DB, uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusNoContent, "")
+}
+
+// LoginTwoFactor the second step of a login with 2FA: exchange the challenge token
+// and a TOTP code, or a recovery code, for the tokens Login returns without 2FA
+func (server *Server) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+
+	request, ok := readSecondFactor(w, r)
+	if !ok {
+		return
+	}
+	uid, err := auth.ParseChallengeToken(request.ChallengeToken)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+	user := models.User{}
+	_, err = user.FindUserByID(server.DB, uid)
+	if err != nil || !user.TOTPEnabled {
+		responses.ERROR(w, http.StatusUnauthorized, auth.ErrChallengeInvalid)
+		return
+	}
+
+	// Codes are guessed like passwords, so they count against the same limits
+	ip := clientIP(r)
+	if server.loginBlocked(w, user.Email, ip) {
+		return
+	}
+	err = server.verifySecondFactor(user, request)
+	if err == ErrInvalidSecondFactor {
+		server.loginFailed(user.Email, ip)
+		logging.Warn("login_failed", logging.Fields{"email": user.Email, "ip": ip, "reason": "invalid_second_factor"})
+		responses.ERROR(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	server.loginSucceeded(user.Email, ip)
+
+	token, err := auth.CreateToken(user.ID, user.Role, user.EmailVerified)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	response, err := server.loginResponse(user, token)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, response)
+}
+
+// verifySecondFactor check the TOTP code, or else the recovery code, of the user.
+// Both are single-use.
+func (server *Server) verifySecondFactor(user models.User, request secondFactor) error {
+	if request.Code != "" {
+		counter, valid := auth.ValidateTOTP(user.TOTPSecret, request.Code, time.Now(), user.TOTPLastCounter)
+		if !valid {
+			return ErrInvalidSecondFactor
+		}
+		used, err := user.UseTOTPCounter(server.DB, user.ID, counter)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidSecondFactor
+		}
+		return nil
+	}
+	if request.RecoveryCode != "" {
+		recoveryCode := models.RecoveryCode{}
+		err := recoveryCode.ConsumeRecoveryCode(server.DB, user.ID, auth.HashRecoveryCode(request.RecoveryCode))
+		if err == models.ErrRecoveryCodeInvalid {
+			return ErrInvalidSecondFactor
+		}
+		return err
+	}
+	return ErrInvalidSecondFactor
+}
+
+// newRecoveryCodes generate and store a new set of recovery codes for the user
+func (server *Server) newRecoveryCodes(uid uuid.UUID) ([]string, error) {
+	codes := []string{}
+	hashes := []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, hash, err := auth.NewRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hash)
+	}
+	recoveryCode := models.RecoveryCode{}
+	err := recoveryCode.ReplaceRecoveryCodes(server.DB, uid, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// twoFactorOwner the user of the {id} route variable, when they are the one making the request.
+// Nobody else, admins included, may see or set their secret.
+func (server *Server) twoFactorOwner(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	uid, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return models.User{}, false
+	}
+	claims, err := auth.ExtractClaims(r)
+	if err != nil || claims.UserID != uid {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return models.User{}, false
+	}
+	if claims.Kind == auth.KindAPIKey {
+		responses.ERROR(w, http.StatusForbidden, errors.New("Forbidden"))
+		return models.User{}, false
+	}
+	user := models.User{}
+	_, err = user.FindUserByID(server.DB, uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("User not found"))
+		return models.User{}, false
+	}
+	return user, true
+}
+
+func readSecondFactor(w http.ResponseWriter, r *http.Request) (secondFactor, bool) {
+	request := secondFactor{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return request, false
+	}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return request, false
+	}
+	return request, true
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 
 	"github.com/arikardnoir/asiwaju/api/auth"
@@ -21,14 +22,27 @@ func (server *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 	}
-	user := models.User{}
-	err = json.Unmarshal(body, &user)
+	// Only what a visitor may choose is read, verification, 2FA and the role start out unset
+	signup := struct {
+		Fullname string `json:"fullname"`
+		Nickname string `json:"nickname"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{}
+	err = json.Unmarshal(body, &signup)
 	if err != nil {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
 	}
-	user.ID = uuid.Must(uuid.NewRandom())
+	user := models.User{
+		ID:       uuid.Must(uuid.NewRandom()),
+		Fullname: signup.Fullname,
+		Nickname: signup.Nickname,
+		Email:    signup.Email,
+		Password: signup.Password,
+	}
 	user.Prepare()
+	user.Role = models.RoleUser // Roles are only given by admins
 	err = user.Validate("")
 	if err != nil {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
@@ -43,8 +57,12 @@ func (server *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusInternalServerError, formattedError)
 		return
 	}
+	err = server.sendEmailVerification(r, *userCreated)
+	if err != nil {
+		log.Printf("cannot send email verification to %s: %v", userCreated.ID, err)
+	}
 	w.Header().Set("Location", fmt.Sprintf("%s%s/%d", r.Host, r.RequestURI, userCreated.ID))
-	responses.JSON(w, http.StatusCreated, userCreated)
+	responses.JSON(w, http.StatusCreated, models.SanitizeUser(*userCreated))
 }
 
 func (server *Server) GetUsers(w http.ResponseWriter, r *http.Request) {
@@ -56,7 +74,7 @@ func (server *Server) GetUsers(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
-	responses.JSON(w, http.StatusOK, users)
+	responses.JSON(w, http.StatusOK, models.SanitizeUsers(*users))
 }
 
 func (server *Server) GetUser(w http.ResponseWriter, r *http.Request) {
@@ -67,13 +85,22 @@ func (server *Server) GetUser(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusBadRequest, err)
 		return
 	}
+	claims, err := auth.ExtractClaims(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+	if !canManage(claims, uid) {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
 	user := models.User{}
 	userGotten, err := user.FindUserByID(server.DB, uid)
 	if err != nil {
 		responses.ERROR(w, http.StatusNotFound, errors.New("User not found"))
 		return
 	}
-	responses.JSON(w, http.StatusOK, userGotten)
+	responses.JSON(w, http.StatusOK, models.SanitizeUser(*userGotten))
 }
 
 func (server *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
@@ -95,12 +122,12 @@ func (server *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
 	}
-	tokenID, err := auth.ExtractTokenID(r)
+	claims, err := auth.ExtractClaims(r)
 	if err != nil {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
-	if tokenID != uid {
+	if !canManage(claims, uid) {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
@@ -110,13 +137,36 @@ func (server *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusUnprocessableEntity, err)
 		return
 	}
+
+	// A new password makes every token issued before it stop working
+	currentUser := models.User{}
+	_, err = currentUser.FindUserByID(server.DB, uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("User not found"))
+		return
+	}
+	passwordChanged := user.Password != "" && models.VerifyPassword(currentUser.Password, user.Password) != nil
+
 	updatedUser, err := user.UpdateAUser(server.DB, uid)
 	if err != nil {
 		formattedError := formaterror.FormatError(err.Error())
 		responses.ERROR(w, http.StatusInternalServerError, formattedError)
 		return
 	}
-	responses.JSON(w, http.StatusOK, updatedUser)
+	if updatedUser.Email != currentUser.Email {
+		err = server.sendEmailVerification(r, *updatedUser)
+		if err != nil {
+			log.Printf("cannot send email verification to %s: %v", uid, err)
+		}
+	}
+	if passwordChanged {
+		err = server.RevokeAllTokens(uid)
+		if err != nil {
+			responses.ERROR(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	responses.JSON(w, http.StatusOK, models.SanitizeUser(*updatedUser))
 }
 
 func (server *Server) DeleteUser(w http.ResponseWriter, r *http.Request) {
@@ -130,20 +180,70 @@ func (server *Server) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		responses.ERROR(w, http.StatusBadRequest, err)
 		return
 	}
-	tokenID, err := auth.ExtractTokenID(r)
+	claims, err := auth.ExtractClaims(r)
 	if err != nil {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
-	if tokenID != uuid.Nil && tokenID != uid {
+	if !canManage(claims, uid) {
 		responses.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
+	user.ActorID = &claims.UserID
 	_, err = user.DeleteAUser(server.DB, uid)
 	if err != nil {
 		responses.ERROR(w, http.StatusInternalServerError, err)
 		return
 	}
+	// The user is in the trash, their tokens must not outlive it
+	err = server.RevokeAllTokens(uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
 	w.Header().Set("Entity", fmt.Sprintf("%d", uid))
 	responses.JSON(w, http.StatusNoContent, "")
 }
+
+// UpdateUserRole let an admin change the role of a user
+func (server *Server) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+
+	vars := mux.Vars(r)
+	uid, err := uuid.Parse(vars["id"])
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	request := struct {
+		Role string `json:"role"`
+	}{}
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if !models.ValidRole(request.Role) {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Invalid Role"))
+		return
+	}
+
+	user := models.User{}
+	updatedUser, err := user.UpdateARole(server.DB, uid, request.Role)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("User not found"))
+		return
+	}
+
+	// Tokens carry the role, so the old ones must not keep the old role alive
+	err = server.RevokeAllTokens(uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, models.SanitizeUser(*updatedUser))
+}
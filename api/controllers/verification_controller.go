@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/mailer"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/responses"
+	"github.com/google/uuid"
+)
+
+const defaultEmailVerificationTTL = 48 * time.Hour
+
+// VerifyEmail mark the email as verified with the token of the verification link.
+// Tokens issued before keep their claims, a refresh picks up the verified email.
+func (server *Server) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		responses.ERROR(w, http.StatusUnprocessableEntity, errors.New("Required Token"))
+		return
+	}
+
+	oneTimeToken := models.OneTimeToken{}
+	_, err := oneTimeToken.ConsumeOneTimeToken(server.DB, models.PurposeEmailVerification, auth.HashToken(token))
+	if err == models.ErrOneTimeTokenInvalid {
+		responses.ERROR(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	user := models.User{}
+	err = user.MarkEmailVerified(server.DB, oneTimeToken.UserID)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, map[string]string{
+		"message": "Email verified",
+	})
+}
+
+// ResendVerificationEmail send a new verification link to the authenticated user
+func (server *Server) ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	uid, err := auth.ExtractTokenID(r)
+	if err != nil {
+		responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+	user := models.User{}
+	_, err = user.FindUserByID(server.DB, uid)
+	if err != nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("User not found"))
+		return
+	}
+	if user.EmailVerified {
+		responses.ERROR(w, http.StatusConflict, errors.New("Email Already Verified"))
+		return
+	}
+	err = server.sendEmailVerification(r, user)
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusAccepted, map[string]string{
+		"message": "A verification link was sent to your email",
+	})
+}
+
+func (server *Server) sendEmailVerification(r *http.Request, user models.User) error {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	ttl := defaultEmailVerificationTTL
+	if d, err := time.ParseDuration(os.Getenv("EMAIL_VERIFICATION_TTL")); err == nil && d > 0 {
+		ttl = d
+	}
+	oneTimeToken := models.OneTimeToken{
+		ID:        uuid.Must(uuid.NewRandom()),
+		UserID:    user.ID,
+		Purpose:   models.PurposeEmailVerification,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	_, err = oneTimeToken.SaveOneTimeToken(server.DB)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", appURL(r), url.QueryEscape(token))
+	return server.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hello %s,\n\nPlease confirm this is your email by opening the link below within %s:\n\n%s\n",
+			user.Fullname, ttl, link),
+	})
+}
@@ -0,0 +1,173 @@
+// Package exchange keeps the exchange rates prices are converted with, in the database or in
+// a JSON file, cached in memory so reading a product does not read the rates every time.
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/arikardnoir/asiwaju/api/money"
+)
+
+// Rates the exchange rates of one base currency: one unit of Base is worth Rates[c] units of c.
+// Rates between two other currencies cross through the base.
+type Rates struct {
+	Base      string             `json:"base"`
+	Rates     map[string]float64 `json:"rates"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// Prepare set value for Rates, currency codes are uppercase
+func (r *Rates) Prepare() {
+	r.Base = strings.ToUpper(strings.TrimSpace(r.Base))
+	rates := map[string]float64{}
+	for currency, rate := range r.Rates {
+		rates[strings.ToUpper(strings.TrimSpace(currency))] = rate
+	}
+	r.Rates = rates
+	r.UpdatedAt = time.Now()
+}
+
+// Validate validations on Rates
+func (r *Rates) Validate() error {
+	if !money.ValidCurrency(r.Base) {
+		return fmt.Errorf("Unknown Base Currency: %s", r.Base)
+	}
+	if len(r.Rates) == 0 {
+		return errors.New("Required Rates")
+	}
+	for currency, rate := range r.Rates {
+		if !money.ValidCurrency(currency) {
+			return fmt.Errorf("Unknown Currency: %s", currency)
+		}
+		// The base is worth itself, a rate of one when it is sent
+		if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) || currency == r.Base && rate != 1 {
+			return fmt.Errorf("Invalid Rate: %s", currency)
+		}
+	}
+	return nil
+}
+
+// Rate implements money.ExchangeRateProvider
+func (r Rates) Rate(from, to string) (float64, error) {
+	fromRate, ok := r.baseRate(from)
+	if !ok {
+		return 0, money.ErrNoExchangeRate
+	}
+	toRate, ok := r.baseRate(to)
+	if !ok {
+		return 0, money.ErrNoExchangeRate
+	}
+	return toRate / fromRate, nil
+}
+
+// Has tell whether amounts can be converted to or from the currency
+func (r Rates) Has(currency string) bool {
+	_, ok := r.baseRate(currency)
+	return ok
+}
+
+func (r Rates) baseRate(currency string) (float64, bool) {
+	currency = strings.ToUpper(currency)
+	if r.Base != "" && currency == r.Base {
+		return 1, true
+	}
+	rate, ok := r.Rates[currency]
+	return rate, ok
+}
+
+// Source keeps the rates, so they can live in the database or in a file
+type Source interface {
+	// Load the rates, empty Rates when none were saved yet
+	Load() (Rates, error)
+	// Save replace the rates
+	Save(rates Rates) error
+}
+
+// Provider a money.ExchangeRateProvider over a Source. The rates are read again once they are
+// older than the ttl, so rates uploaded to another instance of the API show up here too.
+type Provider struct {
+	source Source
+	ttl    time.Duration
+
+	mu       sync.Mutex
+	rates    Rates
+	loadedAt time.Time
+}
+
+// NewProvider create a Provider
+func NewProvider(source Source, ttl time.Duration) *Provider {
+	return &Provider{source: source, ttl: ttl}
+}
+
+// FromEnv the Provider configured by EXCHANGE_RATES_STORE (db or file), EXCHANGE_RATES_FILE
+// and EXCHANGE_RATES_CACHE_TTL
+func FromEnv(db *gorm.DB) (*Provider, error) {
+	ttl := 5 * time.Minute
+	if d, err := time.ParseDuration(os.Getenv("EXCHANGE_RATES_CACHE_TTL")); err == nil && d >= 0 {
+		ttl = d
+	}
+	switch strings.ToLower(os.Getenv("EXCHANGE_RATES_STORE")) {
+	case "", "db":
+		return NewProvider(NewDBSource(db), ttl), nil
+	case "file":
+		path := os.Getenv("EXCHANGE_RATES_FILE")
+		if path == "" {
+			path = "exchange_rates.json"
+		}
+		return NewProvider(NewFileSource(path), ttl), nil
+	default:
+		return nil, fmt.Errorf("Unknown exchange rates store: %s", os.Getenv("EXCHANGE_RATES_STORE"))
+	}
+}
+
+// Rates the current rates, read from the source when the cached ones are too old. When the
+// source fails the cached rates are kept until it answers again.
+func (p *Provider) Rates() (Rates, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.loadedAt.IsZero() && time.Since(p.loadedAt) < p.ttl {
+		return p.rates, nil
+	}
+	rates, err := p.source.Load()
+	if err != nil {
+		if p.loadedAt.IsZero() {
+			return Rates{}, err
+		}
+		log.Printf("cannot reload the exchange rates, keeping the cached ones: %v", err)
+		return p.rates, nil
+	}
+	p.rates = rates
+	p.loadedAt = time.Now()
+	return p.rates, nil
+}
+
+// Rate implements money.ExchangeRateProvider
+func (p *Provider) Rate(from, to string) (float64, error) {
+	rates, err := p.Rates()
+	if err != nil {
+		return 0, err
+	}
+	return rates.Rate(from, to)
+}
+
+// Upload save new rates in the source, they replace the cached ones at once
+func (p *Provider) Upload(rates Rates) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.source.Save(rates)
+	if err != nil {
+		return err
+	}
+	p.rates = rates
+	p.loadedAt = time.Now()
+	return nil
+}
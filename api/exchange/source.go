@@ -0,0 +1,103 @@
+package exchange
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+)
+
+// DBSource a Source backed by the exchange_rates table, shared by every instance of the API
+type DBSource struct {
+	DB *gorm.DB
+}
+
+// NewDBSource create a DBSource
+func NewDBSource(db *gorm.DB) *DBSource {
+	return &DBSource{DB: db}
+}
+
+// Load implements Source
+func (s *DBSource) Load() (Rates, error) {
+	rate := models.ExchangeRate{}
+	saved, err := rate.FindAllExchangeRates(s.DB)
+	if err != nil {
+		return Rates{}, err
+	}
+	rates := Rates{Rates: map[string]float64{}}
+	for _, r := range *saved {
+		rates.Base = r.Base
+		rates.Rates[r.Currency] = r.Rate
+		if r.UpdatedAt.After(rates.UpdatedAt) {
+			rates.UpdatedAt = r.UpdatedAt
+		}
+	}
+	return rates, nil
+}
+
+// Save implements Source
+func (s *DBSource) Save(rates Rates) error {
+	rate := models.ExchangeRate{}
+	return rate.ReplaceExchangeRates(s.DB, rates.Base, rates.Rates, rates.UpdatedAt)
+}
+
+// FileSource a Source backed by a JSON file of Rates, for a single instance or rates
+// deployed with the API
+type FileSource struct {
+	Path string
+}
+
+// NewFileSource create a FileSource
+func NewFileSource(path string) *FileSource {
+	return &FileSource{Path: path}
+}
+
+// Load implements Source
+func (s *FileSource) Load() (Rates, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return Rates{Rates: map[string]float64{}}, nil
+	}
+	if err != nil {
+		return Rates{}, err
+	}
+	rates := Rates{}
+	err = json.Unmarshal(data, &rates)
+	if err != nil {
+		return Rates{}, err
+	}
+	if rates.Rates == nil {
+		rates.Rates = map[string]float64{}
+	}
+	return rates, nil
+}
+
+// Save implements Source, the file is replaced at once so a reader never sees half of it
+func (s *FileSource) Save(rates Rates) error {
+	data, err := json.MarshalIndent(rates, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.Path)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".exchange_rates-*.json")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
@@ -0,0 +1,62 @@
+// Package logging writes structured log lines, one JSON object per event,
+// so they can be searched by field instead of by message.
+package logging
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Fields the data of an event
+type Fields map[string]interface{}
+
+var (
+	mu     sync.Mutex
+	output io.Writer = os.Stderr
+)
+
+// SetOutput replace where events are written, stderr by default
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	output = w
+}
+
+// Info write an event of normal operation
+func Info(event string, fields Fields) {
+	write("info", event, fields)
+}
+
+// Warn write an event that may need attention, such as a failed login
+func Warn(event string, fields Fields) {
+	write("warn", event, fields)
+}
+
+// Error write an event of something that went wrong
+func Error(event string, fields Fields) {
+	write("error", event, fields)
+}
+
+func write(level, event string, fields Fields) {
+	line := Fields{}
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		line[k] = v
+	}
+	line["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	line["level"] = level
+	line["event"] = event
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		b, _ = json.Marshal(Fields{"time": line["time"], "level": "error", "event": "log_encoding_failed", "error": err.Error()})
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	output.Write(append(b, '\n'))
+}
@@ -0,0 +1,252 @@
+// Package loginguard slows down and locks out repeated failed logins. Failures of an account
+// slow down every login to it, but only lock it out for the client IP they came from, so
+// nobody can keep someone else's account locked. An IP failing on many accounts is locked too.
+package loginguard
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Attempts the failed logins counted for a key
+type Attempts struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// Store keeps the counters of failed logins, so they can live in memory or in the database
+type Store interface {
+	// Get the attempts of the key, zero Attempts when there are none
+	Get(key string) (Attempts, error)
+	// RecordFailure count a failure at now. Failures older than window are forgotten first.
+	RecordFailure(key string, now time.Time, window time.Duration) (Attempts, error)
+	// Lock refuse every login for the key until the given time
+	Lock(key string, until time.Time) error
+	// Reset forget the failures of the key
+	Reset(key string) error
+	// Expire forget the keys whose last failure is older than window and that are not locked
+	// at now, it returns how many were forgotten
+	Expire(now time.Time, window time.Duration) (int64, error)
+}
+
+// Config the thresholds of a Guard
+type Config struct {
+	// MaxAccountFailures the failures of an account from one IP after which that IP is locked out of it
+	MaxAccountFailures int
+	// MaxIPFailures the failures after which the IP is locked, over every account it tried
+	MaxIPFailures int
+	// FreeAttempts the failures allowed before delays start
+	FreeAttempts int
+	// BaseDelay the delay after the first failure past FreeAttempts, doubled by every further one
+	BaseDelay time.Duration
+	// MaxDelay the longest delay between two attempts
+	MaxDelay time.Duration
+	// LockoutDuration how long a locked IP stays locked, out of an account or out of every one
+	LockoutDuration time.Duration
+	// Window how long a failure counts
+	Window time.Duration
+}
+
+// DefaultConfig the thresholds used when the environment sets none
+func DefaultConfig() Config {
+	return Config{
+		MaxAccountFailures: 10,
+		MaxIPFailures:      50,
+		FreeAttempts:       3,
+		BaseDelay:          time.Second,
+		MaxDelay:           time.Minute,
+		LockoutDuration:    15 * time.Minute,
+		Window:             time.Hour,
+	}
+}
+
+// ConfigFromEnv the default thresholds, overridden by the LOGIN_* variables
+func ConfigFromEnv() Config {
+	config := DefaultConfig()
+	config.MaxAccountFailures = intFromEnv("LOGIN_MAX_ACCOUNT_FAILURES", config.MaxAccountFailures)
+	config.MaxIPFailures = intFromEnv("LOGIN_MAX_IP_FAILURES", config.MaxIPFailures)
+	config.FreeAttempts = intFromEnv("LOGIN_FREE_ATTEMPTS", config.FreeAttempts)
+	config.BaseDelay = durationFromEnv("LOGIN_BASE_DELAY", config.BaseDelay)
+	config.MaxDelay = durationFromEnv("LOGIN_MAX_DELAY", config.MaxDelay)
+	config.LockoutDuration = durationFromEnv("LOGIN_LOCKOUT_DURATION", config.LockoutDuration)
+	config.Window = durationFromEnv("LOGIN_FAILURE_WINDOW", config.Window)
+	return config
+}
+
+// BlockedError a login refused before the password is even checked
+type BlockedError struct {
+	// Locked the account is locked for the IP, otherwise the client has to slow down
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+func (e *BlockedError) Error() string {
+	if e.Locked {
+		return "Account Locked"
+	}
+	return "Too Many Login Attempts"
+}
+
+// Guard decides whether a login may be tried, and counts the failures
+type Guard struct {
+	store  Store
+	config Config
+	now    func() time.Time
+}
+
+// New create a Guard
+func New(store Store, config Config) *Guard {
+	return &Guard{store: store, config: config, now: time.Now}
+}
+
+// Check return a *BlockedError when the account or the IP has to wait before trying again
+func (g *Guard) Check(account, ip string) error {
+	now := g.now()
+	pairAttempts, err := g.store.Get(pairKey(account, ip))
+	if err != nil {
+		return err
+	}
+	if now.Before(pairAttempts.LockedUntil) {
+		return &BlockedError{Locked: true, RetryAfter: pairAttempts.LockedUntil.Sub(now)}
+	}
+	ipAttempts, err := g.store.Get(ipKey(ip))
+	if err != nil {
+		return err
+	}
+	if now.Before(ipAttempts.LockedUntil) {
+		return &BlockedError{RetryAfter: ipAttempts.LockedUntil.Sub(now)}
+	}
+	accountAttempts, err := g.store.Get(accountKey(account))
+	if err != nil {
+		return err
+	}
+
+	wait := g.wait(accountAttempts, now)
+	for _, attempts := range []Attempts{pairAttempts, ipAttempts} {
+		if w := g.wait(attempts, now); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return &BlockedError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Fail count a failed login of the account from the IP. The account only slows down, the IP is
+// locked out of the account, or out of every one, once past the thresholds.
+func (g *Guard) Fail(account, ip string) error {
+	now := g.now()
+	_, err := g.store.RecordFailure(accountKey(account), now, g.config.Window)
+	if err != nil {
+		return err
+	}
+	attempts, err := g.store.RecordFailure(pairKey(account, ip), now, g.config.Window)
+	if err != nil {
+		return err
+	}
+	if g.config.MaxAccountFailures > 0 && attempts.Failures >= g.config.MaxAccountFailures {
+		err = g.store.Lock(pairKey(account, ip), now.Add(g.config.LockoutDuration))
+		if err != nil {
+			return err
+		}
+	}
+	attempts, err = g.store.RecordFailure(ipKey(ip), now, g.config.Window)
+	if err != nil {
+		return err
+	}
+	if g.config.MaxIPFailures > 0 && attempts.Failures >= g.config.MaxIPFailures {
+		return g.store.Lock(ipKey(ip), now.Add(g.config.LockoutDuration))
+	}
+	return nil
+}
+
+// Succeed forget the failures of the account after a successful login.
+// The IP keeps its count, so one valid account cannot be used to reset guessing on others.
+func (g *Guard) Succeed(account, ip string) error {
+	err := g.store.Reset(accountKey(account))
+	if err != nil {
+		return err
+	}
+	return g.store.Reset(pairKey(account, ip))
+}
+
+// Sweep forget the failures that no longer count, so failed logins with made-up accounts or
+// from passing IPs do not pile up
+func (g *Guard) Sweep() (int64, error) {
+	return g.store.Expire(g.now(), g.config.Window)
+}
+
+// StartSweeper sweep the store every interval
+func (g *Guard) StartSweeper(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			count, err := g.Sweep()
+			if err != nil {
+				log.Printf("cannot expire the login attempts: %v", err)
+				continue
+			}
+			if count > 0 {
+				log.Printf("expired %d login attempts", count)
+			}
+		}
+	}()
+}
+
+// wait how long the next attempt has to wait after the last failure
+func (g *Guard) wait(attempts Attempts, now time.Time) time.Duration {
+	over := attempts.Failures - g.config.FreeAttempts
+	if over <= 0 || now.Sub(attempts.LastFailureAt) > g.config.Window {
+		return 0
+	}
+	delay := g.config.BaseDelay
+	for i := 1; i < over && delay < g.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > g.config.MaxDelay {
+		delay = g.config.MaxDelay
+	}
+	return attempts.LastFailureAt.Add(delay).Sub(now)
+}
+
+func accountKey(account string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(account))
+}
+
+func pairKey(account, ip string) string {
+	return accountKey(account) + "|ip:" + ip
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func intFromEnv(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// RetryAfterSeconds the Retry-After header value of d, rounded up
+func RetryAfterSeconds(d time.Duration) string {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return fmt.Sprint(seconds)
+}
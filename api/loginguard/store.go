@@ -0,0 +1,128 @@
+package loginguard
+
+import (
+	"sync"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/jinzhu/gorm"
+)
+
+// MemoryStore a Store for a single process, counters are lost on restart
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+// NewMemoryStore create a MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: map[string]Attempts{}}
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[key], nil
+}
+
+// RecordFailure implements Store
+func (s *MemoryStore) RecordFailure(key string, now time.Time, window time.Duration) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts := s.attempts[key]
+	if now.Sub(attempts.LastFailureAt) > window {
+		attempts.Failures = 0
+	}
+	attempts.Failures++
+	attempts.LastFailureAt = now
+	s.attempts[key] = attempts
+	return attempts, nil
+}
+
+// Lock implements Store
+func (s *MemoryStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts := s.attempts[key]
+	attempts.LockedUntil = until
+	s.attempts[key] = attempts
+	return nil
+}
+
+// Reset implements Store
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+// Expire implements Store
+func (s *MemoryStore) Expire(now time.Time, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expired int64
+	for key, attempts := range s.attempts {
+		if now.Sub(attempts.LastFailureAt) > window && !now.Before(attempts.LockedUntil) {
+			delete(s.attempts, key)
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// DBStore a Store backed by the login_attempts table, shared by every instance of the API
+type DBStore struct {
+	DB *gorm.DB
+}
+
+// NewDBStore create a DBStore
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{DB: db}
+}
+
+// Get implements Store
+func (s *DBStore) Get(key string) (Attempts, error) {
+	attempt := models.LoginAttempt{}
+	_, err := attempt.FindLoginAttempt(s.DB, key)
+	if err != nil {
+		return Attempts{}, err
+	}
+	return toAttempts(attempt), nil
+}
+
+// RecordFailure implements Store
+func (s *DBStore) RecordFailure(key string, now time.Time, window time.Duration) (Attempts, error) {
+	attempt := models.LoginAttempt{}
+	_, err := attempt.RecordLoginFailure(s.DB, key, now, now.Add(-window))
+	if err != nil {
+		return Attempts{}, err
+	}
+	return toAttempts(attempt), nil
+}
+
+// Lock implements Store
+func (s *DBStore) Lock(key string, until time.Time) error {
+	attempt := models.LoginAttempt{}
+	return attempt.LockLoginAttempt(s.DB, key, until)
+}
+
+// Reset implements Store
+func (s *DBStore) Reset(key string) error {
+	attempt := models.LoginAttempt{}
+	return attempt.DeleteLoginAttempt(s.DB, key)
+}
+
+// Expire implements Store
+func (s *DBStore) Expire(now time.Time, window time.Duration) (int64, error) {
+	return models.ExpireLoginAttempts(s.DB, now.Add(-window), now)
+}
+
+func toAttempts(attempt models.LoginAttempt) Attempts {
+	attempts := Attempts{Failures: attempt.Failures, LastFailureAt: attempt.LastFailureAt}
+	if attempt.LockedUntil != nil {
+		attempts.LockedUntil = *attempt.LockedUntil
+	}
+	return attempts
+}
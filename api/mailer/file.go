@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileSender writes every email to a .eml file of Dir, for local development
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+// Send implements Sender
+func (s *FileSender) Send(msg Message) error {
+	err := os.MkdirAll(s.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), sanitize(msg.To))
+	return ioutil.WriteFile(filepath.Join(s.Dir, name), format(s.From, msg), 0644)
+}
+
+func sanitize(address string) string {
+	b := []byte(address)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
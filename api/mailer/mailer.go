@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Message an email to send
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender sends emails
+type Sender interface {
+	Send(msg Message) error
+}
+
+// FromEnv build the Sender configured by MAIL_DRIVER (smtp, file or memory), wrapped in an Outbox.
+// Without a driver, mails are written to MAIL_DIR for local development.
+func FromEnv() (*Outbox, error) {
+	var sender Sender
+	switch strings.ToLower(os.Getenv("MAIL_DRIVER")) {
+	case "smtp":
+		sender = &SMTPSender{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	case "memory":
+		sender = NewMemorySender()
+	case "", "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		sender = &FileSender{Dir: dir, From: os.Getenv("MAIL_FROM")}
+	default:
+		return nil, fmt.Errorf("Unknown mail driver: %s", os.Getenv("MAIL_DRIVER"))
+	}
+	return NewOutbox(sender, 100), nil
+}
+
+// format render the message as RFC 5322 text
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(msg.Body, "\n", "\r\n", -1))
+	return []byte(b.String())
+}
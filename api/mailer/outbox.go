@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrOutboxFull the outbox cannot take more emails right now
+var ErrOutboxFull = errors.New("Outbox Full")
+
+const outboxAttempts = 3
+
+// Outbox queues emails and sends them in the background, so requests never wait on the mail server
+type Outbox struct {
+	Sender Sender
+	queue  chan Message
+}
+
+// NewOutbox create an Outbox holding up to size emails and start sending them
+func NewOutbox(sender Sender, size int) *Outbox {
+	o := &Outbox{Sender: sender, queue: make(chan Message, size)}
+	go o.run()
+	return o
+}
+
+// Send implements Sender by queueing the email
+func (o *Outbox) Send(msg Message) error {
+	select {
+	case o.queue <- msg:
+		return nil
+	default:
+		return ErrOutboxFull
+	}
+}
+
+func (o *Outbox) run() {
+	for msg := range o.queue {
+		var err error
+		for attempt := 1; attempt <= outboxAttempts; attempt++ {
+			err = o.Sender.Send(msg)
+			if err == nil {
+				break
+			}
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		if err != nil {
+			log.Printf("cannot send email %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+)
+
+// SMTPSender sends emails through an SMTP server
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send implements Sender
+func (s *SMTPSender) Send(msg Message) error {
+	if s.Host == "" || s.From == "" {
+		return errors.New("SMTP host and sender address are required")
+	}
+	port := s.Port
+	if port == "" {
+		port = "587"
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(s.Host, port), auth, s.From, []string{msg.To}, format(s.From, msg))
+}
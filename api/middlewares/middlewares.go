@@ -16,14 +16,83 @@ func SetMiddlewareJSON(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-//SetMiddlewareAuthentication check for the validity of the authentication token provided.
+//SetMiddlewareAuthentication check for the validity of the authentication token or API key provided.
 func SetMiddlewareAuthentication(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := auth.TokenValid(r)
+		claims, err := auth.ExtractClaims(r)
 		if err != nil {
 			responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
 			return
 		}
-		next(w, r)
+		next(w, auth.WithClaims(r, claims))
+	}
+}
+
+//RequireRole only let through authenticated requests whose token carries one of the roles, API keys never do
+func RequireRole(roles ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims, err := auth.ExtractClaims(r)
+			if err != nil {
+				responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+				return
+			}
+			if claims.Kind == auth.KindAPIKey || !claims.HasRole(roles...) {
+				responses.ERROR(w, http.StatusForbidden, errors.New("Forbidden"))
+				return
+			}
+			next(w, auth.WithClaims(r, claims))
+		}
+	}
+}
+
+//RequireAccessToken only let through requests authenticated with an access token, the account
+//itself (password, email, 2FA, API keys) cannot be managed with an API key
+func RequireAccessToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.ExtractClaims(r)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			return
+		}
+		if claims.Kind == auth.KindAPIKey {
+			responses.ERROR(w, http.StatusForbidden, errors.New("Forbidden"))
+			return
+		}
+		next(w, auth.WithClaims(r, claims))
+	}
+}
+
+//RequireScope only let through authenticated requests allowed the scope, tokens have every scope
+func RequireScope(scope string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims, err := auth.ExtractClaims(r)
+			if err != nil {
+				responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+				return
+			}
+			if !claims.HasScope(scope) {
+				responses.ERROR(w, http.StatusForbidden, errors.New("Forbidden"))
+				return
+			}
+			next(w, auth.WithClaims(r, claims))
+		}
+	}
+}
+
+//RequireVerifiedEmail only let through authenticated requests of users who verified their email
+func RequireVerifiedEmail(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.ExtractClaims(r)
+		if err != nil {
+			responses.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			return
+		}
+		if !claims.EmailVerified {
+			responses.ERROR(w, http.StatusForbidden, errors.New("Email Not Verified"))
+			return
+		}
+		next(w, auth.WithClaims(r, claims))
 	}
 }
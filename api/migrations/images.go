@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"html"
+
+	"github.com/jinzhu/gorm"
+)
+
+// unescapeImageURLs undo the HTML escaping the image URLs of the products were saved with,
+// which turned the & of their query strings into &amp;
+func unescapeImageURLs(tx *gorm.DB) error {
+	rows, err := tx.Debug().Table("products").Select("id, image").Where("image LIKE ?", "%&%;%").Rows()
+	if err != nil {
+		return err
+	}
+	images := map[string]string{}
+	for rows.Next() {
+		var id, image string
+		err = rows.Scan(&id, &image)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		images[id] = image
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for id, image := range images {
+		unescaped := html.UnescapeString(image)
+		if unescaped == image {
+			continue
+		}
+		err = tx.Debug().Table("products").Where("id = ?", id).UpdateColumn("image", unescaped).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
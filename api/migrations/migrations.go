@@ -0,0 +1,70 @@
+// Package migrations applies the one-off changes of the data that AutoMigrate cannot make,
+// each one once, recording it in the schema_migrations table.
+package migrations
+
+import (
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Migration a named change of the data, applied in a transaction
+type Migration struct {
+	ID string
+	Up func(tx *gorm.DB) error
+}
+
+// registry the migrations in the order they apply, new ones go at the end and
+// applied ones are never renamed
+var registry = []Migration{
+	{ID: "0001_split_product_sizes", Up: splitProductSizes},
+	{ID: "0002_price_minor_units", Up: priceMinorUnits},
+	{ID: "0003_unescape_image_urls", Up: unescapeImageURLs},
+	{ID: "0004_start_price_history", Up: startPriceHistory},
+	{ID: "0005_verify_existing_users", Up: verifyExistingUsers},
+	{ID: "0006_variant_sku_per_product", Up: variantSKUPerProduct},
+}
+
+// SchemaMigration a migration already applied
+type SchemaMigration struct {
+	ID        string    `gorm:"primary_key;size:255"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// TableName the table of the applied migrations
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Run apply the pending migrations. The tables must have been auto migrated first.
+func Run(db *gorm.DB) error {
+	err := db.Debug().AutoMigrate(&SchemaMigration{}).Error
+	if err != nil {
+		return err
+	}
+	for _, m := range registry {
+		count := 0
+		err = db.Debug().Model(&SchemaMigration{}).Where("id = ?", m.ID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := m.Up(tx)
+			if err != nil {
+				return err
+			}
+			// Another instance applying it at the same time fails here and rolls back
+			return tx.Debug().Create(&SchemaMigration{ID: m.ID, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			log.Printf("cannot apply the migration %s: %v", m.ID, err)
+			return err
+		}
+		log.Printf("applied the migration %s", m.ID)
+	}
+	return nil
+}
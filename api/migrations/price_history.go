@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// startPriceHistory give the products saved before the price history was kept its first entry,
+// their current price as of the migration. When it was set is unknown, so the history starts
+// there rather than pretending the product always had it.
+func startPriceHistory(tx *gorm.DB) error {
+	rows, err := tx.Debug().Table("products").Select("id, price_amount, price_currency").
+		Where("id NOT IN (SELECT product_id FROM price_history)").Rows()
+	if err != nil {
+		return err
+	}
+	type start struct {
+		id       string
+		amount   int64
+		currency string
+	}
+	starts := []start{}
+	for rows.Next() {
+		s := start{}
+		err = rows.Scan(&s.id, &s.amount, &s.currency)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		starts = append(starts, s)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, s := range starts {
+		err = tx.Debug().Exec("INSERT INTO price_history (id, product_id, price_amount, price_currency, changed_at) VALUES (?, ?, ?, ?, ?)",
+			uuid.Must(uuid.NewRandom()), s.id, s.amount, s.currency, now).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
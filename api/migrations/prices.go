@@ -0,0 +1,69 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"github.com/arikardnoir/asiwaju/api/money"
+)
+
+// priceMinorUnits move the float prices of the products and the price overrides of the
+// variants into integer minor units, in DEFAULT_CURRENCY, and drop the float columns.
+// A product without a price gets a price of zero, a variant without an override keeps none.
+func priceMinorUnits(tx *gorm.DB) error {
+	currency := money.DefaultCurrency()
+	err := floatsToMoney(tx, "products", "price", "price_", currency, true)
+	if err != nil {
+		return err
+	}
+	return floatsToMoney(tx, "product_variants", "price_override", "price_override_", currency, false)
+}
+
+// floatsToMoney copy the float column of the table into the prefix_amount and prefix_currency
+// columns AutoMigrate added, rounding to the minor unit, then drop it
+func floatsToMoney(tx *gorm.DB, table, column, prefix, currency string, nullIsZero bool) error {
+	if !tx.Dialect().HasColumn(table, column) {
+		return nil
+	}
+	query := tx.Debug().Table(table)
+	if nullIsZero {
+		query = query.Select("id, COALESCE(" + column + ", 0)")
+	} else {
+		query = query.Select("id, " + column).Where(column + " IS NOT NULL")
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	amounts := map[string]float64{}
+	for rows.Next() {
+		var id string
+		var amount float64
+		err = rows.Scan(&id, &amount)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		amounts[id] = amount
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for id, amount := range amounts {
+		m, err := money.FromFloat(amount, currency)
+		if err != nil {
+			return err
+		}
+		err = tx.Debug().Table(table).
+			Where("id = ? AND ("+prefix+"currency IS NULL OR "+prefix+"currency = '')", id).
+			UpdateColumns(map[string]interface{}{
+				prefix + "amount":   m.Amount,
+				prefix + "currency": m.Currency,
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Debug().Table(table).DropColumn(column).Error
+}
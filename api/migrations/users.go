@@ -0,0 +1,13 @@
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// verifyExistingUsers mark the users who signed up before emails were verified as verified, so
+// they keep creating products. They are the ones never sent a verification token; users who
+// signed up since were, and still verify their email themselves.
+func verifyExistingUsers(tx *gorm.DB) error {
+	return tx.Debug().Exec("UPDATE users SET email_verified = ?, email_verified_at = created_at WHERE email_verified = ? "+
+		"AND id NOT IN (SELECT user_id FROM one_time_tokens WHERE purpose = ?)", true, false, "email_verification").Error
+}
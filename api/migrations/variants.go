@@ -0,0 +1,75 @@
+package migrations
+
+import (
+	"html"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+)
+
+// splitProductSizes turn the semicolon separated sizes of the products ("38;39;40") into
+// one variant per size, without stock, and clear the size strings
+func splitProductSizes(tx *gorm.DB) error {
+	products := []models.Product{}
+	err := tx.Debug().Model(&models.Product{}).Where("size IS NOT NULL AND size <> ''").Find(&products).Error
+	if err != nil {
+		return err
+	}
+	for _, p := range products {
+		for _, size := range splitSizes(p.Size) {
+			variant := models.ProductVariant{
+				ID:         uuid.Must(uuid.NewRandom()),
+				ProductID:  p.ID,
+				Attributes: models.VariantAttributes{"size": size},
+			}
+			variant.Prepare()
+			count := 0
+			err = tx.Debug().Model(&models.ProductVariant{}).
+				Where("product_id = ? AND attributes = ?", p.ID, variant.Attributes).
+				Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			_, err = variant.SaveVariant(tx)
+			if err != nil {
+				return err
+			}
+		}
+		// The size is no longer revisioned, clearing it is not a revision of the product
+		err = tx.Debug().Model(&models.Product{}).Where("id = ?", p.ID).UpdateColumn("size", "").Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// variantSKUPerProduct drop the index that kept variant SKUs unique across every seller, the
+// SKU is unique among the variants of a product now
+func variantSKUPerProduct(tx *gorm.DB) error {
+	if !tx.Dialect().HasIndex("product_variants", "uix_product_variants_sku") {
+		return nil
+	}
+	return tx.Debug().Model(&models.ProductVariant{}).RemoveIndex("uix_product_variants_sku").Error
+}
+
+// splitSizes the distinct sizes of a size string, in their order. The sizes were saved escaped.
+func splitSizes(s string) []string {
+	sizes := []string{}
+	seen := map[string]bool{}
+	for _, size := range strings.Split(html.UnescapeString(s), ";") {
+		size = strings.TrimSpace(size)
+		if size == "" || seen[size] {
+			continue
+		}
+		seen[size] = true
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// ScopeProductsRead read the products of the key owner
+	ScopeProductsRead = "products:read"
+	// ScopeProductsWrite create, update and delete the products of the key owner
+	ScopeProductsWrite = "products:write"
+)
+
+// APIKeyScopes the scopes an APIKey may carry
+var APIKeyScopes = []string{ScopeProductsRead, ScopeProductsWrite}
+
+// DefaultAPIKeyScopes the scopes of an APIKey created without any, the least it may do
+var DefaultAPIKeyScopes = []string{ScopeProductsRead}
+
+// APIKey struct for the API keys scripts use instead of a login. Only the hash of the key is stored.
+type APIKey struct {
+	ID         uuid.UUID  `gorm:"primary_key" json:"id"`
+	UserID     uuid.UUID  `gorm:"not null;index" json:"user_id"`
+	Name       string     `gorm:"size:255;not null" json:"name"`
+	Prefix     string     `gorm:"size:16;not null;unique_index" json:"prefix"`
+	KeyHash    string     `gorm:"size:64;not null" json:"-"`
+	Scopes     string     `gorm:"size:500;null" json:"-"`
+	ScopeList  []string   `gorm:"-" json:"scopes"`
+	ExpiresAt  *time.Time `gorm:"null" json:"expires_at"`
+	LastUsedAt *time.Time `gorm:"null" json:"last_used_at"`
+	RevokedAt  *time.Time `gorm:"null" json:"revoked_at"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// Prepare set value for APIKey
+func (k *APIKey) Prepare() {
+	k.Name = html.EscapeString(strings.TrimSpace(k.Name))
+	scopes := []string{}
+	for _, scope := range k.ScopeList {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		scopes = append(scopes, DefaultAPIKeyScopes...)
+	}
+	k.ScopeList = scopes
+	k.Scopes = strings.Join(scopes, " ")
+	k.CreatedAt = time.Now()
+}
+
+// Validate validations on APIKey
+func (k *APIKey) Validate() error {
+	if k.Name == "" {
+		return errors.New("Required Name")
+	}
+	for _, scope := range k.ScopeList {
+		if !validScope(scope) {
+			return errors.New("Invalid Scope")
+		}
+	}
+	if k.ExpiresAt != nil && k.ExpiresAt.Before(time.Now()) {
+		return errors.New("Expiry Must Be In The Future")
+	}
+	return nil
+}
+
+// AfterFind fill the scope list from the stored scopes
+func (k *APIKey) AfterFind() error {
+	k.ScopeList = strings.Fields(k.Scopes)
+	return nil
+}
+
+// Usable check if the key is neither revoked nor expired
+func (k *APIKey) Usable(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// SaveAPIKey save APIKey
+func (k *APIKey) SaveAPIKey(db *gorm.DB) (*APIKey, error) {
+	err := db.Debug().Create(&k).Error
+	if err != nil {
+		return &APIKey{}, err
+	}
+	return k, nil
+}
+
+// FindUserAPIKeys get the API keys of a user, revoked ones included
+func (k *APIKey) FindUserAPIKeys(db *gorm.DB, uid uuid.UUID) (*[]APIKey, error) {
+	keys := []APIKey{}
+	err := db.Debug().Model(&APIKey{}).Where("user_id = ?", uid).Order("created_at desc").Find(&keys).Error
+	if err != nil {
+		return &[]APIKey{}, err
+	}
+	return &keys, nil
+}
+
+// FindAPIKeyByPrefix find APIKey by the public prefix of the key
+func (k *APIKey) FindAPIKeyByPrefix(db *gorm.DB, prefix string) (*APIKey, error) {
+	err := db.Debug().Model(APIKey{}).Where("prefix = ?", prefix).Take(&k).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &APIKey{}, errors.New("API Key Not Found")
+	}
+	if err != nil {
+		return &APIKey{}, err
+	}
+	return k, nil
+}
+
+// TouchAPIKey record when the key was last used
+func (k *APIKey) TouchAPIKey(db *gorm.DB, kid uuid.UUID) error {
+	return db.Debug().Model(&APIKey{}).Where("id = ?", kid).UpdateColumn("last_used_at", time.Now()).Error
+}
+
+// RevokeAPIKey revoke an APIKey of the user
+func (k *APIKey) RevokeAPIKey(db *gorm.DB, kid uuid.UUID, uid uuid.UUID) (int64, error) {
+	db = db.Debug().Model(&APIKey{}).Where("id = ? AND user_id = ? AND revoked_at IS NULL", kid, uid).UpdateColumn("revoked_at", time.Now())
+	if db.Error != nil {
+		return 0, db.Error
+	}
+	return db.RowsAffected, nil
+}
+
+func validScope(scope string) bool {
+	for _, known := range APIKeyScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,331 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrCategoryNotFound the category does not exist
+	ErrCategoryNotFound = errors.New("Category Not Found")
+	// ErrCategoryHasChildren a category with children cannot be deleted
+	ErrCategoryHasChildren = errors.New("Category Has Children")
+	// ErrCategoryCycle a category cannot be moved below itself
+	ErrCategoryCycle = errors.New("Category Cannot Be Its Own Ancestor")
+	// ErrParentCategoryNotFound the parent given to a category does not exist
+	ErrParentCategoryNotFound = errors.New("Parent Category Not Found")
+)
+
+// Category struct for the product categories, a tree.
+// Path holds the ids from the root down to the category, so a subtree is a prefix match.
+type Category struct {
+	ID        uuid.UUID  `gorm:"primary_key" json:"id"`
+	Name      string     `gorm:"size:255;not null" json:"name"`
+	Slug      string     `gorm:"size:255;not null;unique_index" json:"slug"`
+	ParentID  *uuid.UUID `gorm:"null;index" json:"parent_id"`
+	Path      string     `gorm:"size:2000;not null;index" json:"path"`
+	Depth     int        `gorm:"not null;default:0" json:"depth"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ActorID   *uuid.UUID `gorm:"-" json:"-"`
+}
+
+// CategoryCrumb a step of the breadcrumb from the root category down to a product's category
+type CategoryCrumb struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	Slug string    `json:"slug"`
+}
+
+// Prepare set value for Category
+func (c *Category) Prepare() {
+	c.Name = html.EscapeString(strings.TrimSpace(c.Name))
+	c.Slug = strings.TrimSpace(c.Slug)
+	if c.Slug == "" {
+		c.Slug = slugify(html.UnescapeString(c.Name))
+	}
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+}
+
+// Validate validations on Category
+func (c *Category) Validate() error {
+	if c.Name == "" {
+		return errors.New("Required Name")
+	}
+	if c.Slug == "" || c.Slug != slugify(c.Slug) {
+		return errors.New("Invalid Slug")
+	}
+	return nil
+}
+
+// SaveCategory save Category below its parent
+func (c *Category) SaveCategory(db *gorm.DB) (*Category, error) {
+	err := c.placeBelow(db, c.ParentID)
+	if err != nil {
+		return &Category{}, err
+	}
+	err = db.Debug().Create(&c).Error
+	if err != nil {
+		return &Category{}, err
+	}
+	return c, nil
+}
+
+// FindAllCategories get every Category, each one after its parent
+func (c *Category) FindAllCategories(db *gorm.DB) (*[]Category, error) {
+	categories := []Category{}
+	err := db.Debug().Model(&Category{}).Order("path").Find(&categories).Error
+	if err != nil {
+		return &[]Category{}, err
+	}
+	return &categories, nil
+}
+
+// FindCategoryByID find Category by id
+func (c *Category) FindCategoryByID(db *gorm.DB, cid uuid.UUID) (*Category, error) {
+	err := db.Debug().Model(Category{}).Where("id = ?", cid).Take(&c).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Category{}, ErrCategoryNotFound
+	}
+	if err != nil {
+		return &Category{}, err
+	}
+	return c, nil
+}
+
+// FindCategoryChildren get the direct children of a Category
+func (c *Category) FindCategoryChildren(db *gorm.DB, cid uuid.UUID) (*[]Category, error) {
+	categories := []Category{}
+	err := db.Debug().Model(&Category{}).Where("parent_id = ?", cid).Order("name").Find(&categories).Error
+	if err != nil {
+		return &[]Category{}, err
+	}
+	return &categories, nil
+}
+
+// UpdateACategory update Category. Moving it to another parent moves its whole subtree.
+func (c *Category) UpdateACategory(db *gorm.DB, cid uuid.UUID) (*Category, error) {
+	current := Category{}
+	_, err := current.FindCategoryByID(db, cid)
+	if err != nil {
+		return &Category{}, err
+	}
+	c.ID = cid
+	err = c.placeBelow(db, c.ParentID)
+	if err != nil {
+		return &Category{}, err
+	}
+	if strings.HasPrefix(c.Path, current.Path) && c.Path != current.Path {
+		return &Category{}, ErrCategoryCycle
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Debug().Model(&Category{}).Where("id = ?", cid).UpdateColumns(
+			map[string]interface{}{
+				"name":       c.Name,
+				"slug":       c.Slug,
+				"parent_id":  c.ParentID,
+				"path":       c.Path,
+				"depth":      c.Depth,
+				"updated_at": time.Now(),
+			},
+		).Error
+		if err != nil || c.Path == current.Path {
+			return err
+		}
+		// Rewrite the paths of the descendants to start from the new place
+		descendants := []Category{}
+		err = tx.Debug().Model(&Category{}).Where("path LIKE ? AND id <> ?", escapeLike(current.Path)+"%", cid).Find(&descendants).Error
+		if err != nil {
+			return err
+		}
+		for _, d := range descendants {
+			path := c.Path + strings.TrimPrefix(d.Path, current.Path)
+			err = tx.Debug().Model(&Category{}).Where("id = ?", d.ID).UpdateColumns(
+				map[string]interface{}{
+					"path":  path,
+					"depth": pathDepth(path),
+				},
+			).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return &Category{}, err
+	}
+	return c.FindCategoryByID(db, cid)
+}
+
+// DeleteACategory delete a Category without children, its products are left uncategorized.
+// Each of them gets a revision by the ActorID of the Category.
+func (c *Category) DeleteACategory(db *gorm.DB, cid uuid.UUID) (int64, error) {
+	count := 0
+	err := db.Debug().Model(&Category{}).Where("parent_id = ?", cid).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, ErrCategoryHasChildren
+	}
+
+	var deleted int64
+	err = transaction(db, func(tx *gorm.DB) error {
+		// The products in the trash too, they are restored without it
+		pids := []uuid.UUID{}
+		err := tx.Debug().Unscoped().Model(&Product{}).Where("category_id = ?", cid).Pluck("id", &pids).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Debug().Unscoped().Model(&Product{}).Where("category_id = ?", cid).UpdateColumn("category_id", nil).Error
+		if err != nil {
+			return err
+		}
+		from, err := json.Marshal(cid)
+		if err != nil {
+			return err
+		}
+		changes := RevisionChanges{"category_id": {From: from, To: json.RawMessage("null")}}
+		for _, pid := range pids {
+			err = recordRevision(tx, pid, RevisionUpdate, c.ActorID, nil, changes)
+			if err != nil {
+				return err
+			}
+		}
+		tx = tx.Debug().Where("id = ?", cid).Delete(&Category{})
+		deleted = tx.RowsAffected
+		return tx.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// Breadcrumb the categories from the root down to this one
+func (c *Category) Breadcrumb(db *gorm.DB) ([]CategoryCrumb, error) {
+	crumbs, err := categoryBreadcrumbs(db, []Category{*c})
+	if err != nil {
+		return nil, err
+	}
+	return crumbs[c.ID], nil
+}
+
+// placeBelow set the path and depth of the category for the parent, nil makes it a root
+func (c *Category) placeBelow(db *gorm.DB, parentID *uuid.UUID) error {
+	if parentID == nil || *parentID == uuid.Nil {
+		c.ParentID = nil
+		c.Path = "/" + c.ID.String() + "/"
+		c.Depth = 0
+		return nil
+	}
+	if *parentID == c.ID {
+		return ErrCategoryCycle
+	}
+	parent := Category{}
+	_, err := parent.FindCategoryByID(db, *parentID)
+	if err == ErrCategoryNotFound {
+		return ErrParentCategoryNotFound
+	}
+	if err != nil {
+		return err
+	}
+	c.Path = parent.Path + c.ID.String() + "/"
+	c.Depth = parent.Depth + 1
+	return nil
+}
+
+// categoryBreadcrumbs the breadcrumb of each category, keyed by its id, loading every ancestor at once
+func categoryBreadcrumbs(db *gorm.DB, categories []Category) (map[uuid.UUID][]CategoryCrumb, error) {
+	ids := []uuid.UUID{}
+	seen := map[uuid.UUID]bool{}
+	for _, category := range categories {
+		for _, id := range pathIDs(category.Path) {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	crumbs := map[uuid.UUID][]CategoryCrumb{}
+	if len(ids) == 0 {
+		return crumbs, nil
+	}
+
+	ancestors := []Category{}
+	err := db.Debug().Model(&Category{}).Where("id IN (?)", ids).Find(&ancestors).Error
+	if err != nil {
+		return nil, err
+	}
+	byID := map[uuid.UUID]Category{}
+	for _, ancestor := range ancestors {
+		byID[ancestor.ID] = ancestor
+	}
+	for _, category := range categories {
+		trail := []CategoryCrumb{}
+		for _, id := range pathIDs(category.Path) {
+			if ancestor, ok := byID[id]; ok {
+				trail = append(trail, CategoryCrumb{ID: ancestor.ID, Name: ancestor.Name, Slug: ancestor.Slug})
+			}
+		}
+		crumbs[category.ID] = trail
+	}
+	return crumbs, nil
+}
+
+func pathIDs(path string) []uuid.UUID {
+	ids := []uuid.UUID{}
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		id, err := uuid.Parse(part)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func pathDepth(path string) int {
+	return len(pathIDs(path)) - 1
+}
+
+// slugify turn a name into a lowercase, dash separated slug, without accents
+func slugify(name string) string {
+	name = strings.ToLower(unaccent(name))
+	var b strings.Builder
+	dash := false
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+var unaccentReplacer = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a", "å", "a",
+	"Á", "A", "À", "A", "Â", "A", "Ã", "A", "Ä", "A", "Å", "A",
+	"é", "e", "è", "e", "ê", "e", "ë", "e", "É", "E", "È", "E", "Ê", "E", "Ë", "E",
+	"í", "i", "ì", "i", "î", "i", "ï", "i", "Í", "I", "Ì", "I", "Î", "I", "Ï", "I",
+	"ó", "o", "ò", "o", "ô", "o", "õ", "o", "ö", "o", "Ó", "O", "Ò", "O", "Ô", "O", "Õ", "O", "Ö", "O",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u", "Ú", "U", "Ù", "U", "Û", "U", "Ü", "U",
+	"ç", "c", "Ç", "C", "ñ", "n", "Ñ", "N",
+)
+
+func unaccent(s string) string {
+	return unaccentReplacer.Replace(s)
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ExchangeRate struct for the rates prices are converted with: one unit of Base is worth
+// Rate units of Currency. Every rate has the same base, the last one uploaded.
+type ExchangeRate struct {
+	Currency  string    `gorm:"size:3;primary_key" json:"currency"`
+	Base      string    `gorm:"size:3;not null" json:"base"`
+	Rate      float64   `gorm:"not null" json:"rate"`
+	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// FindAllExchangeRates get every ExchangeRate
+func (e *ExchangeRate) FindAllExchangeRates(db *gorm.DB) (*[]ExchangeRate, error) {
+	rates := []ExchangeRate{}
+	err := db.Debug().Model(&ExchangeRate{}).Order("currency").Find(&rates).Error
+	if err != nil {
+		return &[]ExchangeRate{}, err
+	}
+	return &rates, nil
+}
+
+// ReplaceExchangeRates replace every ExchangeRate by the rates of the base, all at once
+func (e *ExchangeRate) ReplaceExchangeRates(db *gorm.DB, base string, rates map[string]float64, now time.Time) error {
+	return transaction(db, func(tx *gorm.DB) error {
+		err := tx.Debug().Delete(&ExchangeRate{}).Error
+		if err != nil {
+			return err
+		}
+		for currency, rate := range rates {
+			err = tx.Debug().Create(&ExchangeRate{Currency: currency, Base: base, Rate: rate, UpdatedAt: now}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// The state of the renditions of a ProductImage
+const (
+	// RenditionsPending the renditions are waiting to be made, or made again
+	RenditionsPending = "pending"
+	// RenditionsReady every rendition of the current presets is made
+	RenditionsReady = "ready"
+	// RenditionsFailed the image could not be resized, it is shown as uploaded
+	RenditionsFailed = "failed"
+	// RenditionsUnsupported the image is of a type that cannot be resized, WebP
+	RenditionsUnsupported = "unsupported"
+)
+
+// ImageRendition struct for a resized copy of a ProductImage, made for one size preset in one format
+type ImageRendition struct {
+	ID          uuid.UUID `gorm:"primary_key" json:"-"`
+	ImageID     uuid.UUID `gorm:"not null;index" json:"-"`
+	Preset      string    `gorm:"size:50;not null" json:"preset"`
+	ContentType string    `gorm:"size:100;not null" json:"content_type"`
+	Key         string    `gorm:"column:blob_key;size:500;not null" json:"-"`
+	URL         string    `gorm:"size:2000;not null" json:"url"`
+	Width       int       `gorm:"not null" json:"width"`
+	Height      int       `gorm:"not null" json:"height"`
+	Size        int64     `gorm:"not null" json:"size"`
+	CreatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"-"`
+}
+
+// BlobKeys the keys of the image and of its renditions in the blob store
+func (i *ProductImage) BlobKeys() []string {
+	keys := []string{i.Key}
+	for _, rendition := range i.Renditions {
+		keys = append(keys, rendition.Key)
+	}
+	return keys
+}
+
+// fillSrcset set Srcset from the renditions, one srcset attribute per content type, narrowest first
+func (i *ProductImage) fillSrcset() {
+	if i.Renditions == nil {
+		i.Renditions = []ImageRendition{}
+	}
+	renditions := make([]ImageRendition, len(i.Renditions))
+	copy(renditions, i.Renditions)
+	sort.SliceStable(renditions, func(a, b int) bool {
+		return renditions[a].Width < renditions[b].Width
+	})
+	candidates := map[string][]string{}
+	for _, rendition := range renditions {
+		candidates[rendition.ContentType] = append(candidates[rendition.ContentType], fmt.Sprintf("%s %dw", rendition.URL, rendition.Width))
+	}
+	i.Srcset = map[string]string{}
+	for contentType, list := range candidates {
+		i.Srcset[contentType] = strings.Join(list, ", ")
+	}
+}
+
+// FindPendingImages get up to limit images waiting for their renditions, oldest first
+func (i *ProductImage) FindPendingImages(db *gorm.DB, limit int) (*[]ProductImage, error) {
+	images := []ProductImage{}
+	err := db.Debug().Model(&ProductImage{}).Where("rendition_status = ?", RenditionsPending).Order("created_at").Limit(limit).Find(&images).Error
+	if err != nil {
+		return &[]ProductImage{}, err
+	}
+	return &images, nil
+}
+
+// ReplaceRenditions swap the renditions of the image for new ones and set its status. It returns
+// the renditions replaced, whose blobs are left to the caller, or ErrImageNotFound when the image
+// was deleted in the meantime. When the image was queued again since it was read, nothing is
+// replaced: it returns ErrRenditionsStale with the renditions the image keeps, and the image
+// stays pending.
+func (i *ProductImage) ReplaceRenditions(db *gorm.DB, renditions []ImageRendition, status string) ([]ImageRendition, error) {
+	replaced := []ImageRendition{}
+	err := transaction(db, func(tx *gorm.DB) error {
+		result := tx.Debug().Model(&ProductImage{}).Where("id = ? AND rendition_generation = ?", i.ID, i.RenditionGeneration).
+			UpdateColumn("rendition_status", status)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			count := 0
+			err := tx.Debug().Model(&ProductImage{}).Where("id = ?", i.ID).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				return ErrImageNotFound
+			}
+			err = tx.Debug().Where("image_id = ?", i.ID).Find(&replaced).Error
+			if err != nil {
+				return err
+			}
+			return ErrRenditionsStale
+		}
+		err := tx.Debug().Where("image_id = ?", i.ID).Find(&replaced).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Debug().Where("image_id = ?", i.ID).Delete(&ImageRendition{}).Error
+		if err != nil {
+			return err
+		}
+		for j := range renditions {
+			renditions[j].ID = uuid.Must(uuid.NewRandom())
+			renditions[j].ImageID = i.ID
+			renditions[j].CreatedAt = time.Now()
+			err = tx.Debug().Create(&renditions[j]).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == ErrRenditionsStale {
+		return replaced, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	i.RenditionStatus = status
+	i.Renditions = renditions
+	i.fillSrcset()
+	return replaced, nil
+}
+
+// MarkImagesPending queue the images of the product, or every image when pid is nil, to have
+// their renditions made again. Renditions being made for them meanwhile are dropped.
+func MarkImagesPending(db *gorm.DB, pid *uuid.UUID) (int64, error) {
+	query := db.Debug().Model(&ProductImage{})
+	if pid != nil {
+		query = query.Where("product_id = ?", *pid)
+	}
+	result := query.UpdateColumns(map[string]interface{}{
+		"rendition_status":     RenditionsPending,
+		"rendition_generation": gorm.Expr("rendition_generation + 1"),
+	})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// LoginAttempt struct for the failed logins counted per account or per IP, for the login guard
+type LoginAttempt struct {
+	Key           string     `gorm:"column:attempt_key;size:255;primary_key" json:"key"`
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt time.Time  `gorm:"not null" json:"last_failure_at"`
+	LockedUntil   *time.Time `gorm:"null" json:"locked_until"`
+}
+
+// FindLoginAttempt find the LoginAttempt of the key, an empty one when there is none
+func (a *LoginAttempt) FindLoginAttempt(db *gorm.DB, key string) (*LoginAttempt, error) {
+	err := db.Debug().Model(LoginAttempt{}).Where("attempt_key = ?", key).Take(&a).Error
+	if gorm.IsRecordNotFoundError(err) {
+		*a = LoginAttempt{Key: key}
+		return a, nil
+	}
+	if err != nil {
+		return &LoginAttempt{}, err
+	}
+	return a, nil
+}
+
+// RecordLoginFailure count a failure of the key, failures before since start over from one.
+// The counter is incremented in the database, so concurrent failures are all counted.
+func (a *LoginAttempt) RecordLoginFailure(db *gorm.DB, key string, now, since time.Time) (*LoginAttempt, error) {
+	update := func() (int64, error) {
+		tx := db.Debug().Model(&LoginAttempt{}).Where("attempt_key = ?", key).UpdateColumns(
+			map[string]interface{}{
+				"failures":        gorm.Expr("CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END", since),
+				"last_failure_at": now,
+			},
+		)
+		return tx.RowsAffected, tx.Error
+	}
+	updated, err := update()
+	if err != nil {
+		return &LoginAttempt{}, err
+	}
+	if updated == 0 {
+		err = db.Debug().Create(&LoginAttempt{Key: key, Failures: 1, LastFailureAt: now}).Error
+		if err != nil {
+			// Another request created the row first, count on it instead
+			_, err = update()
+			if err != nil {
+				return &LoginAttempt{}, err
+			}
+		}
+	}
+	err = db.Debug().Model(LoginAttempt{}).Where("attempt_key = ?", key).Take(&a).Error
+	if err != nil {
+		return &LoginAttempt{}, err
+	}
+	return a, nil
+}
+
+// LockLoginAttempt refuse every login of the key until the given time
+func (a *LoginAttempt) LockLoginAttempt(db *gorm.DB, key string, until time.Time) error {
+	return db.Debug().Model(&LoginAttempt{}).Where("attempt_key = ?", key).UpdateColumn("locked_until", until).Error
+}
+
+// DeleteLoginAttempt forget the failures of the key
+func (a *LoginAttempt) DeleteLoginAttempt(db *gorm.DB, key string) error {
+	return db.Debug().Where("attempt_key = ?", key).Delete(&LoginAttempt{}).Error
+}
+
+// ExpireLoginAttempts delete the attempts whose last failure is before the time and that are not
+// locked at now, it returns how many were deleted
+func ExpireLoginAttempts(db *gorm.DB, lastFailureBefore, now time.Time) (int64, error) {
+	result := db.Debug().Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until <= ?)", lastFailureBefore, now).
+		Delete(&LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// PurposePasswordReset a token sent to reset a forgotten password
+	PurposePasswordReset = "password_reset"
+	// PurposeEmailVerification a token sent to prove the user owns their email
+	PurposeEmailVerification = "email_verification"
+)
+
+// ErrOneTimeTokenInvalid the token is unknown, expired or already used
+var ErrOneTimeTokenInvalid = errors.New("Invalid Or Expired Token")
+
+// OneTimeToken struct for single-use, time-limited tokens sent by email. Only the hash is stored.
+type OneTimeToken struct {
+	ID        uuid.UUID  `gorm:"primary_key" json:"id"`
+	UserID    uuid.UUID  `gorm:"not null;index" json:"user_id"`
+	Purpose   string     `gorm:"size:50;not null" json:"purpose"`
+	TokenHash string     `gorm:"size:64;not null;unique_index" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `gorm:"null" json:"used_at"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// SaveOneTimeToken save OneTimeToken, the earlier unused tokens of the same purpose stop working
+func (t *OneTimeToken) SaveOneTimeToken(db *gorm.DB) (*OneTimeToken, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Debug().Model(&OneTimeToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", t.UserID, t.Purpose).
+			UpdateColumn("expires_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Debug().Create(&t).Error
+	})
+	if err != nil {
+		return &OneTimeToken{}, err
+	}
+	return t, nil
+}
+
+// ConsumeOneTimeToken mark the token as used and return it.
+// The update is conditional, so a token can only be consumed once.
+func (t *OneTimeToken) ConsumeOneTimeToken(db *gorm.DB, purpose, hash string) (*OneTimeToken, error) {
+	now := time.Now()
+	err := db.Debug().Model(OneTimeToken{}).Where("token_hash = ? AND purpose = ?", hash, purpose).Take(&t).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &OneTimeToken{}, ErrOneTimeTokenInvalid
+	}
+	if err != nil {
+		return &OneTimeToken{}, err
+	}
+
+	db = db.Debug().Model(&OneTimeToken{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", t.ID, now).
+		UpdateColumn("used_at", now)
+	if db.Error != nil {
+		return &OneTimeToken{}, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return &OneTimeToken{}, ErrOneTimeTokenInvalid
+	}
+	t.UsedAt = &now
+	return t, nil
+}
@@ -0,0 +1,347 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+
+	"github.com/arikardnoir/asiwaju/api/money"
+)
+
+const (
+	// PriceIntervalDay buckets of a day, in UTC
+	PriceIntervalDay = "day"
+	// PriceIntervalWeek buckets of a week, from Monday
+	PriceIntervalWeek = "week"
+	// PriceIntervalMonth buckets of a calendar month
+	PriceIntervalMonth = "month"
+)
+
+// MaxPriceChanges the most price changes listed at once, the next ones are paged after the last
+const MaxPriceChanges = 1000
+
+// DefaultPriceBuckets how many buckets back a price history goes when it is given no start
+const DefaultPriceBuckets = 90
+
+// MaxPriceBuckets the most buckets a price history is aggregated in
+const MaxPriceBuckets = 1000
+
+// MaxPriceMovers the most products a price movers report lists
+const MaxPriceMovers = 100
+
+var (
+	// ErrInvalidPriceInterval the aggregation is not by day, week or month
+	ErrInvalidPriceInterval = errors.New("Invalid Interval, expected day, week or month")
+	// ErrPriceRangeTooLong the range has more than MaxPriceBuckets buckets of the interval
+	ErrPriceRangeTooLong = errors.New("Range Too Long")
+	// ErrPriceChangeNotFound the change to page after does not exist or is of another product
+	ErrPriceChangeNotFound = errors.New("Price Change Not Found")
+)
+
+// PriceChange struct for a price a Product had from ChangedAt on, until its next change. The
+// price history has one for every change of the price, the first for the price it was created
+// with.
+type PriceChange struct {
+	ID        uuid.UUID   `gorm:"primary_key" json:"id"`
+	ProductID uuid.UUID   `gorm:"not null;index:idx_price_history_product_changed_at" json:"product_id"`
+	Price     money.Money `gorm:"embedded;embedded_prefix:price_" json:"price"`
+	ChangedAt time.Time   `gorm:"not null;index:idx_price_history_product_changed_at" json:"changed_at"`
+}
+
+// TableName the table of the price history
+func (PriceChange) TableName() string {
+	return "price_history"
+}
+
+// PriceBucket the prices of a Product during a day, week or month. Open is the price it started
+// with, Close the one it ended with, Low and High the extremes among the prices in the currency
+// of Close.
+type PriceBucket struct {
+	Start   time.Time   `json:"start"`
+	Open    money.Money `json:"open"`
+	Close   money.Money `json:"close"`
+	Low     money.Money `json:"low"`
+	High    money.Money `json:"high"`
+	Changes int         `json:"changes"`
+}
+
+// PriceMover a Product whose price moved over a period, from the price it had at the start to the
+// one it had at the end
+type PriceMover struct {
+	ProductID     uuid.UUID   `json:"product_id"`
+	SKU           *string     `json:"sku"`
+	Name          string      `json:"name"`
+	From          money.Money `json:"from"`
+	To            money.Money `json:"to"`
+	Change        money.Money `json:"change"`
+	ChangePercent float64     `json:"change_percent"`
+}
+
+// recordPriceChange add the price the product has from the time on to its history
+func recordPriceChange(tx *gorm.DB, pid uuid.UUID, price money.Money, changedAt time.Time) error {
+	change := PriceChange{
+		ID:        uuid.Must(uuid.NewRandom()),
+		ProductID: pid,
+		Price:     price,
+		ChangedAt: changedAt,
+	}
+	return tx.Debug().Create(&change).Error
+}
+
+// FindPriceChanges get up to limit changes of the price of the Product between the times, oldest
+// first, after the change whose ID is given, if any. A nil time leaves the range open on that side.
+// It tells whether more changes follow the ones returned.
+func FindPriceChanges(db *gorm.DB, pid uuid.UUID, from, to *time.Time, after *uuid.UUID, limit int) (*[]PriceChange, bool, error) {
+	if limit <= 0 || limit > MaxPriceChanges {
+		limit = MaxPriceChanges
+	}
+	query := db.Debug().Model(&PriceChange{}).Where("product_id = ?", pid)
+	if from != nil {
+		query = query.Where("changed_at >= ?", *from)
+	}
+	if to != nil {
+		query = query.Where("changed_at <= ?", *to)
+	}
+	if after != nil {
+		last := PriceChange{}
+		err := db.Debug().Model(&PriceChange{}).Where("id = ? AND product_id = ?", *after, pid).Take(&last).Error
+		if gorm.IsRecordNotFoundError(err) {
+			return &[]PriceChange{}, false, ErrPriceChangeNotFound
+		}
+		if err != nil {
+			return &[]PriceChange{}, false, err
+		}
+		// Changes at the same time are ordered by their ID
+		query = query.Where("changed_at > ? OR (changed_at = ? AND id > ?)", last.ChangedAt, last.ChangedAt, last.ID)
+	}
+	changes := []PriceChange{}
+	err := query.Order("changed_at, id").Limit(limit + 1).Find(&changes).Error
+	if err != nil {
+		return &[]PriceChange{}, false, err
+	}
+	more := len(changes) > limit
+	if more {
+		changes = changes[:limit]
+	}
+	return &changes, more, nil
+}
+
+// DefaultPriceHistoryStart the start of a price history of the interval ending at the time,
+// DefaultPriceBuckets buckets back
+func DefaultPriceHistoryStart(end time.Time, interval string) time.Time {
+	switch interval {
+	case PriceIntervalWeek:
+		return end.AddDate(0, 0, -7*(DefaultPriceBuckets-1))
+	case PriceIntervalMonth:
+		return end.AddDate(0, -(DefaultPriceBuckets - 1), 0)
+	default:
+		return end.AddDate(0, 0, -(DefaultPriceBuckets - 1))
+	}
+}
+
+// AggregatePriceHistory the prices of the Product in buckets of the interval, from the one the
+// from time is in to the one the to time is in. Buckets before the product had a price are left
+// out, the ones without a change carry the price on.
+func AggregatePriceHistory(db *gorm.DB, pid uuid.UUID, from, to time.Time, interval string) ([]PriceBucket, error) {
+	starts, err := priceBucketStarts(from, to, interval)
+	if err != nil {
+		return nil, err
+	}
+	end := nextPriceBucket(starts[len(starts)-1], interval)
+
+	// The price the product had when the first bucket starts, if it had one
+	previous := []PriceChange{}
+	err = db.Debug().Model(&PriceChange{}).Where("product_id = ? AND changed_at < ?", pid, starts[0]).
+		Order("changed_at desc").Limit(1).Find(&previous).Error
+	if err != nil {
+		return nil, err
+	}
+	changes := []PriceChange{}
+	err = db.Debug().Model(&PriceChange{}).Where("product_id = ? AND changed_at >= ? AND changed_at < ?", pid, starts[0], end).
+		Order("changed_at").Find(&changes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := []PriceBucket{}
+	var current *money.Money
+	if len(previous) > 0 {
+		current = &previous[0].Price
+	}
+	next := 0
+	for _, start := range starts {
+		bucketEnd := nextPriceBucket(start, interval)
+		prices := []money.Money{}
+		if current != nil {
+			prices = append(prices, *current)
+		}
+		count := 0
+		for ; next < len(changes) && changes[next].ChangedAt.Before(bucketEnd); next++ {
+			prices = append(prices, changes[next].Price)
+			current = &changes[next].Price
+			count++
+		}
+		if len(prices) == 0 {
+			continue
+		}
+		bucket := PriceBucket{
+			Start:   start,
+			Open:    prices[0],
+			Close:   prices[len(prices)-1],
+			Changes: count,
+		}
+		bucket.Low, bucket.High = bucket.Close, bucket.Close
+		for _, price := range prices {
+			if price.Currency != bucket.Close.Currency {
+				continue
+			}
+			if price.Amount < bucket.Low.Amount {
+				bucket.Low = price
+			}
+			if price.Amount > bucket.High.Amount {
+				bucket.High = price
+			}
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// FindPriceMovers the products of the owner whose price moved the most between the times, by
+// percentage. A product created during the period moves from the price it was created with.
+// Products whose price changed currency are left out, their moves cannot be compared.
+func FindPriceMovers(db *gorm.DB, oid uuid.UUID, from, to time.Time, limit int) ([]PriceMover, error) {
+	if limit <= 0 || limit > MaxPriceMovers {
+		limit = MaxPriceMovers
+	}
+	// The price every product had at the end, then at the start
+	ends, err := findOwnerPriceChanges(db, oid, "SELECT MAX(latest.changed_at) FROM price_history latest WHERE latest.product_id = price_history.product_id AND latest.changed_at <= ?", to)
+	if err != nil {
+		return nil, err
+	}
+	starts, err := findOwnerPriceChanges(db, oid, "SELECT MAX(latest.changed_at) FROM price_history latest WHERE latest.product_id = price_history.product_id AND latest.changed_at <= ?", from)
+	if err != nil {
+		return nil, err
+	}
+	// The products created during the period start from their first price
+	created, err := findOwnerPriceChanges(db, oid, "SELECT MIN(earliest.changed_at) FROM price_history earliest WHERE earliest.product_id = price_history.product_id AND earliest.changed_at > ?", from)
+	if err != nil {
+		return nil, err
+	}
+	startOf := map[uuid.UUID]money.Money{}
+	for _, change := range created {
+		startOf[change.ProductID] = change.Price
+	}
+	for _, change := range starts {
+		startOf[change.ProductID] = change.Price
+	}
+
+	movers := []PriceMover{}
+	for _, end := range ends {
+		start, ok := startOf[end.ProductID]
+		if !ok || start.Currency != end.Price.Currency || start.Amount == end.Price.Amount || start.Amount == 0 {
+			continue
+		}
+		mover := PriceMover{ProductID: end.ProductID, From: start, To: end.Price}
+		mover.Change = money.New(mover.To.Amount-mover.From.Amount, mover.To.Currency)
+		percent := float64(mover.Change.Amount) / float64(mover.From.Amount) * 100
+		mover.ChangePercent = math.Round(percent*100) / 100
+		movers = append(movers, mover)
+	}
+
+	sort.SliceStable(movers, func(i, j int) bool {
+		return math.Abs(movers[i].ChangePercent) > math.Abs(movers[j].ChangePercent)
+	})
+	if len(movers) > limit {
+		movers = movers[:limit]
+	}
+	if len(movers) == 0 {
+		return movers, nil
+	}
+
+	ids := []uuid.UUID{}
+	for _, m := range movers {
+		ids = append(ids, m.ProductID)
+	}
+	products := []Product{}
+	err = db.Debug().Model(&Product{}).Where("id IN (?)", ids).Find(&products).Error
+	if err != nil {
+		return nil, err
+	}
+	byID := map[uuid.UUID]Product{}
+	for _, p := range products {
+		byID[p.ID] = p
+	}
+	for i := range movers {
+		movers[i].SKU = byID[movers[i].ProductID].SKU
+		movers[i].Name = byID[movers[i].ProductID].Name
+	}
+	return movers, nil
+}
+
+// findOwnerPriceChanges the change of each product of the owner made at the time the subquery
+// picks for it, by product
+func findOwnerPriceChanges(db *gorm.DB, oid uuid.UUID, pick string, at time.Time) ([]PriceChange, error) {
+	changes := []PriceChange{}
+	err := db.Debug().Model(&PriceChange{}).
+		Where("product_id IN (SELECT id FROM products WHERE owner_id = ? AND deleted_at IS NULL)", oid).
+		Where("changed_at = ("+pick+")", at).
+		Order("product_id, changed_at, id").Find(&changes).Error
+	if err != nil {
+		return nil, err
+	}
+	// Of changes at the same time, the one ordered last wins
+	picked := []PriceChange{}
+	for _, change := range changes {
+		if n := len(picked); n > 0 && picked[n-1].ProductID == change.ProductID {
+			picked[n-1] = change
+			continue
+		}
+		picked = append(picked, change)
+	}
+	return picked, nil
+}
+
+// priceBucketStarts the start of every bucket of the interval from the time to the other
+func priceBucketStarts(from, to time.Time, interval string) ([]time.Time, error) {
+	from = from.UTC()
+	var start time.Time
+	switch interval {
+	case PriceIntervalDay:
+		start = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	case PriceIntervalWeek:
+		start = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	case PriceIntervalMonth:
+		start = time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return nil, ErrInvalidPriceInterval
+	}
+	starts := []time.Time{}
+	for ; !start.After(to); start = nextPriceBucket(start, interval) {
+		if len(starts) == MaxPriceBuckets {
+			return nil, ErrPriceRangeTooLong
+		}
+		starts = append(starts, start)
+	}
+	if len(starts) == 0 {
+		starts = append(starts, start)
+	}
+	return starts, nil
+}
+
+// nextPriceBucket the start of the bucket after the one starting at the time
+func nextPriceBucket(start time.Time, interval string) time.Time {
+	switch interval {
+	case PriceIntervalWeek:
+		return start.AddDate(0, 0, 7)
+	case PriceIntervalMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
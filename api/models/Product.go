@@ -1,29 +1,51 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
 	"html"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jinzhu/gorm"
+
+	"github.com/arikardnoir/asiwaju/api/money"
 )
 
-// Product struct for Product
+// Product struct for Product. SKU and ExternalID identify it in the owner's own systems,
+// imports update the products they match. Deleting it sets DeletedAt, the finders leave it out
+// until it is restored or purged from the trash. Every change is recorded as a ProductRevision
+// by ActorID, the user making it. Sizes are variants, the old size string is neither read from
+// requests nor written any more.
 type Product struct {
-	ID          uuid.UUID `gorm:"primary_key;auto_increment" json:"id"`
-	Name        string    `gorm:"size:255;not null" json:"name"`
-	Brand       string    `gorm:"size:255;not null" json:"brand"`
-	Image       string    `gorm:"size:2000;null" json:"image"`
-	Size        string    `gorm:"size:200;null" json:"size"`
-	Model       string    `gorm:"size:255;null" json:"model"`
-	Price       float64   `gorm:"default:0.00;null" json:"price"`
-	OwnerID     uuid.UUID `gorm:"not null" json:"owner_id"`
-	ExpDate     time.Time `gorm:"null" json:"exp_date"`
-	Description string    `gorm:"size:2000;null" json:"description"`
-	CreatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ID             uuid.UUID         `gorm:"primary_key;auto_increment" json:"id"`
+	SKU            *string           `gorm:"size:100;unique_index:idx_products_owner_sku" json:"sku"`
+	ExternalID     *string           `gorm:"size:255;unique_index:idx_products_owner_external_id" json:"external_id"`
+	Name           string            `gorm:"size:255;not null" json:"name"`
+	Brand          string            `gorm:"size:255;not null" json:"brand"`
+	Image          string            `gorm:"size:2000;null" json:"image"`
+	Size           string            `gorm:"size:200;null" json:"-"` // Only read by the migration to variants
+	Model          string            `gorm:"size:255;null" json:"model"`
+	Price          money.Money       `gorm:"embedded;embedded_prefix:price_" json:"price"`
+	ConvertedPrice *money.Conversion `gorm:"-" json:"converted_price,omitempty"`
+	OwnerID        uuid.UUID         `gorm:"not null;unique_index:idx_products_owner_sku,idx_products_owner_external_id" json:"owner_id"`
+	ExpDate        time.Time         `gorm:"null" json:"exp_date"`
+	Description    string            `gorm:"size:2000;null" json:"description"`
+	Public         bool              `gorm:"not null;default:false;index" json:"public"`
+	Stock          int               `gorm:"not null;default:0" json:"stock"`
+	Reserved       int               `gorm:"not null;default:0" json:"reserved"`
+	CategoryID     *uuid.UUID        `gorm:"null;index" json:"category_id"`
+	Tags           []Tag             `gorm:"many2many:product_tags;association_autoupdate:false;association_autocreate:false" json:"tags"`
+	TagIDs         []uuid.UUID       `gorm:"-" json:"tag_ids,omitempty"`
+	Breadcrumb     []CategoryCrumb   `gorm:"-" json:"breadcrumb"`
+	Variants       []ProductVariant  `gorm:"foreignkey:ProductID;association_autoupdate:false;association_autocreate:false" json:"variants"`
+	Images         []ProductImage    `gorm:"foreignkey:ProductID;association_autoupdate:false;association_autocreate:false" json:"images"`
+	CreatedAt      time.Time         `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt      time.Time         `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	DeletedAt      *time.Time        `gorm:"index" json:"deleted_at,omitempty"`
+	ActorID        *uuid.UUID        `gorm:"-" json:"-"`
 }
 
 // ResponseProduct return for the struct Product
@@ -32,12 +54,12 @@ type ResponseProduct struct {
 	Name        string
 	Brand       string
 	Image       string
-	Size        string
 	Model       string
-	Price       float64
+	Price       money.Money
 	ExpDate     time.Time
 	OwnerID     uuid.UUID
 	Description string
+	Public      bool
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
@@ -49,25 +71,115 @@ func SanitizeProduct(p Product) ResponseProduct {
 		p.Name,
 		p.Brand,
 		p.Image,
-		p.Size,
 		p.Model,
 		p.Price,
 		p.ExpDate,
 		p.OwnerID,
 		p.Description,
+		p.Public,
 		p.CreatedAt,
 		p.UpdatedAt,
 	}
 }
 
+// CatalogProduct the public view of a Product, for anonymous visitors of the catalog
+type CatalogProduct struct {
+	ID             uuid.UUID         `json:"id"`
+	Name           string            `json:"name"`
+	Brand          string            `json:"brand"`
+	Image          string            `json:"image"`
+	Model          string            `json:"model"`
+	Price          money.Money       `json:"price"`
+	ConvertedPrice *money.Conversion `json:"converted_price,omitempty"`
+	ExpDate        time.Time         `json:"exp_date"`
+	Description    string            `json:"description"`
+	Breadcrumb     []CategoryCrumb   `json:"breadcrumb"`
+	Tags           []string          `json:"tags"`
+	Available      int               `json:"available"`
+	Variants       []CatalogVariant  `json:"variants"`
+	Images         []CatalogImage    `json:"images"`
+}
+
+// CatalogVariant the public view of a ProductVariant, what it sells for and how many are left
+type CatalogVariant struct {
+	ID             uuid.UUID         `json:"id"`
+	Attributes     VariantAttributes `json:"attributes"`
+	Price          money.Money       `json:"price"`
+	ConvertedPrice *money.Conversion `json:"converted_price,omitempty"`
+	Available      int               `json:"available"`
+}
+
+// CatalogImage the public view of a ProductImage, enough to show it
+type CatalogImage struct {
+	URL    string            `json:"url"`
+	Srcset map[string]string `json:"srcset"`
+	Width  int               `json:"width"`
+	Height int               `json:"height"`
+}
+
+// CatalogView the catalog response of the Product, without its owner and bookkeeping
+func CatalogView(p Product) CatalogProduct {
+	tags := []string{}
+	for _, tag := range p.Tags {
+		tags = append(tags, tag.Name)
+	}
+	variants := []CatalogVariant{}
+	for _, v := range p.Variants {
+		variants = append(variants, CatalogVariant{
+			ID:             v.ID,
+			Attributes:     v.Attributes,
+			Price:          v.Price(p),
+			ConvertedPrice: v.ConvertedPrice,
+			Available:      v.Stock - v.Reserved,
+		})
+	}
+	images := []CatalogImage{}
+	for _, i := range p.Images {
+		images = append(images, CatalogImage{URL: i.URL, Srcset: i.Srcset, Width: i.Width, Height: i.Height})
+	}
+	return CatalogProduct{
+		ID:             p.ID,
+		Name:           p.Name,
+		Brand:          p.Brand,
+		Image:          p.Image,
+		Model:          p.Model,
+		Price:          p.Price,
+		ConvertedPrice: p.ConvertedPrice,
+		ExpDate:        p.ExpDate,
+		Description:    p.Description,
+		Breadcrumb:     p.Breadcrumb,
+		Tags:           tags,
+		Available:      p.Stock - p.Reserved,
+		Variants:       variants,
+		Images:         images,
+	}
+}
+
 // Prepare set value for Product
 func (p *Product) Prepare() {
 	p.Name = html.EscapeString(strings.TrimSpace(p.Name))
 	p.Brand = html.EscapeString(strings.TrimSpace(p.Brand))
-	p.Image = html.EscapeString(strings.TrimSpace(p.Image))
-	p.Size = html.EscapeString(strings.TrimSpace(p.Size))
+	// A URL, escaping it would break its query string. It is checked by Validate and
+	// escaped by whoever writes it into HTML.
+	p.Image = strings.TrimSpace(p.Image)
+	p.Size = ""
 	p.Model = html.EscapeString(strings.TrimSpace(p.Model))
 	p.Description = html.EscapeString(strings.TrimSpace(p.Description))
+	p.SKU = optionalString(p.SKU, strings.ToUpper)
+	p.ExternalID = optionalString(p.ExternalID, nil)
+	// Tags are set through TagIDs, the breadcrumb follows the category,
+	// variants and images have their own routes
+	p.Tags = nil
+	p.Breadcrumb = nil
+	p.Variants = nil
+	p.Images = nil
+	// The stock only moves through the ledger, a new product may start with some
+	p.Reserved = 0
+	// Products go to the trash only through DeleteAProduct
+	p.DeletedAt = nil
+	if p.CategoryID != nil && *p.CategoryID == uuid.Nil {
+		p.CategoryID = nil
+	}
 	p.CreatedAt = time.Now()
 	p.UpdatedAt = time.Now()
 }
@@ -82,13 +194,16 @@ func (p *Product) Validate(action string) error {
 		if p.Brand == "" {
 			return errors.New("Required Brand")
 		}
-		if p.Image == "" {
-			return errors.New("Required Image")
+		if p.Image != "" && !validImageURL(p.Image) {
+			return errors.New("Invalid Image URL")
 		}
-		if p.Price == 0.00 {
+		if p.Price.IsZero() || p.Price.Amount == 0 {
 			return errors.New("Required Price")
 		}
-		return nil
+		if err := p.Price.Validate(); err != nil {
+			return err
+		}
+		return p.validateIdentifiers()
 
 	default:
 		if p.Name == "" {
@@ -97,20 +212,78 @@ func (p *Product) Validate(action string) error {
 		if p.Brand == "" {
 			return errors.New("Required Brand")
 		}
-		if p.Image == "" {
-			return errors.New("Required Image")
+		if p.Image != "" && !validImageURL(p.Image) {
+			return errors.New("Invalid Image URL")
 		}
-		if p.Price == 0.00 {
+		if p.Price.IsZero() || p.Price.Amount == 0 {
 			return errors.New("Required Price")
 		}
+		if err := p.Price.Validate(); err != nil {
+			return err
+		}
+		return p.validateIdentifiers()
+	}
+}
+
+// optionalString trim the value and apply normalize to it, an empty value is no value
+func optionalString(value *string, normalize func(string) string) *string {
+	if value == nil {
+		return nil
+	}
+	s := strings.TrimSpace(*value)
+	if normalize != nil {
+		s = normalize(s)
+	}
+	if s == "" {
 		return nil
 	}
+	return &s
+}
+
+// validateIdentifiers check the lengths of the SKU and the external id
+func (p *Product) validateIdentifiers() error {
+	if p.SKU != nil && len(*p.SKU) > 100 {
+		return errors.New("Invalid SKU")
+	}
+	if p.ExternalID != nil && len(*p.ExternalID) > 255 {
+		return errors.New("Invalid External ID")
+	}
+	return nil
 }
 
-// SaveProduct save Product
+// validImageURL an absolute http or https URL
+func validImageURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// SaveProduct save Product, the stock it starts with is received in the ledger and its price
+// starts its price history
 func (p *Product) SaveProduct(db *gorm.DB) (*Product, error) {
 
-	err := db.Debug().Create(&p).Error
+	err := transaction(db, func(tx *gorm.DB) error {
+		stock := p.Stock
+		p.Stock = 0
+		err := tx.Debug().Create(&p).Error
+		if err != nil {
+			return err
+		}
+		err = recordRevision(tx, p.ID, RevisionCreate, p.ActorID, nil, diffProductStates(productState{}, productStateOf(*p)))
+		if err != nil {
+			return err
+		}
+		err = recordPriceChange(tx, p.ID, p.Price, p.CreatedAt)
+		if err != nil || stock <= 0 {
+			return err
+		}
+		movement := StockMovement{ProductID: p.ID, Kind: MovementReceive, Quantity: stock, Note: "Initial stock"}
+		err = applyStockMovement(tx, &movement, stock, 0)
+		p.Stock = stock
+		return err
+	})
 	if err != nil {
 		return &Product{}, err
 	}
@@ -142,53 +315,377 @@ func (p *Product) FindProductByID(db *gorm.DB, pid uuid.UUID, oid uuid.UUID) (*P
 	return p, err
 }
 
+// FindAnyProductByID find Product by id whoever the owner is
+func (p *Product) FindAnyProductByID(db *gorm.DB, pid uuid.UUID) (*Product, error) {
+	err := db.Debug().Model(Product{}).Where("id = ?", pid).Take(&p).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Product{}, errors.New("Product Not Found")
+	}
+	if err != nil {
+		return &Product{}, err
+	}
+	return p, nil
+}
+
 // UpdateAProduct update Product
 func (p *Product) UpdateAProduct(db *gorm.DB, pid uuid.UUID) (*Product, error) {
+	return p.updateProduct(db, pid, RevisionUpdate, nil)
+}
 
-	db = db.Debug().Model(&Product{}).Where("id = ?", pid).Take(&Product{}).UpdateColumns(
-		map[string]interface{}{
-			"name":        p.Name,
-			"brand":       p.Brand,
-			"image":       p.Image,
-			"size":        p.Size,
-			"model":       p.Model,
-			"price":       p.Price,
-			"exp_date":    p.ExpDate,
-			"description": p.Description,
-			"updated_at":  time.Now(),
-		},
-	)
-	if db.Error != nil {
-		return &Product{}, db.Error
-	}
-	// This is the display the updated Product
-	err := db.Debug().Model(&Product{}).Where("id = ?", pid).Take(&p).Error
+// RevertAProduct set the Product back to how it was right after the revision, as a new revision.
+// Its category must still exist, ErrCategoryNotFound otherwise, and its SKU and external ID must
+// not have been taken by another product since, ErrRevertSKUTaken or ErrRevertExternalIDTaken.
+func (p *Product) RevertAProduct(db *gorm.DB, pid uuid.UUID, number int) (*Product, error) {
+
+	actorID := p.ActorID
+	var reverted *Product
+	err := transaction(db, func(tx *gorm.DB) error {
+		current := Product{}
+		err := tx.Debug().Model(&Product{}).Where("id = ?", pid).Take(&current).Error
+		if err != nil {
+			return err
+		}
+		state, err := productStateAt(tx, current, number)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(data, &current)
+		if err != nil {
+			return err
+		}
+		if current.CategoryID != nil {
+			category := Category{}
+			_, err = category.FindCategoryByID(tx, *current.CategoryID)
+			if err != nil {
+				return err
+			}
+		}
+		taken, err := productKeyTaken(tx, current, "sku", current.SKU)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrRevertSKUTaken
+		}
+		taken, err = productKeyTaken(tx, current, "external_id", current.ExternalID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrRevertExternalIDTaken
+		}
+		current.ActorID = actorID
+		reverted, err = current.updateProduct(tx, pid, RevisionRevert, &number)
+		return err
+	})
 	if err != nil {
 		return &Product{}, err
 	}
+	return reverted, nil
+}
 
+// updateProduct write the columns of the Product and record the fields that changed, if any, as
+// a revision of the action. A new price goes in the price history too.
+func (p *Product) updateProduct(db *gorm.DB, pid uuid.UUID, action string, reverts *int) (*Product, error) {
+
+	err := transaction(db, func(tx *gorm.DB) error {
+		before := Product{}
+		err := tx.Debug().Model(&Product{}).Where("id = ?", pid).Take(&before).Error
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		err = tx.Debug().Model(&Product{}).Where("id = ?", pid).UpdateColumns(
+			map[string]interface{}{
+				"sku":            p.SKU,
+				"external_id":    p.ExternalID,
+				"name":           p.Name,
+				"brand":          p.Brand,
+				"image":          p.Image,
+				"model":          p.Model,
+				"price_amount":   p.Price.Amount,
+				"price_currency": p.Price.Currency,
+				"exp_date":       p.ExpDate,
+				"description":    p.Description,
+				"public":         p.Public,
+				"category_id":    p.CategoryID,
+				"updated_at":     now,
+			},
+		).Error
+		if err != nil {
+			return err
+		}
+		// This is the display the updated Product
+		err = tx.Debug().Model(&Product{}).Where("id = ?", pid).Take(&p).Error
+		if err != nil {
+			return err
+		}
+		if p.Price != before.Price {
+			err = recordPriceChange(tx, pid, p.Price, now)
+			if err != nil {
+				return err
+			}
+		}
+		changes := diffProductStates(productStateOf(before), productStateOf(*p))
+		if len(changes) == 0 {
+			return nil
+		}
+		return recordRevision(tx, pid, action, p.ActorID, reverts, changes)
+	})
+	if err != nil {
+		return &Product{}, err
+	}
 	return p, nil
 }
 
-// DeleteAProduct delete Product by id
+// DeleteAProduct move the Product to the trash. Its pending reservations are released, its
+// variants, images and tags stay with it until the trash is purged.
 func (p *Product) DeleteAProduct(db *gorm.DB, pid uuid.UUID, oid uuid.UUID) (int64, error) {
 
-	db = db.Debug().Model(&Product{}).Where("id = ? and owner_id = ?", pid, oid).Take(&Product{}).Delete(&Product{})
+	var deleted int64
+	err := transaction(db, func(tx *gorm.DB) error {
+		err := tx.Debug().Model(&Product{}).Where("id = ? and owner_id = ?", pid, oid).Take(&Product{}).Error
+		if err != nil {
+			return err
+		}
+		err = releasePendingReservations(tx, []uuid.UUID{pid})
+		if err != nil {
+			return err
+		}
+		result := tx.Debug().Model(&Product{}).Where("id = ? and owner_id = ?", pid, oid).Delete(&Product{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		return recordRevision(tx, pid, RevisionDelete, p.ActorID, nil, RevisionChanges{})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// FindDeletedProducts get the Products of the owner in the trash, the last deleted first
+func (p *Product) FindDeletedProducts(db *gorm.DB, oid uuid.UUID) (*[]Product, error) {
+	products := []Product{}
+	err := db.Debug().Unscoped().Model(&Product{}).Where("owner_id = ? AND deleted_at IS NOT NULL", oid).
+		Order("deleted_at desc").Limit(100).Find(&products).Error
+	if err != nil {
+		return &[]Product{}, err
+	}
+	return &products, nil
+}
 
-	if db.Error != nil {
-		return 0, db.Error
+// RestoreAProduct take the Product out of the trash. It kept its SKU and external id while it
+// was there, so nothing can conflict with it. The products of a deleted owner come back with
+// their owner, ErrOwnerDeleted otherwise.
+func (p *Product) RestoreAProduct(db *gorm.DB, pid uuid.UUID, oid uuid.UUID) (*Product, error) {
+
+	err := transaction(db, func(tx *gorm.DB) error {
+		err := tx.Debug().Unscoped().Model(&Product{}).Where("id = ? AND owner_id = ? AND deleted_at IS NOT NULL", pid, oid).Take(&Product{}).Error
+		if gorm.IsRecordNotFoundError(err) {
+			return ErrNotInTrash
+		}
+		if err != nil {
+			return err
+		}
+		owners := 0
+		err = tx.Debug().Model(&User{}).Where("id = ?", oid).Count(&owners).Error
+		if err != nil {
+			return err
+		}
+		if owners == 0 {
+			return ErrOwnerDeleted
+		}
+		err = tx.Debug().Unscoped().Model(&Product{}).Where("id = ?", pid).UpdateColumn("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+		return recordRevision(tx, pid, RevisionRestore, p.ActorID, nil, RevisionChanges{})
+	})
+	if err != nil {
+		return &Product{}, err
 	}
-	return db.RowsAffected, nil
+	return p.FindProductByID(db, pid, oid)
 }
 
-// FindAllOpenProducts get all Products
+// purgeProduct delete the Product for good, with its variants, reservations, stock movements,
+// images, tags and price history, its revisions are kept. It returns the blob keys of the images, for the caller
+// to remove from the blob store.
+func purgeProduct(db *gorm.DB, pid uuid.UUID) ([]string, error) {
+
+	keys := []string{}
+	err := transaction(db, func(tx *gorm.DB) error {
+		productImage := ProductImage{}
+		images, err := productImage.FindProductImages(tx, pid)
+		if err != nil {
+			return err
+		}
+		for _, image := range *images {
+			keys = append(keys, image.BlobKeys()...)
+		}
+		// The movements refer to the variants and reservations, they go first
+		err = tx.Debug().Where("product_id = ?", pid).Delete(&StockMovement{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Debug().Where("product_id = ?", pid).Delete(&ProductVariant{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Debug().Where("product_id = ?", pid).Delete(&StockReservation{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Debug().Exec("DELETE FROM image_renditions WHERE image_id IN (SELECT id FROM product_images WHERE product_id = ?)", pid).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Debug().Where("product_id = ?", pid).Delete(&ProductImage{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Debug().Exec("DELETE FROM product_tags WHERE product_id = ?", pid).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Debug().Where("product_id = ?", pid).Delete(&PriceChange{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Debug().Unscoped().Where("id = ?", pid).Delete(&Product{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// FindAllOpenProducts get the public Products, newest first
 func (p *Product) FindAllOpenProducts(db *gorm.DB) (*[]Product, error) {
 	var err error
 	products := []Product{}
-	err = db.Debug().Model(&Product{}).Limit(100).Find(&products).Error
+	err = db.Debug().Model(&Product{}).Where("public = ?", true).Order("created_at desc").Limit(100).Find(&products).Error
 	if err != nil {
 		return &[]Product{}, err
 	}
 
 	return &products, err
-}
\ No newline at end of file
+}
+
+// FindOpenProductByID find a public Product by id
+func (p *Product) FindOpenProductByID(db *gorm.DB, pid uuid.UUID) (*Product, error) {
+	err := db.Debug().Model(Product{}).Where("id = ? AND public = ?", pid, true).Take(&p).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Product{}, errors.New("Product Not Found")
+	}
+	if err != nil {
+		return &Product{}, err
+	}
+	return p, nil
+}
+
+// ReplaceProductTags set the tags of the Product, dropping the ones it had
+func (p *Product) ReplaceProductTags(db *gorm.DB, tags []Tag) error {
+	return db.Debug().Model(p).Association("Tags").Replace(tags).Error
+}
+
+// LoadProductDetails fill the tags, the variants and the category breadcrumb of the products
+func LoadProductDetails(db *gorm.DB, products []Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	ids := []uuid.UUID{}
+	categoryIDs := []uuid.UUID{}
+	for _, p := range products {
+		ids = append(ids, p.ID)
+		if p.CategoryID != nil {
+			categoryIDs = append(categoryIDs, *p.CategoryID)
+		}
+	}
+
+	tagged := []Product{}
+	err := db.Debug().Preload("Tags", func(db *gorm.DB) *gorm.DB {
+		return db.Order("name")
+	}).Preload("Variants", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at, sku")
+	}).Preload("Images", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position, created_at")
+	}).Preload("Images.Renditions").Where("id IN (?)", ids).Find(&tagged).Error
+	if err != nil {
+		return err
+	}
+	tags := map[uuid.UUID][]Tag{}
+	variants := map[uuid.UUID][]ProductVariant{}
+	images := map[uuid.UUID][]ProductImage{}
+	for _, p := range tagged {
+		tags[p.ID] = p.Tags
+		variants[p.ID] = p.Variants
+		images[p.ID] = p.Images
+	}
+
+	categories := []Category{}
+	if len(categoryIDs) > 0 {
+		err = db.Debug().Model(&Category{}).Where("id IN (?)", categoryIDs).Find(&categories).Error
+		if err != nil {
+			return err
+		}
+	}
+	crumbs, err := categoryBreadcrumbs(db, categories)
+	if err != nil {
+		return err
+	}
+
+	for i := range products {
+		products[i].Tags = tags[products[i].ID]
+		if products[i].Tags == nil {
+			products[i].Tags = []Tag{}
+		}
+		products[i].Variants = variants[products[i].ID]
+		if products[i].Variants == nil {
+			products[i].Variants = []ProductVariant{}
+		}
+		products[i].Images = images[products[i].ID]
+		if products[i].Images == nil {
+			products[i].Images = []ProductImage{}
+		}
+		for j := range products[i].Images {
+			products[i].Images[j].fillSrcset()
+		}
+		products[i].Breadcrumb = []CategoryCrumb{}
+		if products[i].CategoryID != nil {
+			if trail, ok := crumbs[*products[i].CategoryID]; ok {
+				products[i].Breadcrumb = trail
+			}
+		}
+	}
+	return nil
+}
+
+// ConvertProductPrices set the converted price of the products and of their variants, in the
+// currency. Products that cannot be converted keep none, the first error is returned.
+func ConvertProductPrices(products []Product, currency string, rates money.ExchangeRateProvider) error {
+	var first error
+	convert := func(price money.Money) *money.Conversion {
+		conversion, err := money.Convert(price, currency, rates)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			return nil
+		}
+		return &conversion
+	}
+	for i := range products {
+		products[i].ConvertedPrice = convert(products[i].Price)
+		for j := range products[i].Variants {
+			variant := &products[i].Variants[j]
+			variant.ConvertedPrice = convert(variant.Price(products[i]))
+		}
+	}
+	return first
+}
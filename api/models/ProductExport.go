@@ -0,0 +1,126 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/arikardnoir/asiwaju/api/money"
+)
+
+// ProductExport the exported view of a Product, with the ProductColumns. Text is unescaped, so an
+// export imported again reads the same.
+type ProductExport struct {
+	ID          uuid.UUID   `json:"id"`
+	SKU         *string     `json:"sku"`
+	ExternalID  *string     `json:"external_id"`
+	Name        string      `json:"name"`
+	Brand       string      `json:"brand"`
+	Model       string      `json:"model"`
+	Description string      `json:"description"`
+	Image       string      `json:"image"`
+	Price       money.Money `json:"price"`
+	ExpDate     *time.Time  `json:"exp_date"`
+	Public      bool        `json:"public"`
+	Stock       int         `json:"stock"`
+	Reserved    int         `json:"reserved"`
+	CategoryID  *uuid.UUID  `json:"category_id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// ExportView the exported view of the Product
+func ExportView(p Product) ProductExport {
+	e := ProductExport{
+		ID:          p.ID,
+		SKU:         p.SKU,
+		ExternalID:  p.ExternalID,
+		Name:        html.UnescapeString(p.Name),
+		Brand:       html.UnescapeString(p.Brand),
+		Model:       html.UnescapeString(p.Model),
+		Description: html.UnescapeString(p.Description),
+		Image:       p.Image,
+		Price:       p.Price,
+		Public:      p.Public,
+		Stock:       p.Stock,
+		Reserved:    p.Reserved,
+		CategoryID:  p.CategoryID,
+		CreatedAt:   p.CreatedAt.UTC(),
+		UpdatedAt:   p.UpdatedAt.UTC(),
+	}
+	if !p.ExpDate.IsZero() {
+		expDate := p.ExpDate.UTC()
+		e.ExpDate = &expDate
+	}
+	return e
+}
+
+// Record the values of the ProductColumns, in their order. Text is a string, the price a
+// json.Number, the stock an int and public a bool, missing values are empty strings.
+func (e ProductExport) Record() []interface{} {
+	optional := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	expDate, categoryID := "", ""
+	if e.ExpDate != nil {
+		expDate = e.ExpDate.Format(time.RFC3339)
+	}
+	if e.CategoryID != nil {
+		categoryID = e.CategoryID.String()
+	}
+	var price interface{} = ""
+	if !e.Price.IsZero() {
+		price = json.Number(e.Price.Decimal())
+	}
+	return []interface{}{
+		e.ID.String(),
+		optional(e.SKU),
+		optional(e.ExternalID),
+		e.Name,
+		e.Brand,
+		e.Model,
+		e.Description,
+		e.Image,
+		price,
+		e.Price.Currency,
+		expDate,
+		e.Public,
+		e.Stock,
+		e.Reserved,
+		categoryID,
+		e.CreatedAt.Format(time.RFC3339),
+		e.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// formulaPrefixes the first characters that make spreadsheet programs read a CSV cell as a formula
+const formulaPrefixes = "=+-@\t\r"
+
+// CSVRecord the Record as CSV cells. Text that a spreadsheet program would run as a formula is
+// quoted with a leading ', which imports remove.
+func (e ProductExport) CSVRecord() []string {
+	record := []string{}
+	for _, value := range e.Record() {
+		cell := fmt.Sprint(value)
+		if text, ok := value.(string); ok && text != "" && strings.ContainsAny(text[:1], formulaPrefixes) {
+			cell = "'" + text
+		}
+		record = append(record, cell)
+	}
+	return record
+}
+
+// unquoteFormula remove the ' CSVRecord put before text starting like a formula
+func unquoteFormula(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsAny(s[1:2], formulaPrefixes) {
+		return s[1:]
+	}
+	return s
+}
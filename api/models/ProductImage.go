@@ -0,0 +1,159 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// MaxProductImages the most images a product may have
+const MaxProductImages = 20
+
+var (
+	// ErrImageNotFound the image does not exist or belongs to another product
+	ErrImageNotFound = errors.New("Image Not Found")
+	// ErrTooManyImages the product already has MaxProductImages images
+	ErrTooManyImages = errors.New("Too Many Images")
+	// ErrInvalidImageOrder the order does not list every image of the product exactly once
+	ErrInvalidImageOrder = errors.New("Invalid Image Order")
+	// ErrRenditionsStale the image was queued again while its renditions were made, they are
+	// made again
+	ErrRenditionsStale = errors.New("Renditions Stale")
+)
+
+// ProductImage struct for an image uploaded for a Product, kept in the blob store under Key.
+// The images of a product are shown by Position, the first one is its cover. Resized copies
+// are made in the background, Srcset lists them by content type once RenditionStatus is ready.
+// RenditionGeneration counts the times the image was queued, renditions made for an older one
+// are dropped.
+type ProductImage struct {
+	ID          uuid.UUID `gorm:"primary_key" json:"id"`
+	ProductID   uuid.UUID `gorm:"not null;index" json:"product_id"`
+	Key         string    `gorm:"column:blob_key;size:500;not null" json:"-"`
+	URL         string    `gorm:"size:2000;not null" json:"url"`
+	ContentType string    `gorm:"size:100;not null" json:"content_type"`
+	Size        int64     `gorm:"not null" json:"size"`
+	Width       int       `gorm:"not null;default:0" json:"width"`
+	Height      int       `gorm:"not null;default:0" json:"height"`
+	Position    int       `gorm:"not null;default:0" json:"position"`
+	CreatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+
+	RenditionStatus     string            `gorm:"size:20;not null;default:'pending';index" json:"rendition_status"`
+	RenditionGeneration int               `gorm:"not null;default:0" json:"-"`
+	Renditions          []ImageRendition  `gorm:"foreignkey:ImageID;association_autoupdate:false;association_autocreate:false" json:"renditions"`
+	Srcset              map[string]string `gorm:"-" json:"srcset"`
+}
+
+// SaveImage save ProductImage after the other images of its product. Two concurrent uploads
+// may take the same position, they are then shown in the order they were saved.
+func (i *ProductImage) SaveImage(db *gorm.DB) (*ProductImage, error) {
+	err := transaction(db, func(tx *gorm.DB) error {
+		count := 0
+		err := tx.Debug().Model(&ProductImage{}).Where("product_id = ?", i.ProductID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count >= MaxProductImages {
+			return ErrTooManyImages
+		}
+		var last *int
+		err = tx.Debug().Model(&ProductImage{}).Where("product_id = ?", i.ProductID).Select("MAX(position)").Row().Scan(&last)
+		if err != nil {
+			return err
+		}
+		i.Position = 0
+		if last != nil {
+			i.Position = *last + 1
+		}
+		i.CreatedAt = time.Now()
+		i.RenditionStatus = RenditionsPending
+		i.Renditions = nil
+		return tx.Debug().Create(&i).Error
+	})
+	if err != nil {
+		return &ProductImage{}, err
+	}
+	i.fillSrcset()
+	return i, nil
+}
+
+// FindProductImages get the images of a Product, in their order
+func (i *ProductImage) FindProductImages(db *gorm.DB, pid uuid.UUID) (*[]ProductImage, error) {
+	images := []ProductImage{}
+	err := db.Debug().Model(&ProductImage{}).Preload("Renditions").Where("product_id = ?", pid).Order("position, created_at").Find(&images).Error
+	if err != nil {
+		return &[]ProductImage{}, err
+	}
+	for j := range images {
+		images[j].fillSrcset()
+	}
+	return &images, nil
+}
+
+// FindImageByID get an image of the Product
+func (i *ProductImage) FindImageByID(db *gorm.DB, pid uuid.UUID, iid uuid.UUID) (*ProductImage, error) {
+	err := db.Debug().Model(ProductImage{}).Preload("Renditions").Where("id = ? AND product_id = ?", iid, pid).Take(&i).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &ProductImage{}, ErrImageNotFound
+	}
+	if err != nil {
+		return &ProductImage{}, err
+	}
+	i.fillSrcset()
+	return i, nil
+}
+
+// ReorderImages put the images of the Product in the order of the ids, which must list each of them once
+func (i *ProductImage) ReorderImages(db *gorm.DB, pid uuid.UUID, ids []uuid.UUID) (*[]ProductImage, error) {
+	err := transaction(db, func(tx *gorm.DB) error {
+		images, err := i.FindProductImages(tx, pid)
+		if err != nil {
+			return err
+		}
+		if len(ids) != len(*images) {
+			return ErrInvalidImageOrder
+		}
+		known := map[uuid.UUID]bool{}
+		for _, image := range *images {
+			known[image.ID] = true
+		}
+		for position, id := range ids {
+			if !known[id] {
+				return ErrInvalidImageOrder
+			}
+			// Forgotten once placed, so an id listed twice is refused
+			delete(known, id)
+			err = tx.Debug().Model(&ProductImage{}).Where("id = ?", id).UpdateColumn("position", position).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return &[]ProductImage{}, err
+	}
+	return i.FindProductImages(db, pid)
+}
+
+// DeleteAnImage delete an image of the Product and its renditions, the blobs are left to the caller
+func (i *ProductImage) DeleteAnImage(db *gorm.DB, pid uuid.UUID, iid uuid.UUID) (int64, error) {
+	var deleted int64
+	err := transaction(db, func(tx *gorm.DB) error {
+		result := tx.Debug().Where("id = ? AND product_id = ?", iid, pid).Delete(&ProductImage{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		if deleted == 0 {
+			return nil
+		}
+		return tx.Debug().Where("image_id = ?", iid).Delete(&ImageRendition{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
@@ -0,0 +1,599 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+
+	"github.com/arikardnoir/asiwaju/api/money"
+	"github.com/arikardnoir/asiwaju/api/utils/formaterror"
+)
+
+// ProductColumns the columns of product spreadsheets, in the order exports write them.
+// Imports read them by name, in any order, and skip the read-only ones.
+var ProductColumns = []string{
+	"id", "sku", "external_id", "name", "brand", "model", "description", "image",
+	"price", "currency", "exp_date", "public", "stock", "reserved", "category_id", "created_at", "updated_at",
+}
+
+// readOnlyProductColumns the columns imports skip, the database sets them
+var readOnlyProductColumns = map[string]bool{"id": true, "reserved": true, "created_at": true, "updated_at": true}
+
+// The formats products are imported from
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+)
+
+// The columns an import may update products by
+const (
+	ImportKeySKU        = "sku"
+	ImportKeyExternalID = "external_id"
+)
+
+// The states of an ImportJob
+const (
+	ImportJobQueued  = "queued"
+	ImportJobRunning = "running"
+	ImportJobDone    = "done"
+	ImportJobFailed  = "failed"
+)
+
+// MaxImportErrors the most row errors a report lists, the others are only counted
+const MaxImportErrors = 1000
+
+// importBatchSize how many rows are written per transaction
+const importBatchSize = 500
+
+var (
+	// ErrInvalidImportFormat the import is neither CSV nor NDJSON
+	ErrInvalidImportFormat = errors.New("Invalid Import Format, expected csv or ndjson")
+	// ErrInvalidImportKey the import would update products by an unknown column
+	ErrInvalidImportKey = errors.New("Invalid Import Key, expected sku or external_id")
+	// ErrImportJobNotFound the import job does not exist or belongs to another user
+	ErrImportJobNotFound = errors.New("Import Job Not Found")
+
+	errDryRun = errors.New("dry run")
+)
+
+// ImportFileError the file cannot be imported at all, its header is wrong for example
+type ImportFileError struct {
+	Reason string
+}
+
+func (e ImportFileError) Error() string {
+	return e.Reason
+}
+
+// ImportRowError why a row of an import was skipped, rows count from 1 without the CSV header
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// ImportRowErrors the row errors of an import, stored as JSON
+type ImportRowErrors []ImportRowError
+
+// Value store the errors as JSON
+func (e ImportRowErrors) Value() (driver.Value, error) {
+	if e == nil {
+		e = ImportRowErrors{}
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan read the errors from their JSON
+func (e *ImportRowErrors) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*e = ImportRowErrors{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into ImportRowErrors", value)
+	}
+	errs := ImportRowErrors{}
+	err := json.Unmarshal(data, &errs)
+	if err != nil {
+		return err
+	}
+	*e = errs
+	return nil
+}
+
+// ImportReport what an import did, or would have done for a dry run
+type ImportReport struct {
+	DryRun  bool            `gorm:"not null;default:false" json:"dry_run"`
+	Rows    int             `gorm:"not null;default:0" json:"rows"`
+	Created int             `gorm:"not null;default:0" json:"created"`
+	Updated int             `gorm:"not null;default:0" json:"updated"`
+	Failed  int             `gorm:"not null;default:0" json:"failed"`
+	Errors  ImportRowErrors `gorm:"type:text" json:"errors"`
+}
+
+func (report *ImportReport) fail(row int, err error) {
+	report.Failed++
+	if len(report.Errors) < MaxImportErrors {
+		report.Errors = append(report.Errors, ImportRowError{Row: row, Error: err.Error()})
+	}
+}
+
+// ProductImport creates the products of a CSV or NDJSON file for their owner, or updates the ones
+// matching its Key. Each row goes through Prepare and Validate like a product sent to the API,
+// a row that fails is reported and skipped.
+type ProductImport struct {
+	OwnerID uuid.UUID
+	Format  string
+	// Key the column matching rows to the products they update, none creates every row
+	Key string
+	// DryRun check every row and count what would change, without writing anything
+	DryRun bool
+	// Progress is called with the report so far after each batch
+	Progress func(ImportReport)
+}
+
+// Validate validations on ProductImport
+func (imp *ProductImport) Validate() error {
+	if imp.Format != ImportFormatCSV && imp.Format != ImportFormatNDJSON {
+		return ErrInvalidImportFormat
+	}
+	if imp.Key != "" && imp.Key != ImportKeySKU && imp.Key != ImportKeyExternalID {
+		return ErrInvalidImportKey
+	}
+	return nil
+}
+
+// importRow a row read from the file, with the columns it gave
+type importRow struct {
+	number  int
+	product Product
+	columns map[string]bool
+	err     error
+}
+
+// Run import the rows of r, importBatchSize rows per transaction. The error is for a file that
+// cannot be read and for the database, the rows written until then stay.
+func (imp *ProductImport) Run(db *gorm.DB, r io.Reader) (*ImportReport, error) {
+	report := ImportReport{DryRun: imp.DryRun, Errors: ImportRowErrors{}}
+	next, err := imp.rows(r)
+	if err != nil {
+		return &report, err
+	}
+	categories := map[uuid.UUID]bool{}
+	batch := []importRow{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := imp.writeBatch(db, batch, categories, &report)
+		batch = batch[:0]
+		if err != nil {
+			return err
+		}
+		if imp.Progress != nil {
+			imp.Progress(report)
+		}
+		return nil
+	}
+	for {
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &report, err
+		}
+		report.Rows++
+		row.number = report.Rows
+		// Rows that could not be read go in the batch too, so the errors stay in the order of the rows
+		batch = append(batch, row)
+		if len(batch) >= importBatchSize {
+			err = flush()
+			if err != nil {
+				return &report, err
+			}
+		}
+	}
+	err = flush()
+	if err != nil {
+		return &report, err
+	}
+	return &report, nil
+}
+
+// writeBatch write the rows in a transaction, each behind a savepoint so a row the database
+// refuses is skipped without the others
+func (imp *ProductImport) writeBatch(db *gorm.DB, batch []importRow, categories map[uuid.UUID]bool, report *ImportReport) error {
+	counted := *report
+	err := transaction(db, func(tx *gorm.DB) error {
+		for _, row := range batch {
+			if row.err != nil {
+				counted.fail(row.number, row.err)
+				continue
+			}
+			err := tx.Exec("SAVEPOINT import_row").Error
+			if err != nil {
+				return err
+			}
+			created, err := imp.writeRow(tx, row, categories)
+			if err != nil {
+				rollback := tx.Exec("ROLLBACK TO SAVEPOINT import_row").Error
+				if rollback != nil {
+					return rollback
+				}
+				counted.fail(row.number, err)
+				continue
+			}
+			err = tx.Exec("RELEASE SAVEPOINT import_row").Error
+			if err != nil {
+				return err
+			}
+			if created {
+				counted.Created++
+			} else {
+				counted.Updated++
+			}
+		}
+		if imp.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return err
+	}
+	*report = counted
+	return nil
+}
+
+// writeRow create the product of the row, or update the one its key matches with the columns
+// the row gave
+func (imp *ProductImport) writeRow(tx *gorm.DB, row importRow, categories map[uuid.UUID]bool) (bool, error) {
+	product := row.product
+	product.OwnerID = imp.OwnerID
+	product.Prepare()
+
+	existing := Product{}
+	found := false
+	if imp.Key != "" {
+		value, column := product.SKU, "sku"
+		if imp.Key == ImportKeyExternalID {
+			value, column = product.ExternalID, "external_id"
+		}
+		if value == nil {
+			if column == "sku" {
+				return false, errors.New("Required SKU")
+			}
+			return false, errors.New("Required External ID")
+		}
+		err := tx.Debug().Model(&Product{}).Where("owner_id = ? AND "+column+" = ?", imp.OwnerID, *value).Take(&existing).Error
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			return false, err
+		}
+		found = err == nil
+	}
+
+	if found {
+		mergeProductColumns(&existing, &product, row.columns)
+		product = existing
+		err := product.Validate("update")
+		if err != nil {
+			return false, err
+		}
+	} else {
+		err := product.Validate("")
+		if err != nil {
+			return false, err
+		}
+	}
+	if product.CategoryID != nil && !categories[*product.CategoryID] {
+		category := Category{}
+		_, err := category.FindCategoryByID(tx, *product.CategoryID)
+		if err != nil {
+			return false, err
+		}
+		categories[*product.CategoryID] = true
+	}
+
+	actorID := imp.OwnerID
+	product.ActorID = &actorID
+	if found {
+		_, err := product.UpdateAProduct(tx, product.ID)
+		if err != nil {
+			return false, formaterror.FormatError(err.Error())
+		}
+		return false, nil
+	}
+	product.ID = uuid.Must(uuid.NewRandom())
+	_, err := product.SaveProduct(tx)
+	if err != nil {
+		return false, formaterror.FormatError(err.Error())
+	}
+	return true, nil
+}
+
+// mergeProductColumns copy the columns the row gave onto the product it updates, the others keep
+// their values. The stock only moves through the ledger, it is not updated.
+func mergeProductColumns(dst *Product, src *Product, columns map[string]bool) {
+	if columns["sku"] {
+		dst.SKU = src.SKU
+	}
+	if columns["external_id"] {
+		dst.ExternalID = src.ExternalID
+	}
+	if columns["name"] {
+		dst.Name = src.Name
+	}
+	if columns["brand"] {
+		dst.Brand = src.Brand
+	}
+	if columns["model"] {
+		dst.Model = src.Model
+	}
+	if columns["description"] {
+		dst.Description = src.Description
+	}
+	if columns["image"] {
+		dst.Image = src.Image
+	}
+	if columns["price"] {
+		dst.Price = src.Price
+	}
+	if columns["exp_date"] {
+		dst.ExpDate = src.ExpDate
+	}
+	if columns["public"] {
+		dst.Public = src.Public
+	}
+	if columns["category_id"] {
+		dst.CategoryID = src.CategoryID
+	}
+}
+
+// rows the function reading the rows of the file one by one, until io.EOF
+func (imp *ProductImport) rows(r io.Reader) (func() (importRow, error), error) {
+	if imp.Format == ImportFormatCSV {
+		return csvRows(r)
+	}
+	return ndjsonRows(r), nil
+}
+
+// csvRows read a CSV file whose first row names the columns
+func csvRows(r io.Reader) (func() (importRow, error), error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, ImportFileError{"Empty File"}
+	}
+	if err != nil {
+		return nil, ImportFileError{fmt.Sprintf("Invalid CSV Header: %v", err)}
+	}
+	known := map[string]bool{}
+	for _, column := range ProductColumns {
+		known[column] = true
+	}
+	columns := make([]string, len(header))
+	given := map[string]bool{}
+	for i, column := range header {
+		// Spreadsheet programs start their UTF-8 files with a byte order mark
+		column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		if !known[column] {
+			return nil, ImportFileError{fmt.Sprintf("Unknown Column: %s", column)}
+		}
+		if given[column] {
+			return nil, ImportFileError{fmt.Sprintf("Duplicate Column: %s", column)}
+		}
+		columns[i] = column
+		if !readOnlyProductColumns[column] {
+			given[column] = true
+		}
+	}
+	if given["currency"] {
+		given["price"] = true
+	}
+
+	return func() (importRow, error) {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return importRow{}, io.EOF
+		}
+		if parseErr, ok := err.(*csv.ParseError); ok {
+			return importRow{err: fmt.Errorf("Invalid CSV: %v", parseErr.Err)}, nil
+		}
+		if err != nil {
+			return importRow{}, err
+		}
+		if len(record) != len(columns) {
+			return importRow{err: fmt.Errorf("Expected %d Columns, Got %d", len(columns), len(record))}, nil
+		}
+		values := map[string]string{}
+		for i, column := range columns {
+			values[column] = unquoteFormula(strings.TrimSpace(record[i]))
+		}
+		product, err := productFromValues(values)
+		return importRow{product: product, columns: given, err: err}, nil
+	}, nil
+}
+
+// productFromValues the product of a CSV row, by column
+func productFromValues(values map[string]string) (Product, error) {
+	product := Product{
+		Name:        values["name"],
+		Brand:       values["brand"],
+		Model:       values["model"],
+		Description: values["description"],
+		Image:       values["image"],
+	}
+	sku, externalID := values["sku"], values["external_id"]
+	product.SKU, product.ExternalID = &sku, &externalID
+
+	if price := values["price"]; price != "" {
+		currency := values["currency"]
+		if currency == "" {
+			currency = money.DefaultCurrency()
+		}
+		amount, err := money.Parse(price, currency)
+		if err != nil {
+			return product, err
+		}
+		product.Price = amount
+	}
+	if expDate := values["exp_date"]; expDate != "" {
+		t, err := time.Parse(time.RFC3339, expDate)
+		if err != nil {
+			t, err = time.Parse("2006-01-02", expDate)
+		}
+		if err != nil {
+			return product, errors.New("Invalid Expiration Date")
+		}
+		product.ExpDate = t
+	}
+	if public := values["public"]; public != "" {
+		b, err := strconv.ParseBool(public)
+		if err != nil {
+			return product, errors.New("Invalid Public")
+		}
+		product.Public = b
+	}
+	if stock := values["stock"]; stock != "" {
+		n, err := strconv.Atoi(stock)
+		if err != nil || n < 0 {
+			return product, errors.New("Invalid Stock")
+		}
+		product.Stock = n
+	}
+	if categoryID := values["category_id"]; categoryID != "" {
+		cid, err := uuid.Parse(categoryID)
+		if err != nil {
+			return product, errors.New("Invalid Category")
+		}
+		product.CategoryID = &cid
+	}
+	return product, nil
+}
+
+// ndjsonRows read one product per line, as the API takes them, blank lines are skipped
+func ndjsonRows(r io.Reader) func() (importRow, error) {
+	reader := bufio.NewReader(r)
+	return func() (importRow, error) {
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return importRow{}, err
+			}
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				if err == io.EOF {
+					return importRow{}, io.EOF
+				}
+				continue
+			}
+			fields := map[string]json.RawMessage{}
+			product := Product{}
+			parseErr := json.Unmarshal(line, &fields)
+			if parseErr == nil {
+				parseErr = json.Unmarshal(line, &product)
+			}
+			if parseErr != nil {
+				return importRow{err: fmt.Errorf("Invalid JSON: %v", parseErr)}, nil
+			}
+			columns := map[string]bool{}
+			for name := range fields {
+				columns[name] = true
+			}
+			return importRow{product: product, columns: columns}, nil
+		}
+	}
+}
+
+// ImportJob struct for an import run in the background, with its report so far
+type ImportJob struct {
+	ID      uuid.UUID `gorm:"primary_key" json:"id"`
+	OwnerID uuid.UUID `gorm:"not null;index" json:"owner_id"`
+	Status  string    `gorm:"size:20;not null" json:"status"`
+	Format  string    `gorm:"size:10;not null" json:"format"`
+	Key     string    `gorm:"column:upsert_key;size:20" json:"key"`
+	ImportReport
+	// Error why the job failed, the rows written before stay
+	Error      string     `gorm:"size:2000" json:"error,omitempty"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// SaveImportJob save ImportJob
+func (j *ImportJob) SaveImportJob(db *gorm.DB) (*ImportJob, error) {
+	j.CreatedAt = time.Now()
+	j.UpdatedAt = time.Now()
+	if j.Errors == nil {
+		j.Errors = ImportRowErrors{}
+	}
+	err := db.Debug().Create(&j).Error
+	if err != nil {
+		return &ImportJob{}, err
+	}
+	return j, nil
+}
+
+// UpdateImportJob save the status and the report of the ImportJob
+func (j *ImportJob) UpdateImportJob(db *gorm.DB) error {
+	j.UpdatedAt = time.Now()
+	return db.Debug().Model(&ImportJob{}).Where("id = ?", j.ID).UpdateColumns(
+		map[string]interface{}{
+			"status":      j.Status,
+			"rows":        j.Rows,
+			"created":     j.Created,
+			"updated":     j.Updated,
+			"failed":      j.Failed,
+			"errors":      j.Errors,
+			"error":       j.Error,
+			"updated_at":  j.UpdatedAt,
+			"finished_at": j.FinishedAt,
+		},
+	).Error
+}
+
+// FindImportJobByID find ImportJob by id
+func (j *ImportJob) FindImportJobByID(db *gorm.DB, id uuid.UUID) (*ImportJob, error) {
+	err := db.Debug().Model(ImportJob{}).Where("id = ?", id).Take(&j).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &ImportJob{}, ErrImportJobNotFound
+	}
+	if err != nil {
+		return &ImportJob{}, err
+	}
+	return j, nil
+}
+
+// FailStaleImportJobs mark failed the jobs that made no progress since before, the instance
+// running them stopped
+func FailStaleImportJobs(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Debug().Model(&ImportJob{}).
+		Where("status IN (?) AND updated_at < ?", []string{ImportJobQueued, ImportJobRunning}, before).
+		UpdateColumns(map[string]interface{}{"status": ImportJobFailed, "error": "Interrupted", "finished_at": time.Now()})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
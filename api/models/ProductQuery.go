@@ -0,0 +1,344 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+
+	"github.com/arikardnoir/asiwaju/api/money"
+)
+
+const (
+	// DefaultProductPageSize the page size when the query sets none
+	DefaultProductPageSize = 20
+	// MaxProductPageSize the largest page a query may ask for
+	MaxProductPageSize = 100
+	// DefaultProductSort newest products first
+	DefaultProductSort = "-created_at"
+)
+
+var (
+	// ErrInvalidSort the sort is not one of the ProductSorts
+	ErrInvalidSort = errors.New("Invalid Sort")
+	// ErrInvalidCursor the cursor was tampered with, or belongs to another sort
+	ErrInvalidCursor = errors.New("Invalid Cursor")
+)
+
+// productSortColumns the columns products may be sorted by, a leading - sorts descending. Prices
+// are sorted within their currency, amounts in minor units of different currencies don't compare.
+var productSortColumns = map[string][]string{
+	"price":      {"price_currency", "price_amount"},
+	"name":       {"name"},
+	"created_at": {"created_at"},
+}
+
+// ProductQuery the filters, sort and page of a product listing
+type ProductQuery struct {
+	// OwnerID limits the query to the products of an owner. Only a PublicOnly query may leave it empty.
+	OwnerID    uuid.UUID
+	PublicOnly bool
+	Brand      string
+	Model      string
+	// MinPrice and MaxPrice also limit the query to their currency
+	MinPrice *money.Money
+	MaxPrice *money.Money
+	ExpFrom  *time.Time
+	ExpTo    *time.Time
+	Sort     string
+	Limit    int
+	Cursor   *ProductCursor
+	// CategoryID limits the query to the category and every category below it
+	CategoryID *uuid.UUID
+	// Tag the slug of a tag the products must have
+	Tag string
+
+	categoryPath string
+}
+
+// ProductCursor the position of a page boundary. It is opaque to clients, see Encode.
+type ProductCursor struct {
+	Sort     string    `json:"s"`
+	Value    string    `json:"v"`
+	ID       uuid.UUID `json:"id"`
+	Backward bool      `json:"b,omitempty"`
+}
+
+// ProductPage a page of products, with the cursors of the pages around it
+type ProductPage struct {
+	Products []Product
+	Total    int
+	Next     *ProductCursor
+	Prev     *ProductCursor
+}
+
+// Encode the cursor as an opaque string for query parameters
+func (c *ProductCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeProductCursor read a cursor made by Encode
+func DecodeProductCursor(s string) (*ProductCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor := ProductCursor{}
+	err = json.Unmarshal(b, &cursor)
+	if err != nil || cursor.ID == uuid.Nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// Normalize fill the defaults of the query and check its sort and cursor
+func (q *ProductQuery) Normalize() error {
+	if q.Sort == "" {
+		q.Sort = DefaultProductSort
+	}
+	if _, ok := productSortColumns[strings.TrimPrefix(q.Sort, "-")]; !ok {
+		return ErrInvalidSort
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultProductPageSize
+	}
+	if q.Limit > MaxProductPageSize {
+		q.Limit = MaxProductPageSize
+	}
+	if q.Cursor != nil {
+		if q.Cursor.Sort != q.Sort {
+			return ErrInvalidCursor
+		}
+		if _, err := q.cursorValues(); err != nil {
+			return ErrInvalidCursor
+		}
+	}
+	return nil
+}
+
+// FindProducts get a page of the products matching the query
+func (p *Product) FindProducts(db *gorm.DB, q ProductQuery) (*ProductPage, error) {
+	err := q.Normalize()
+	if err != nil {
+		return &ProductPage{}, err
+	}
+	err = q.findCategory(db)
+	if err != nil {
+		return &ProductPage{}, err
+	}
+
+	filtered := q.filter(db.Debug().Model(&Product{}))
+	page := ProductPage{Products: []Product{}}
+	err = filtered.Count(&page.Total).Error
+	if err != nil {
+		return &ProductPage{}, err
+	}
+
+	columns := productSortColumns[strings.TrimPrefix(q.Sort, "-")]
+	descending := strings.HasPrefix(q.Sort, "-")
+	backward := q.Cursor != nil && q.Cursor.Backward
+	// Going back reads the rows before the cursor in reverse, then puts them back in order
+	if backward {
+		descending = !descending
+	}
+	direction, comparison := "ASC", ">"
+	if descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	query := filtered
+	if q.Cursor != nil {
+		values, _ := q.cursorValues()
+		condition, args := afterCursor(columns, comparison, values, q.Cursor.ID)
+		query = query.Where(condition, args...)
+	}
+	err = orderProducts(query, columns, direction).Limit(q.Limit + 1).Find(&page.Products).Error
+	if err != nil {
+		return &ProductPage{}, err
+	}
+
+	more := len(page.Products) > q.Limit
+	if more {
+		page.Products = page.Products[:q.Limit]
+	}
+	if backward {
+		for i, j := 0, len(page.Products)-1; i < j; i, j = i+1, j-1 {
+			page.Products[i], page.Products[j] = page.Products[j], page.Products[i]
+		}
+	}
+	if len(page.Products) == 0 {
+		return &page, nil
+	}
+
+	first, last := page.Products[0], page.Products[len(page.Products)-1]
+	hasNext, hasPrev := more, q.Cursor != nil
+	if backward {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		page.Next = &ProductCursor{Sort: q.Sort, Value: sortValue(last, q.Sort), ID: last.ID}
+	}
+	if hasPrev {
+		page.Prev = &ProductCursor{Sort: q.Sort, Value: sortValue(first, q.Sort), ID: first.ID, Backward: true}
+	}
+	return &page, nil
+}
+
+// EachProduct call fn with every product matching the query, in its sort. They are read from the
+// database cursor one at a time, however many there are. The limit and the cursor of the query
+// are ignored, an error of fn stops the reading and is returned.
+func (p *Product) EachProduct(db *gorm.DB, q ProductQuery, fn func(Product) error) error {
+	q.Cursor = nil
+	err := q.Normalize()
+	if err != nil {
+		return err
+	}
+	err = q.findCategory(db)
+	if err != nil {
+		return err
+	}
+
+	columns := productSortColumns[strings.TrimPrefix(q.Sort, "-")]
+	direction := "ASC"
+	if strings.HasPrefix(q.Sort, "-") {
+		direction = "DESC"
+	}
+	rows, err := orderProducts(q.filter(db.Debug().Model(&Product{})), columns, direction).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		product := Product{}
+		err = db.ScanRows(rows, &product)
+		if err != nil {
+			return err
+		}
+		err = fn(product)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// findCategory resolve the category of the query to the path its products are under
+func (q *ProductQuery) findCategory(db *gorm.DB) error {
+	if q.CategoryID == nil {
+		return nil
+	}
+	category := Category{}
+	_, err := category.FindCategoryByID(db, *q.CategoryID)
+	if err != nil {
+		return err
+	}
+	q.categoryPath = category.Path
+	return nil
+}
+
+// filter apply the filters of the query, they also decide the total
+func (q *ProductQuery) filter(db *gorm.DB) *gorm.DB {
+	if q.OwnerID != uuid.Nil || !q.PublicOnly {
+		db = db.Where("owner_id = ?", q.OwnerID)
+	}
+	if q.PublicOnly {
+		db = db.Where("public = ?", true)
+	}
+	if q.Brand != "" {
+		db = db.Where("LOWER(brand) = LOWER(?)", q.Brand)
+	}
+	if q.Model != "" {
+		db = db.Where("LOWER(model) = LOWER(?)", q.Model)
+	}
+	if q.MinPrice != nil {
+		db = db.Where("price_currency = ? AND price_amount >= ?", q.MinPrice.Currency, q.MinPrice.Amount)
+	}
+	if q.MaxPrice != nil {
+		db = db.Where("price_currency = ? AND price_amount <= ?", q.MaxPrice.Currency, q.MaxPrice.Amount)
+	}
+	if q.ExpFrom != nil {
+		db = db.Where("exp_date >= ?", *q.ExpFrom)
+	}
+	if q.ExpTo != nil {
+		db = db.Where("exp_date <= ?", *q.ExpTo)
+	}
+	if q.categoryPath != "" {
+		db = db.Where("category_id IN (SELECT id FROM categories WHERE path LIKE ?)", escapeLike(q.categoryPath)+"%")
+	}
+	if q.Tag != "" {
+		db = db.Where("id IN (SELECT product_tags.product_id FROM product_tags JOIN tags ON tags.id = product_tags.tag_id WHERE tags.slug = ?)", q.Tag)
+	}
+	return db
+}
+
+// orderProducts order the query by the sort columns, then by id so the order is total
+func orderProducts(db *gorm.DB, columns []string, direction string) *gorm.DB {
+	for _, column := range columns {
+		db = db.Order(column + " " + direction)
+	}
+	return db.Order("id " + direction)
+}
+
+// afterCursor the condition of the rows past the cursor, in the order of the sort columns and id
+func afterCursor(columns []string, comparison string, values []interface{}, id uuid.UUID) (string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+	for i := 0; i <= len(columns); i++ {
+		condition := ""
+		for j := 0; j < i; j++ {
+			condition += columns[j] + " = ? AND "
+			args = append(args, values[j])
+		}
+		if i < len(columns) {
+			condition += columns[i] + " " + comparison + " ?"
+			args = append(args, values[i])
+		} else {
+			condition += "id " + comparison + " ?"
+			args = append(args, id)
+		}
+		conditions = append(conditions, "("+condition+")")
+	}
+	return strings.Join(conditions, " OR "), args
+}
+
+// cursorValues the cursor values in the types of the sort columns
+func (q *ProductQuery) cursorValues() ([]interface{}, error) {
+	switch strings.TrimPrefix(q.Sort, "-") {
+	case "price":
+		parts := strings.SplitN(q.Cursor.Value, ":", 2)
+		if len(parts) != 2 {
+			return nil, ErrInvalidCursor
+		}
+		amount, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{parts[0], amount}, nil
+	case "created_at":
+		t, err := time.Parse(time.RFC3339Nano, q.Cursor.Value)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{t}, nil
+	default:
+		return []interface{}{q.Cursor.Value}, nil
+	}
+}
+
+// sortValue the cursor value of the product in the sort, the currency and amount of a price
+func sortValue(p Product, sort string) string {
+	switch strings.TrimPrefix(sort, "-") {
+	case "price":
+		return p.Price.Currency + ":" + strconv.FormatInt(p.Price.Amount, 10)
+	case "created_at":
+		return p.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		return p.Name
+	}
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// RevisionCreate the product was created
+	RevisionCreate = "create"
+	// RevisionUpdate the product was updated
+	RevisionUpdate = "update"
+	// RevisionDelete the product was moved to the trash
+	RevisionDelete = "delete"
+	// RevisionRestore the product was taken out of the trash
+	RevisionRestore = "restore"
+	// RevisionRevert the product was set back to an earlier revision
+	RevisionRevert = "revert"
+)
+
+// MaxProductRevisions the most revisions listed at once
+const MaxProductRevisions = 200
+
+var (
+	// ErrRevisionNotFound the revision does not exist or is of another product
+	ErrRevisionNotFound = errors.New("Revision Not Found")
+	// ErrRevertSKUTaken another product of the owner took the SKU of the revision since
+	ErrRevertSKUTaken = errors.New("SKU Already Taken")
+	// ErrRevertExternalIDTaken another product of the owner took the external ID of the revision since
+	ErrRevertExternalIDTaken = errors.New("External ID Already Taken")
+)
+
+// RevisionChange the JSON value of a field before and after a revision, null before the product
+// was created
+type RevisionChange struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+// RevisionChanges the changes of a revision by the JSON name of the field, stored as JSON
+type RevisionChanges map[string]RevisionChange
+
+// Value store the changes as JSON
+func (c RevisionChanges) Value() (driver.Value, error) {
+	if c == nil {
+		c = RevisionChanges{}
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan read the changes from their JSON
+func (c *RevisionChanges) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*c = RevisionChanges{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into RevisionChanges", value)
+	}
+	changes := RevisionChanges{}
+	err := json.Unmarshal(data, &changes)
+	if err != nil {
+		return err
+	}
+	*c = changes
+	return nil
+}
+
+// ProductRevision struct for a change of a Product, numbered from 1 in the order they happened.
+// Revisions are never changed or deleted, not even when the product is purged from the trash.
+// The stock has its own history in the StockMovement ledger.
+type ProductRevision struct {
+	ID        uuid.UUID       `gorm:"primary_key" json:"id"`
+	ProductID uuid.UUID       `gorm:"not null;unique_index:idx_product_revisions_number" json:"product_id"`
+	Number    int             `gorm:"not null;unique_index:idx_product_revisions_number" json:"number"`
+	Action    string          `gorm:"size:20;not null" json:"action"`
+	ActorID   *uuid.UUID      `gorm:"null;index" json:"actor_id"`
+	Reverts   *int            `gorm:"null" json:"reverts,omitempty"`
+	Changes   RevisionChanges `gorm:"type:text;not null" json:"changes"`
+	CreatedAt time.Time       `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// FindProductRevisions get the revisions of the Product numbered below before, the last first.
+// A before of 0 starts from the last one.
+func (r *ProductRevision) FindProductRevisions(db *gorm.DB, pid uuid.UUID, before int, limit int) (*[]ProductRevision, error) {
+	if limit <= 0 || limit > MaxProductRevisions {
+		limit = MaxProductRevisions
+	}
+	query := db.Debug().Model(&ProductRevision{}).Where("product_id = ?", pid)
+	if before > 0 {
+		query = query.Where("number < ?", before)
+	}
+	revisions := []ProductRevision{}
+	err := query.Order("number desc").Limit(limit).Find(&revisions).Error
+	if err != nil {
+		return &[]ProductRevision{}, err
+	}
+	return &revisions, nil
+}
+
+// DiffProductRevisions the fields that differ between the Product as it was at the two
+// revisions, from the first to the second
+func DiffProductRevisions(db *gorm.DB, product Product, from int, to int) (RevisionChanges, error) {
+	fromState, err := productStateAt(db, product, from)
+	if err != nil {
+		return nil, err
+	}
+	toState, err := productStateAt(db, product, to)
+	if err != nil {
+		return nil, err
+	}
+	return diffProductStates(fromState, toState), nil
+}
+
+// productState the revisioned fields of a Product, as JSON by their JSON name
+type productState map[string]json.RawMessage
+
+// productStateOf the revisioned fields of the Product, the ones UpdateAProduct writes
+func productStateOf(p Product) productState {
+	fields := map[string]interface{}{
+		"sku":         p.SKU,
+		"external_id": p.ExternalID,
+		"name":        p.Name,
+		"brand":       p.Brand,
+		"model":       p.Model,
+		"description": p.Description,
+		"image":       p.Image,
+		"price":       p.Price,
+		// The database keeps microseconds
+		"exp_date":    p.ExpDate.UTC().Truncate(time.Microsecond),
+		"public":      p.Public,
+		"category_id": p.CategoryID,
+	}
+	state := productState{}
+	for name, value := range fields {
+		data, err := json.Marshal(value)
+		if err != nil {
+			// Every field is a plain value
+			panic(err)
+		}
+		state[name] = data
+	}
+	return state
+}
+
+// productStateAt the revisioned fields of the Product as they were right after the revision,
+// found by undoing the revisions after it
+func productStateAt(db *gorm.DB, product Product, number int) (productState, error) {
+	count := 0
+	err := db.Debug().Model(&ProductRevision{}).Where("product_id = ? AND number = ?", product.ID, number).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrRevisionNotFound
+	}
+	later := []ProductRevision{}
+	err = db.Debug().Model(&ProductRevision{}).Where("product_id = ? AND number > ?", product.ID, number).
+		Order("number desc").Find(&later).Error
+	if err != nil {
+		return nil, err
+	}
+	state := productStateOf(product)
+	for _, revision := range later {
+		for name, change := range revision.Changes {
+			// Fields no longer revisioned, such as the old size, are left out
+			if _, ok := state[name]; ok {
+				state[name] = change.From
+			}
+		}
+	}
+	return state, nil
+}
+
+// diffProductStates the fields whose value is not the same in both states
+func diffProductStates(from, to productState) RevisionChanges {
+	changes := RevisionChanges{}
+	for name, value := range to {
+		before, ok := from[name]
+		if !ok {
+			before = json.RawMessage("null")
+		}
+		if !bytes.Equal(before, value) {
+			changes[name] = RevisionChange{From: before, To: value}
+		}
+	}
+	return changes
+}
+
+// recordRevision add the next revision of the product. It must run in the transaction of the
+// change, after the product row was written, which keeps concurrent changes from taking the
+// same number.
+func recordRevision(tx *gorm.DB, pid uuid.UUID, action string, actorID *uuid.UUID, reverts *int, changes RevisionChanges) error {
+	var last int
+	err := tx.Debug().Model(&ProductRevision{}).Where("product_id = ?", pid).Select("COALESCE(MAX(number), 0)").Row().Scan(&last)
+	if err != nil {
+		return err
+	}
+	revision := ProductRevision{
+		ID:        uuid.Must(uuid.NewRandom()),
+		ProductID: pid,
+		Number:    last + 1,
+		Action:    action,
+		ActorID:   actorID,
+		Reverts:   reverts,
+		Changes:   changes,
+		CreatedAt: time.Now(),
+	}
+	return tx.Debug().Create(&revision).Error
+}
+
+// productKeyTaken whether another product of the owner, in the trash too, has the value in the
+// unique column
+func productKeyTaken(tx *gorm.DB, p Product, column string, value *string) (bool, error) {
+	if value == nil {
+		return false, nil
+	}
+	count := 0
+	err := tx.Debug().Unscoped().Model(&Product{}).
+		Where("owner_id = ? AND id <> ? AND "+column+" = ?", p.OwnerID, p.ID, *value).Count(&count).Error
+	return count > 0, err
+}
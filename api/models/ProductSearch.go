@@ -0,0 +1,137 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// productSearchLanguages the text search configurations the lang parameter picks from.
+// "simple" does no stemming, so it suits any language and is the default.
+var productSearchLanguages = map[string]string{
+	"":       "simple",
+	"simple": "simple",
+	"pt":     "portuguese",
+	"en":     "english",
+	"es":     "spanish",
+	"fr":     "french",
+	"de":     "german",
+	"it":     "italian",
+}
+
+// indexedSearchLanguages the configurations with a GIN index, searches in others scan the table
+var indexedSearchLanguages = []string{"simple", "portuguese", "english"}
+
+// ProductSearch a full-text search of the products of an owner
+type ProductSearch struct {
+	OwnerID uuid.UUID
+	Text    string
+	Lang    string
+	Limit   int
+}
+
+// ValidSearchLanguage check if the lang parameter is one we know
+func ValidSearchLanguage(lang string) bool {
+	_, ok := productSearchLanguages[strings.ToLower(lang)]
+	return ok
+}
+
+// SearchProducts find the products matching the text in their name, brand, model or description,
+// most relevant first. Postgres ranks with tsvector, other databases fall back to LIKE.
+func (p *Product) SearchProducts(db *gorm.DB, s ProductSearch) (*[]Product, error) {
+	if s.Limit <= 0 || s.Limit > MaxProductPageSize {
+		s.Limit = DefaultProductPageSize
+	}
+	products := []Product{}
+	if strings.TrimSpace(s.Text) == "" {
+		return &products, nil
+	}
+
+	var err error
+	if db.Dialect().GetName() == "postgres" {
+		config := productSearchLanguages[strings.ToLower(s.Lang)]
+		if config == "" {
+			config = "simple"
+		}
+		document := productSearchDocument(config)
+		query := fmt.Sprintf("plainto_tsquery('%s', asiwaju_unaccent(?))", config)
+		err = db.Debug().Model(&Product{}).
+			Where("owner_id = ?", s.OwnerID).
+			Where(document+" @@ "+query, s.Text).
+			Order(gorm.Expr("ts_rank("+document+", "+query+") DESC, id", s.Text)).
+			Limit(s.Limit).
+			Find(&products).Error
+	} else {
+		err = p.searchProductsLike(db, s, &products)
+	}
+	if err != nil {
+		return &[]Product{}, err
+	}
+	return &products, nil
+}
+
+// searchProductsLike every word has to appear in one of the fields, matches in the name rank first.
+// MySQL's default collations already compare without case and accents.
+func (p *Product) searchProductsLike(db *gorm.DB, s ProductSearch, products *[]Product) error {
+	query := db.Debug().Model(&Product{}).Where("owner_id = ?", s.OwnerID)
+	rank := []string{}
+	args := []interface{}{}
+	for _, word := range strings.Fields(s.Text) {
+		pattern := "%" + escapeLike(strings.ToLower(word)) + "%"
+		query = query.Where(
+			"LOWER(name) LIKE ? OR LOWER(brand) LIKE ? OR LOWER(model) LIKE ? OR LOWER(description) LIKE ?",
+			pattern, pattern, pattern, pattern,
+		)
+		rank = append(rank, "(CASE WHEN LOWER(name) LIKE ? THEN 4 WHEN LOWER(brand) LIKE ? OR LOWER(model) LIKE ? THEN 2 ELSE 1 END)")
+		args = append(args, pattern, pattern, pattern)
+	}
+	return query.
+		Order(gorm.Expr(strings.Join(rank, " + ")+" DESC, id", args...)).
+		Limit(s.Limit).
+		Find(products).Error
+}
+
+// productSearchDocument the tsvector of a product, matches in the name weigh most.
+// The GIN indexes are built on this very expression, so queries must use it unchanged.
+func productSearchDocument(config string) string {
+	return fmt.Sprintf("("+
+		"setweight(to_tsvector('%[1]s', asiwaju_unaccent(coalesce(name, ''))), 'A') || "+
+		"setweight(to_tsvector('%[1]s', asiwaju_unaccent(coalesce(brand, '') || ' ' || coalesce(model, ''))), 'B') || "+
+		"setweight(to_tsvector('%[1]s', asiwaju_unaccent(coalesce(description, ''))), 'C'))", config)
+}
+
+// MigrateProductSearch create what the Postgres search needs: the unaccent extension,
+// an immutable wrapper so it can be indexed, and a GIN index per indexed language.
+// Other databases need nothing.
+func MigrateProductSearch(db *gorm.DB) error {
+	if db.Dialect().GetName() != "postgres" {
+		return nil
+	}
+	statements := []string{
+		"CREATE EXTENSION IF NOT EXISTS unaccent",
+		// unaccent is only STABLE, naming the dictionary makes the wrapper safe to declare IMMUTABLE
+		"CREATE OR REPLACE FUNCTION asiwaju_unaccent(text) RETURNS text AS " +
+			"$$ SELECT public.unaccent('public.unaccent'::regdictionary, $1) $$ LANGUAGE sql IMMUTABLE STRICT",
+	}
+	for _, config := range indexedSearchLanguages {
+		statements = append(statements, fmt.Sprintf(
+			"CREATE INDEX IF NOT EXISTS idx_products_search_%s ON products USING GIN (%s)",
+			config, productSearchDocument(config),
+		))
+	}
+	for _, statement := range statements {
+		err := db.Debug().Exec(statement).Error
+		if err != nil {
+			log.Printf("cannot prepare the product search: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,229 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+
+	"github.com/arikardnoir/asiwaju/api/money"
+)
+
+var (
+	// ErrVariantNotFound the variant does not exist or belongs to another product
+	ErrVariantNotFound = errors.New("Variant Not Found")
+	// ErrVariantReserved the variant has reserved units and cannot be deleted
+	ErrVariantReserved = errors.New("Variant Has Reserved Stock")
+)
+
+// VariantAttributes the attribute values telling a variant apart, such as size and color.
+// They are stored as JSON with sorted keys, so two variants with the same values have the same column.
+type VariantAttributes map[string]string
+
+// Value store the attributes as JSON
+func (a VariantAttributes) Value() (driver.Value, error) {
+	if a == nil {
+		a = VariantAttributes{}
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan read the attributes from their JSON
+func (a *VariantAttributes) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*a = VariantAttributes{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into VariantAttributes", value)
+	}
+	attributes := VariantAttributes{}
+	err := json.Unmarshal(data, &attributes)
+	if err != nil {
+		return err
+	}
+	*a = attributes
+	return nil
+}
+
+// ProductVariant struct for a sellable version of a Product, one size or color of it,
+// with its own SKU, unique among the variants of the product, stock and optionally its own price
+type ProductVariant struct {
+	ID            uuid.UUID         `gorm:"primary_key" json:"id"`
+	ProductID     uuid.UUID         `gorm:"not null;unique_index:idx_product_variant_attributes,idx_product_variant_sku" json:"product_id"`
+	Attributes    VariantAttributes `gorm:"type:varchar(500);not null;unique_index:idx_product_variant_attributes" json:"attributes"`
+	SKU           string            `gorm:"size:100;not null;unique_index:idx_product_variant_sku" json:"sku"`
+	PriceOverride money.Money       `gorm:"embedded;embedded_prefix:price_override_" json:"price_override"`
+	// ConvertedPrice the price of the variant, its own or the product one, in the currency asked for
+	ConvertedPrice *money.Conversion `gorm:"-" json:"converted_price,omitempty"`
+	Stock          int               `gorm:"not null;default:0" json:"stock"`
+	Reserved       int               `gorm:"not null;default:0" json:"reserved"`
+	CreatedAt      time.Time         `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt      time.Time         `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// Prepare set value for ProductVariant, attribute names are lowercase and a missing SKU is made
+// from the product and the attribute values
+func (v *ProductVariant) Prepare() {
+	attributes := VariantAttributes{}
+	for name, value := range v.Attributes {
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = html.EscapeString(strings.TrimSpace(value))
+		if name != "" && value != "" {
+			attributes[name] = value
+		}
+	}
+	v.Attributes = attributes
+	v.Reserved = 0
+	v.SKU = strings.ToUpper(strings.TrimSpace(v.SKU))
+	if v.SKU == "" {
+		v.SKU = DefaultVariantSKU(v.ProductID, v.Attributes)
+	}
+	v.CreatedAt = time.Now()
+	v.UpdatedAt = time.Now()
+}
+
+// Validate validations on ProductVariant
+func (v *ProductVariant) Validate() error {
+	if len(v.Attributes) == 0 {
+		return errors.New("Required Attributes")
+	}
+	if data, _ := v.Attributes.Value(); len(data.(string)) > 500 {
+		return errors.New("Attributes Too Long")
+	}
+	if v.SKU == "" || len(v.SKU) > 100 {
+		return errors.New("Invalid SKU")
+	}
+	if !v.PriceOverride.IsZero() {
+		if err := v.PriceOverride.Validate(); err != nil {
+			return err
+		}
+	}
+	if v.Stock < 0 {
+		return errors.New("Invalid Stock")
+	}
+	return nil
+}
+
+// SaveVariant save ProductVariant, the stock it starts with is received in the ledger
+func (v *ProductVariant) SaveVariant(db *gorm.DB) (*ProductVariant, error) {
+	err := transaction(db, func(tx *gorm.DB) error {
+		stock := v.Stock
+		v.Stock = 0
+		err := tx.Debug().Create(&v).Error
+		if err != nil || stock <= 0 {
+			return err
+		}
+		vid := v.ID
+		movement := StockMovement{ProductID: v.ProductID, VariantID: &vid, Kind: MovementReceive, Quantity: stock, Note: "Initial stock"}
+		err = applyStockMovement(tx, &movement, stock, 0)
+		v.Stock = stock
+		return err
+	})
+	if err != nil {
+		return &ProductVariant{}, err
+	}
+	return v, nil
+}
+
+// FindProductVariants get the variants of a Product, in the order they were added
+func (v *ProductVariant) FindProductVariants(db *gorm.DB, pid uuid.UUID) (*[]ProductVariant, error) {
+	variants := []ProductVariant{}
+	err := db.Debug().Model(&ProductVariant{}).Where("product_id = ?", pid).Order("created_at, sku").Find(&variants).Error
+	if err != nil {
+		return &[]ProductVariant{}, err
+	}
+	return &variants, nil
+}
+
+// FindVariantByID get a variant of the Product
+func (v *ProductVariant) FindVariantByID(db *gorm.DB, pid uuid.UUID, vid uuid.UUID) (*ProductVariant, error) {
+	err := db.Debug().Model(ProductVariant{}).Where("id = ? AND product_id = ?", vid, pid).Take(&v).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &ProductVariant{}, ErrVariantNotFound
+	}
+	if err != nil {
+		return &ProductVariant{}, err
+	}
+	return v, nil
+}
+
+// UpdateAVariant update a variant of the Product, its stock only moves through the ledger
+func (v *ProductVariant) UpdateAVariant(db *gorm.DB, pid uuid.UUID, vid uuid.UUID) (*ProductVariant, error) {
+	result := db.Debug().Model(&ProductVariant{}).Where("id = ? AND product_id = ?", vid, pid).UpdateColumns(
+		map[string]interface{}{
+			"attributes":              v.Attributes,
+			"sku":                     v.SKU,
+			"price_override_amount":   v.PriceOverride.Amount,
+			"price_override_currency": v.PriceOverride.Currency,
+			"updated_at":              time.Now(),
+		},
+	)
+	if result.Error != nil {
+		return &ProductVariant{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &ProductVariant{}, ErrVariantNotFound
+	}
+	return v.FindVariantByID(db, pid, vid)
+}
+
+// DeleteAVariant delete a variant of the Product, unless some of its units are reserved
+func (v *ProductVariant) DeleteAVariant(db *gorm.DB, pid uuid.UUID, vid uuid.UUID) (int64, error) {
+	result := db.Debug().Where("id = ? AND product_id = ? AND reserved = 0", vid, pid).Delete(&ProductVariant{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		count := 0
+		err := db.Debug().Model(&ProductVariant{}).Where("id = ? AND product_id = ?", vid, pid).Count(&count).Error
+		if err != nil {
+			return 0, err
+		}
+		if count > 0 {
+			return 0, ErrVariantReserved
+		}
+	}
+	return result.RowsAffected, nil
+}
+
+// Price what the variant sells for, its own price or the price of the product
+func (v *ProductVariant) Price(product Product) money.Money {
+	if v.PriceOverride.IsZero() {
+		return product.Price
+	}
+	return v.PriceOverride
+}
+
+// DefaultVariantSKU the SKU of a variant without one: the start of the product id and the
+// attribute values in the order of their names, e.g. 3F2A9C1B-BLUE-42
+func DefaultVariantSKU(pid uuid.UUID, attributes VariantAttributes) string {
+	names := []string{}
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := []string{strings.ToUpper(pid.String()[:8])}
+	for _, name := range names {
+		if value := slugify(html.UnescapeString(attributes[name])); value != "" {
+			parts = append(parts, strings.ToUpper(value))
+		}
+	}
+	return strings.Join(parts, "-")
+}
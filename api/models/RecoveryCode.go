@@ -0,0 +1,71 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// ErrRecoveryCodeInvalid the recovery code is unknown or already used
+var ErrRecoveryCodeInvalid = errors.New("Invalid Recovery Code")
+
+// RecoveryCode struct for the single-use codes that replace a lost authenticator. Only the hash is stored.
+type RecoveryCode struct {
+	ID        uuid.UUID  `gorm:"primary_key" json:"id"`
+	UserID    uuid.UUID  `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"size:64;not null" json:"-"`
+	UsedAt    *time.Time `gorm:"null" json:"used_at"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// ReplaceRecoveryCodes drop the recovery codes of the user and save new ones
+func (c *RecoveryCode) ReplaceRecoveryCodes(db *gorm.DB, uid uuid.UUID, hashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Debug().Where("user_id = ?", uid).Delete(&RecoveryCode{}).Error
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			code := RecoveryCode{
+				ID:        uuid.Must(uuid.NewRandom()),
+				UserID:    uid,
+				CodeHash:  hash,
+				CreatedAt: time.Now(),
+			}
+			err = tx.Debug().Create(&code).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ConsumeRecoveryCode mark an unused recovery code of the user as used.
+// The update is conditional, so a code can only be used once.
+func (c *RecoveryCode) ConsumeRecoveryCode(db *gorm.DB, uid uuid.UUID, hash string) error {
+	db = db.Debug().Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", uid, hash).
+		UpdateColumn("used_at", time.Now())
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+// CountUnusedRecoveryCodes the recovery codes the user has left
+func (c *RecoveryCode) CountUnusedRecoveryCodes(db *gorm.DB, uid uuid.UUID) (int, error) {
+	count := 0
+	err := db.Debug().Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", uid).Count(&count).Error
+	return count, err
+}
+
+// DeleteUserRecoveryCodes drop every recovery code of the user
+func (c *RecoveryCode) DeleteUserRecoveryCodes(db *gorm.DB, uid uuid.UUID) error {
+	return db.Debug().Where("user_id = ?", uid).Delete(&RecoveryCode{}).Error
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrRefreshTokenInvalid the refresh token is unknown, expired or revoked
+	ErrRefreshTokenInvalid = errors.New("Invalid Refresh Token")
+	// ErrRefreshTokenReused the refresh token was already rotated, so its family was revoked
+	ErrRefreshTokenReused = errors.New("Refresh Token Reused")
+)
+
+// RefreshToken struct for the refresh tokens we keep server-side.
+// Every rotation creates a new token in the same family.
+type RefreshToken struct {
+	ID        uuid.UUID  `gorm:"primary_key" json:"id"`
+	UserID    uuid.UUID  `gorm:"not null;index" json:"user_id"`
+	FamilyID  uuid.UUID  `gorm:"not null;index" json:"family_id"`
+	TokenHash string     `gorm:"size:64;not null;unique_index" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	RotatedAt *time.Time `gorm:"null" json:"rotated_at"`
+	RevokedAt *time.Time `gorm:"null" json:"revoked_at"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// SaveRefreshToken save RefreshToken
+func (rt *RefreshToken) SaveRefreshToken(db *gorm.DB) (*RefreshToken, error) {
+	err := db.Debug().Create(&rt).Error
+	if err != nil {
+		return &RefreshToken{}, err
+	}
+	return rt, nil
+}
+
+// FindRefreshTokenByHash find RefreshToken by the hash of the token
+func (rt *RefreshToken) FindRefreshTokenByHash(db *gorm.DB, hash string) (*RefreshToken, error) {
+	err := db.Debug().Model(RefreshToken{}).Where("token_hash = ?", hash).Take(&rt).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &RefreshToken{}, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return &RefreshToken{}, err
+	}
+	return rt, nil
+}
+
+// RotateRefreshToken mark the RefreshToken as used and save the next one of the family.
+// The update is conditional, so two concurrent rotations of the same token cannot both win.
+func (rt *RefreshToken) RotateRefreshToken(db *gorm.DB, next *RefreshToken) (*RefreshToken, error) {
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		tx = tx.Debug().Model(&RefreshToken{}).
+			Where("id = ? AND rotated_at IS NULL AND revoked_at IS NULL", rt.ID).
+			UpdateColumn("rotated_at", now)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		if tx.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+		return tx.Create(next).Error
+	})
+	if err != nil {
+		return &RefreshToken{}, err
+	}
+	rt.RotatedAt = &now
+	return next, nil
+}
+
+// RevokeRefreshTokenFamily revoke every token of a family
+func (rt *RefreshToken) RevokeRefreshTokenFamily(db *gorm.DB, familyID uuid.UUID) (int64, error) {
+	db = db.Debug().Model(&RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", familyID).UpdateColumn("revoked_at", time.Now())
+	if db.Error != nil {
+		return 0, db.Error
+	}
+	return db.RowsAffected, nil
+}
+
+// RevokeUserRefreshTokens revoke every refresh token of a user
+func (rt *RefreshToken) RevokeUserRefreshTokens(db *gorm.DB, uid uuid.UUID) (int64, error) {
+	db = db.Debug().Model(&RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", uid).UpdateColumn("revoked_at", time.Now())
+	if db.Error != nil {
+		return 0, db.Error
+	}
+	return db.RowsAffected, nil
+}
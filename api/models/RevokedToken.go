@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// RevokedToken struct for access tokens revoked before they expire, keyed by jti
+type RevokedToken struct {
+	JTI       string    `gorm:"primary_key;size:64" json:"jti"`
+	UserID    uuid.UUID `gorm:"not null;index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// TokenCutoff struct for the moment before which every token of a user is revoked
+type TokenCutoff struct {
+	UserID       uuid.UUID `gorm:"primary_key" json:"user_id"`
+	IssuedBefore time.Time `gorm:"not null" json:"issued_before"`
+	UpdatedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// SaveRevokedToken save RevokedToken, revoking the same jti twice is not an error
+func (rt *RevokedToken) SaveRevokedToken(db *gorm.DB) (*RevokedToken, error) {
+	err := db.Debug().Where(RevokedToken{JTI: rt.JTI}).FirstOrCreate(&rt).Error
+	if err != nil {
+		return &RevokedToken{}, err
+	}
+	return rt, nil
+}
+
+// IsTokenRevoked check if a jti was revoked
+func (rt *RevokedToken) IsTokenRevoked(db *gorm.DB, jti string) (bool, error) {
+	var count int
+	err := db.Debug().Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// DeleteExpiredRevokedTokens remove revoked tokens that would be rejected as expired anyway
+func (rt *RevokedToken) DeleteExpiredRevokedTokens(db *gorm.DB) (int64, error) {
+	db = db.Debug().Where("expires_at < ?", time.Now()).Delete(&RevokedToken{})
+	if db.Error != nil {
+		return 0, db.Error
+	}
+	return db.RowsAffected, nil
+}
+
+// SaveTokenCutoff create or move forward the cutoff of a user
+func (tc *TokenCutoff) SaveTokenCutoff(db *gorm.DB) (*TokenCutoff, error) {
+	err := db.Debug().Where(TokenCutoff{UserID: tc.UserID}).
+		Assign(TokenCutoff{IssuedBefore: tc.IssuedBefore, UpdatedAt: time.Now()}).
+		FirstOrCreate(&tc).Error
+	if err != nil {
+		return &TokenCutoff{}, err
+	}
+	return tc, nil
+}
+
+// FindTokenCutoff find the cutoff of a user, a zero time when there is none
+func (tc *TokenCutoff) FindTokenCutoff(db *gorm.DB, uid uuid.UUID) (time.Time, error) {
+	err := db.Debug().Model(&TokenCutoff{}).Where("user_id = ?", uid).Take(&tc).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return tc.IssuedBefore, nil
+}
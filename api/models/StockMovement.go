@@ -0,0 +1,171 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// MovementReceive units arrived and were added to the stock
+	MovementReceive = "receive"
+	// MovementAdjust the stock was corrected up or down, after a count or a loss
+	MovementAdjust = "adjust"
+	// MovementReserve units were set aside for a reservation
+	MovementReserve = "reserve"
+	// MovementRelease the units of a reservation went back to the available stock
+	MovementRelease = "release"
+	// MovementSell the units of a confirmed reservation left the stock
+	MovementSell = "sell"
+)
+
+// MaxStockMovements the most movements listed at once
+const MaxStockMovements = 200
+
+var (
+	// ErrInsufficientStock there are not enough available units for the change
+	ErrInsufficientStock = errors.New("Insufficient Stock")
+	// ErrInvalidQuantity the quantity of the movement makes no sense for its kind
+	ErrInvalidQuantity = errors.New("Invalid Quantity")
+)
+
+// StockMovement struct for the ledger of every change of the stock of a product or one of
+// its variants. Movements are only ever added, with the counts they left behind.
+type StockMovement struct {
+	ID            uuid.UUID  `gorm:"primary_key" json:"id"`
+	ProductID     uuid.UUID  `gorm:"not null;index" json:"product_id"`
+	VariantID     *uuid.UUID `gorm:"null;index" json:"variant_id"`
+	Kind          string     `gorm:"size:20;not null" json:"kind"`
+	Quantity      int        `gorm:"not null" json:"quantity"`
+	StockAfter    int        `gorm:"not null" json:"stock_after"`
+	ReservedAfter int        `gorm:"not null" json:"reserved_after"`
+	ReservationID *uuid.UUID `gorm:"null;index" json:"reservation_id"`
+	ActorID       *uuid.UUID `gorm:"null" json:"actor_id"`
+	Note          string     `gorm:"size:500;null" json:"note"`
+	CreatedAt     time.Time  `gorm:"default:CURRENT_TIMESTAMP;index" json:"created_at"`
+}
+
+// StockLevel the counts of a product or variant, available is what can still be reserved
+type StockLevel struct {
+	Stock     int `json:"stock"`
+	Reserved  int `json:"reserved"`
+	Available int `json:"available"`
+}
+
+// Prepare set value for StockMovement
+func (m *StockMovement) Prepare() {
+	m.Kind = strings.ToLower(strings.TrimSpace(m.Kind))
+	m.Note = html.EscapeString(strings.TrimSpace(m.Note))
+	if m.VariantID != nil && *m.VariantID == uuid.Nil {
+		m.VariantID = nil
+	}
+	m.CreatedAt = time.Now()
+}
+
+// Validate validations on the movements made by hand, receive and adjust.
+// The others only follow reservations.
+func (m *StockMovement) Validate() error {
+	switch m.Kind {
+	case MovementReceive:
+		if m.Quantity <= 0 {
+			return ErrInvalidQuantity
+		}
+	case MovementAdjust:
+		if m.Quantity == 0 {
+			return ErrInvalidQuantity
+		}
+	default:
+		return errors.New("Invalid Movement Kind")
+	}
+	return nil
+}
+
+// SaveStockMovement apply a receive or adjust movement to the stock and record it.
+// An adjustment can never take the stock below what is reserved.
+func (m *StockMovement) SaveStockMovement(db *gorm.DB) (*StockMovement, error) {
+	err := transaction(db, func(tx *gorm.DB) error {
+		return applyStockMovement(tx, m, m.Quantity, 0)
+	})
+	if err != nil {
+		return &StockMovement{}, err
+	}
+	return m, nil
+}
+
+// FindStockMovements get the latest movements of a Product and its variants, newest first
+func (m *StockMovement) FindStockMovements(db *gorm.DB, pid uuid.UUID, limit int) (*[]StockMovement, error) {
+	if limit <= 0 || limit > MaxStockMovements {
+		limit = MaxStockMovements
+	}
+	movements := []StockMovement{}
+	err := db.Debug().Model(&StockMovement{}).Where("product_id = ?", pid).
+		Order("created_at desc").Limit(limit).Find(&movements).Error
+	if err != nil {
+		return &[]StockMovement{}, err
+	}
+	return &movements, nil
+}
+
+// applyStockMovement move the stock and reserved counts of the product, or of its variant,
+// and record the movement with the counts it left. The update is conditional: it only
+// happens while no count goes negative and the stock still covers what is reserved, so
+// concurrent movements cannot oversell. It must run inside a transaction.
+func applyStockMovement(tx *gorm.DB, m *StockMovement, stockDelta, reservedDelta int) error {
+	target := stockTarget(tx, m.ProductID, m.VariantID)
+	result := target.Where("stock + ? >= reserved + ? AND reserved + ? >= 0", stockDelta, reservedDelta, reservedDelta).
+		UpdateColumns(map[string]interface{}{
+			"stock":    gorm.Expr("stock + ?", stockDelta),
+			"reserved": gorm.Expr("reserved + ?", reservedDelta),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		// Nothing changed, either the row is missing or the counts did not allow it
+		_, err := findStockLevel(tx, m.ProductID, m.VariantID)
+		if err != nil {
+			return err
+		}
+		return ErrInsufficientStock
+	}
+
+	level, err := findStockLevel(tx, m.ProductID, m.VariantID)
+	if err != nil {
+		return err
+	}
+	m.ID = uuid.Must(uuid.NewRandom())
+	m.StockAfter = level.Stock
+	m.ReservedAfter = level.Reserved
+	m.CreatedAt = time.Now()
+	return tx.Debug().Create(m).Error
+}
+
+// stockTarget the row holding the counts, the variant when there is one
+func stockTarget(db *gorm.DB, pid uuid.UUID, vid *uuid.UUID) *gorm.DB {
+	if vid != nil {
+		return db.Debug().Model(&ProductVariant{}).Where("id = ? AND product_id = ?", *vid, pid)
+	}
+	return db.Debug().Model(&Product{}).Where("id = ?", pid)
+}
+
+// findStockLevel the counts of the product or its variant
+func findStockLevel(db *gorm.DB, pid uuid.UUID, vid *uuid.UUID) (StockLevel, error) {
+	level := StockLevel{}
+	err := stockTarget(db, pid, vid).Select("stock, reserved").Row().Scan(&level.Stock, &level.Reserved)
+	if err == sql.ErrNoRows {
+		if vid != nil {
+			return StockLevel{}, ErrVariantNotFound
+		}
+		return StockLevel{}, errors.New("Product Not Found")
+	}
+	if err != nil {
+		return StockLevel{}, err
+	}
+	level.Available = level.Stock - level.Reserved
+	return level, nil
+}
@@ -0,0 +1,205 @@
+package models
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// ReservationPending the units are set aside until the reservation expires
+	ReservationPending = "pending"
+	// ReservationConfirmed the units were sold
+	ReservationConfirmed = "confirmed"
+	// ReservationReleased the units were given back before the reservation expired
+	ReservationReleased = "released"
+	// ReservationExpired nobody confirmed the reservation in time and its units were given back
+	ReservationExpired = "expired"
+)
+
+var (
+	// ErrReservationNotFound the reservation does not exist or is of another product
+	ErrReservationNotFound = errors.New("Reservation Not Found")
+	// ErrReservationClosed the reservation was already confirmed, released or expired
+	ErrReservationClosed = errors.New("Reservation Already Closed")
+	// ErrReservationExpired the reservation expired before it was confirmed
+	ErrReservationExpired = errors.New("Reservation Expired")
+)
+
+// StockReservation struct for units of a product or variant set aside for a while, e.g. during
+// a checkout. Confirming it sells the units, releasing it or letting it expire gives them back.
+type StockReservation struct {
+	ID        uuid.UUID  `gorm:"primary_key" json:"id"`
+	ProductID uuid.UUID  `gorm:"not null;index" json:"product_id"`
+	VariantID *uuid.UUID `gorm:"null" json:"variant_id"`
+	Quantity  int        `gorm:"not null" json:"quantity"`
+	Status    string     `gorm:"size:20;not null;index:idx_stock_reservations_status_expires" json:"status"`
+	ExpiresAt time.Time  `gorm:"not null;index:idx_stock_reservations_status_expires" json:"expires_at"`
+	UserID    uuid.UUID  `gorm:"not null" json:"user_id"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+}
+
+// Prepare set value for StockReservation
+func (r *StockReservation) Prepare() {
+	if r.VariantID != nil && *r.VariantID == uuid.Nil {
+		r.VariantID = nil
+	}
+	r.Status = ReservationPending
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+}
+
+// Validate validations on StockReservation
+func (r *StockReservation) Validate() error {
+	if r.Quantity <= 0 {
+		return ErrInvalidQuantity
+	}
+	return nil
+}
+
+// ReserveStock set the units aside and save the reservation, it fails with
+// ErrInsufficientStock when fewer units are available
+func (r *StockReservation) ReserveStock(db *gorm.DB, ttl time.Duration) (*StockReservation, error) {
+	r.ID = uuid.Must(uuid.NewRandom())
+	r.Status = ReservationPending
+	r.ExpiresAt = time.Now().Add(ttl)
+	err := transaction(db, func(tx *gorm.DB) error {
+		err := applyStockMovement(tx, r.movement(MovementReserve), 0, r.Quantity)
+		if err != nil {
+			return err
+		}
+		return tx.Debug().Create(r).Error
+	})
+	if err != nil {
+		return &StockReservation{}, err
+	}
+	return r, nil
+}
+
+// FindReservationByID get a reservation of the Product
+func (r *StockReservation) FindReservationByID(db *gorm.DB, pid uuid.UUID, rid uuid.UUID) (*StockReservation, error) {
+	err := db.Debug().Model(StockReservation{}).Where("id = ? AND product_id = ?", rid, pid).Take(&r).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &StockReservation{}, ErrReservationNotFound
+	}
+	if err != nil {
+		return &StockReservation{}, err
+	}
+	return r, nil
+}
+
+// ConfirmReservation sell the units of a pending reservation that has not expired yet
+func (r *StockReservation) ConfirmReservation(db *gorm.DB, pid uuid.UUID, rid uuid.UUID) (*StockReservation, error) {
+	return r.closeReservation(db, pid, rid, ReservationConfirmed)
+}
+
+// ReleaseReservation give the units of a pending reservation back
+func (r *StockReservation) ReleaseReservation(db *gorm.DB, pid uuid.UUID, rid uuid.UUID) (*StockReservation, error) {
+	return r.closeReservation(db, pid, rid, ReservationReleased)
+}
+
+// ExpireStockReservations give back the units of the pending reservations past their expiry,
+// returning how many expired
+func ExpireStockReservations(db *gorm.DB, now time.Time) (int, error) {
+	expired := []StockReservation{}
+	err := db.Debug().Model(&StockReservation{}).
+		Where("status = ? AND expires_at <= ?", ReservationPending, now).
+		Limit(500).Find(&expired).Error
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, e := range expired {
+		r := StockReservation{}
+		_, err = r.closeReservation(db, e.ProductID, e.ID, ReservationExpired)
+		if err == ErrReservationClosed {
+			// Confirmed or released meanwhile
+			continue
+		}
+		if err != nil {
+			log.Printf("cannot expire the reservation %s: %v", e.ID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// releasePendingReservations give back the units of the pending reservations of the products
+func releasePendingReservations(db *gorm.DB, pids []uuid.UUID) error {
+	if len(pids) == 0 {
+		return nil
+	}
+	pending := []StockReservation{}
+	err := db.Debug().Model(&StockReservation{}).Where("status = ? AND product_id IN (?)", ReservationPending, pids).Find(&pending).Error
+	if err != nil {
+		return err
+	}
+	for _, p := range pending {
+		r := StockReservation{}
+		_, err = r.ReleaseReservation(db, p.ProductID, p.ID)
+		if err != nil && err != ErrReservationClosed {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeReservation move a pending reservation to its final status and its units with it.
+// The status update is conditional, so a reservation is closed only once, however many
+// requests and sweepers race for it.
+func (r *StockReservation) closeReservation(db *gorm.DB, pid uuid.UUID, rid uuid.UUID, status string) (*StockReservation, error) {
+	_, err := r.FindReservationByID(db, pid, rid)
+	if err != nil {
+		return &StockReservation{}, err
+	}
+
+	now := time.Now()
+	err = transaction(db, func(tx *gorm.DB) error {
+		query := tx.Debug().Model(&StockReservation{}).Where("id = ? AND status = ?", rid, ReservationPending)
+		switch status {
+		case ReservationConfirmed:
+			query = query.Where("expires_at > ?", now)
+		case ReservationExpired:
+			query = query.Where("expires_at <= ?", now)
+		}
+		result := query.UpdateColumns(map[string]interface{}{"status": status, "updated_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if status == ReservationConfirmed && r.Status == ReservationPending && !r.ExpiresAt.After(now) {
+				return ErrReservationExpired
+			}
+			return ErrReservationClosed
+		}
+		if status == ReservationConfirmed {
+			return applyStockMovement(tx, r.movement(MovementSell), -r.Quantity, -r.Quantity)
+		}
+		return applyStockMovement(tx, r.movement(MovementRelease), 0, -r.Quantity)
+	})
+	if err != nil {
+		return &StockReservation{}, err
+	}
+	r.Status = status
+	r.UpdatedAt = now
+	return r, nil
+}
+
+// movement the ledger entry of a change of the reservation
+func (r *StockReservation) movement(kind string) *StockMovement {
+	rid := r.ID
+	uid := r.UserID
+	return &StockMovement{
+		ProductID:     r.ProductID,
+		VariantID:     r.VariantID,
+		Kind:          kind,
+		Quantity:      r.Quantity,
+		ReservationID: &rid,
+		ActorID:       &uid,
+	}
+}
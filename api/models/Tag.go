@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// ErrTagNotFound the tag does not exist
+var ErrTagNotFound = errors.New("Tag Not Found")
+
+// Tag struct for the tags products are labelled with, through the product_tags table
+type Tag struct {
+	ID        uuid.UUID `gorm:"primary_key" json:"id"`
+	Name      string    `gorm:"size:100;not null;unique_index" json:"name"`
+	Slug      string    `gorm:"size:100;not null;unique_index" json:"slug"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// Prepare set value for Tag
+func (t *Tag) Prepare() {
+	t.Name = html.EscapeString(strings.TrimSpace(t.Name))
+	t.Slug = slugify(html.UnescapeString(t.Name))
+	t.CreatedAt = time.Now()
+}
+
+// Validate validations on Tag
+func (t *Tag) Validate() error {
+	if t.Name == "" {
+		return errors.New("Required Name")
+	}
+	if t.Slug == "" {
+		return errors.New("Invalid Name")
+	}
+	return nil
+}
+
+// SaveTag save Tag
+func (t *Tag) SaveTag(db *gorm.DB) (*Tag, error) {
+	err := db.Debug().Create(&t).Error
+	if err != nil {
+		return &Tag{}, err
+	}
+	return t, nil
+}
+
+// FindAllTags get every Tag by name
+func (t *Tag) FindAllTags(db *gorm.DB) (*[]Tag, error) {
+	tags := []Tag{}
+	err := db.Debug().Model(&Tag{}).Order("name").Find(&tags).Error
+	if err != nil {
+		return &[]Tag{}, err
+	}
+	return &tags, nil
+}
+
+// FindTagByID find Tag by id
+func (t *Tag) FindTagByID(db *gorm.DB, tid uuid.UUID) (*Tag, error) {
+	err := db.Debug().Model(Tag{}).Where("id = ?", tid).Take(&t).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Tag{}, ErrTagNotFound
+	}
+	if err != nil {
+		return &Tag{}, err
+	}
+	return t, nil
+}
+
+// FindTagsByID find the Tags of the ids, every one of them has to exist
+func (t *Tag) FindTagsByID(db *gorm.DB, ids []uuid.UUID) ([]Tag, error) {
+	tags := []Tag{}
+	if len(ids) == 0 {
+		return tags, nil
+	}
+	err := db.Debug().Model(&Tag{}).Where("id IN (?)", ids).Find(&tags).Error
+	if err != nil {
+		return nil, err
+	}
+	unique := map[uuid.UUID]bool{}
+	for _, id := range ids {
+		unique[id] = true
+	}
+	if len(tags) != len(unique) {
+		return nil, ErrTagNotFound
+	}
+	return tags, nil
+}
+
+// UpdateATag rename a Tag
+func (t *Tag) UpdateATag(db *gorm.DB, tid uuid.UUID) (*Tag, error) {
+	err := db.Debug().Model(&Tag{}).Where("id = ?", tid).Take(&Tag{}).UpdateColumns(
+		map[string]interface{}{
+			"name": t.Name,
+			"slug": t.Slug,
+		},
+	).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Tag{}, ErrTagNotFound
+	}
+	if err != nil {
+		return &Tag{}, err
+	}
+	return t.FindTagByID(db, tid)
+}
+
+// DeleteATag delete a Tag and take it off every product
+func (t *Tag) DeleteATag(db *gorm.DB, tid uuid.UUID) (int64, error) {
+	var deleted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Debug().Exec("DELETE FROM product_tags WHERE tag_id = ?", tid).Error
+		if err != nil {
+			return err
+		}
+		tx = tx.Debug().Where("id = ?", tid).Delete(&Tag{})
+		deleted = tx.RowsAffected
+		return tx.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
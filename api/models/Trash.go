@@ -0,0 +1,91 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrNotInTrash the product or user is not deleted, or was purged from the trash
+	ErrNotInTrash = errors.New("Not In Trash")
+	// ErrOwnerDeleted the owner of the product is in the trash too, restoring them restores it
+	ErrOwnerDeleted = errors.New("Owner Deleted")
+)
+
+// purgeBatchSize how many products are purged per query of the trash
+const purgeBatchSize = 100
+
+// TrashPurge what a purge of the trash deleted for good
+type TrashPurge struct {
+	Products int
+	Users    int
+	// BlobKeys the files of the purged product images, still in the blob store
+	BlobKeys []string
+}
+
+// PurgeTrash delete for good the products and the users deleted before the time, the products
+// with everything that belongs to them. The products of a purged user go with them.
+func PurgeTrash(db *gorm.DB, before time.Time) (*TrashPurge, error) {
+	purge := TrashPurge{BlobKeys: []string{}}
+	for {
+		pids := []uuid.UUID{}
+		err := db.Debug().Unscoped().Model(&Product{}).
+			Where("deleted_at < ? OR owner_id IN (SELECT id FROM users WHERE deleted_at < ?)", before, before).
+			Limit(purgeBatchSize).Pluck("id", &pids).Error
+		if err != nil {
+			return &purge, err
+		}
+		for _, pid := range pids {
+			keys, err := purgeProduct(db, pid)
+			if err != nil {
+				return &purge, err
+			}
+			purge.Products++
+			purge.BlobKeys = append(purge.BlobKeys, keys...)
+		}
+		if len(pids) < purgeBatchSize {
+			break
+		}
+	}
+	for {
+		uids := []uuid.UUID{}
+		err := db.Debug().Unscoped().Model(&User{}).Where("deleted_at < ?", before).
+			Limit(purgeBatchSize).Pluck("id", &uids).Error
+		if err != nil {
+			return &purge, err
+		}
+		for _, uid := range uids {
+			err = purgeUser(db, uid)
+			if err != nil {
+				return &purge, err
+			}
+			purge.Users++
+		}
+		if len(uids) < purgeBatchSize {
+			break
+		}
+	}
+	return &purge, nil
+}
+
+// purgeUser delete the User for good, with their refresh tokens, API keys, one-time tokens,
+// recovery codes and import jobs. Their products must have been purged first.
+func purgeUser(db *gorm.DB, uid uuid.UUID) error {
+	return transaction(db, func(tx *gorm.DB) error {
+		owned := []interface{}{&RefreshToken{}, &APIKey{}, &OneTimeToken{}, &RecoveryCode{}}
+		for _, value := range owned {
+			err := tx.Debug().Where("user_id = ?", uid).Delete(value).Error
+			if err != nil {
+				return err
+			}
+		}
+		err := tx.Debug().Where("owner_id = ?", uid).Delete(&ImportJob{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Debug().Unscoped().Where("id = ?", uid).Delete(&User{}).Error
+	})
+}
@@ -13,38 +13,73 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	// RoleUser an ordinary user, who manages only their own account and products
+	RoleUser = "user"
+	// RoleAdmin an administrator, who manages every user and product
+	RoleAdmin = "admin"
+)
+
+// User struct for User. Deleting it sets DeletedAt, it keeps its email and nickname taken
+// until it is restored or purged from the trash. ActorID is the user deleting or restoring it,
+// recorded in the revisions of its products.
 type User struct {
-	ID        uuid.UUID    `gorm:"primary_key;auto_increment" json:"id"`
-	Fullname  string    `gorm:"size:255;not null;unique" json:"fullname"`
-	Nickname  string    `gorm:"size:255;not null;unique" json:"nickname"`
-	Email     string    `gorm:"size:100;not null;unique" json:"email"`
-	Password  string    `gorm:"size:100;not null;" json:"password"`
-	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ID              uuid.UUID  `gorm:"primary_key;auto_increment" json:"id"`
+	Fullname        string     `gorm:"size:255;not null;unique" json:"fullname"`
+	Nickname        string     `gorm:"size:255;not null;unique" json:"nickname"`
+	Email           string     `gorm:"size:100;not null;unique" json:"email"`
+	Password        string     `gorm:"size:100;not null;" json:"password"`
+	Role            string     `gorm:"size:20;not null;default:'user'" json:"role"`
+	EmailVerified   bool       `gorm:"not null;default:false" json:"email_verified"`
+	EmailVerifiedAt *time.Time `gorm:"null" json:"email_verified_at"`
+	TOTPSecret      string     `gorm:"size:64;null" json:"-"`
+	TOTPEnabled     bool       `gorm:"not null;default:false" json:"totp_enabled"`
+	TOTPLastCounter int64      `gorm:"not null;default:0" json:"-"`
+	CreatedAt       time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt       time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	DeletedAt       *time.Time `gorm:"index" json:"deleted_at,omitempty"`
+	ActorID         *uuid.UUID `gorm:"-" json:"-"`
 }
 
-//ResponseUser return for the struct Product
+//ResponseUser return for the struct User, without the password hash
 type ResponseUser struct {
-	ID          uuid.UUID
-	Fullname    string
-	Nickname    string
-	Email       string
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID            uuid.UUID  `json:"id"`
+	Fullname      string     `json:"fullname"`
+	Nickname      string     `json:"nickname"`
+	Email         string     `json:"email"`
+	Role          string     `json:"role"`
+	EmailVerified bool       `json:"email_verified"`
+	TOTPEnabled   bool       `json:"totp_enabled"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
 }
 
-//SanitizeUser the Product response
+//SanitizeUser the User response
 func SanitizeUser(u User) ResponseUser {
 	return ResponseUser{
 		u.ID,
 		u.Fullname,
 		u.Nickname,
 		u.Email,
+		u.Role,
+		u.EmailVerified,
+		u.TOTPEnabled,
 		u.CreatedAt,
 		u.UpdatedAt,
+		u.DeletedAt,
 	}
 }
 
+//SanitizeUsers the response of a list of Users
+func SanitizeUsers(users []User) []ResponseUser {
+	sanitized := []ResponseUser{}
+	for _, u := range users {
+		sanitized = append(sanitized, SanitizeUser(u))
+	}
+	return sanitized
+}
+
 func Hash(password string) ([]byte, error) {
 	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 }
@@ -66,6 +101,10 @@ func (u *User) Prepare() {
 	u.Fullname = html.EscapeString(strings.TrimSpace(u.Fullname))
 	u.Nickname = html.EscapeString(strings.TrimSpace(u.Nickname))
 	u.Email = html.EscapeString(strings.TrimSpace(u.Email))
+	u.Role = strings.ToLower(strings.TrimSpace(u.Role))
+	if u.Role == "" {
+		u.Role = RoleUser
+	}
 	u.CreatedAt = time.Now()
 	u.UpdatedAt = time.Now()
 }
@@ -151,37 +190,256 @@ func (u *User) FindUserByID(db *gorm.DB, uid uuid.UUID) (*User, error) {
 
 func (u *User) UpdateAUser(db *gorm.DB, uid uuid.UUID) (*User, error) {
 
-	// To hash the password
-	err := u.BeforeSave()
+	columns := map[string]interface{}{
+		"fullname":   u.Fullname,
+		"nickname":   u.Nickname,
+		"email":      u.Email,
+		"updated_at": time.Now(),
+	}
+	// A new email must be verified again
+	current := User{}
+	err := db.Debug().Model(&User{}).Where("id = ?", uid).Take(&current).Error
+	if err != nil {
+		return &User{}, err
+	}
+	if current.Email != u.Email {
+		columns["email_verified"] = false
+		columns["email_verified_at"] = nil
+	}
+	// To hash the password, an empty password keeps the current one
+	if u.Password != "" {
+		err := u.BeforeSave()
+		if err != nil {
+			log.Fatal(err)
+		}
+		columns["password"] = u.Password
+	}
+	db = db.Debug().Model(&User{}).Where("id = ?", uid).Take(&User{}).UpdateColumns(columns)
+	if db.Error != nil {
+		return &User{}, db.Error
+	}
+	// This is the display the updated user
+	err = db.Debug().Model(&User{}).Where("id = ?", uid).Take(&u).Error
+	if err != nil {
+		return &User{}, err
+	}
+	return u, nil
+}
+
+// UpdatePassword hash and store a new password for the user
+func (u *User) UpdatePassword(db *gorm.DB, uid uuid.UUID, password string) error {
+	hashedPassword, err := Hash(password)
+	if err != nil {
+		return err
+	}
+	db = db.Debug().Model(&User{}).Where("id = ?", uid).Take(&User{}).UpdateColumns(
+		map[string]interface{}{
+			"password":   string(hashedPassword),
+			"updated_at": time.Now(),
+		},
+	)
+	return db.Error
+}
+
+// ResetPassword set the password of the user a password reset token was sent to. The token is
+// consumed in the same transaction, so it is only used up once the password was changed.
+func (u *User) ResetPassword(db *gorm.DB, tokenHash string, password string) (uuid.UUID, error) {
+	var uid uuid.UUID
+	err := transaction(db, func(tx *gorm.DB) error {
+		oneTimeToken := OneTimeToken{}
+		_, err := oneTimeToken.ConsumeOneTimeToken(tx, PurposePasswordReset, tokenHash)
+		if err != nil {
+			return err
+		}
+		uid = oneTimeToken.UserID
+		return u.UpdatePassword(tx, uid, password)
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uid, nil
+}
+
+// MarkEmailVerified record that the user proved they own their email
+func (u *User) MarkEmailVerified(db *gorm.DB, uid uuid.UUID) error {
+	now := time.Now()
+	db = db.Debug().Model(&User{}).Where("id = ?", uid).Take(&User{}).UpdateColumns(
+		map[string]interface{}{
+			"email_verified":    true,
+			"email_verified_at": now,
+			"updated_at":        now,
+		},
+	)
+	return db.Error
+}
+
+// SetTOTPSecret store a secret the user still has to confirm, 2FA stays off until then
+func (u *User) SetTOTPSecret(db *gorm.DB, uid uuid.UUID, secret string) error {
+	db = db.Debug().Model(&User{}).Where("id = ?", uid).Take(&User{}).UpdateColumns(
+		map[string]interface{}{
+			"totp_secret":       secret,
+			"totp_enabled":      false,
+			"totp_last_counter": 0,
+			"updated_at":        time.Now(),
+		},
+	)
+	return db.Error
+}
+
+// EnableTOTP turn 2FA on, counter is the time step of the code that confirmed the secret
+func (u *User) EnableTOTP(db *gorm.DB, uid uuid.UUID, counter int64) error {
+	db = db.Debug().Model(&User{}).Where("id = ?", uid).Take(&User{}).UpdateColumns(
+		map[string]interface{}{
+			"totp_enabled":      true,
+			"totp_last_counter": counter,
+			"updated_at":        time.Now(),
+		},
+	)
+	return db.Error
+}
+
+// UseTOTPCounter record the time step of an accepted code.
+// The update is conditional, so the same code cannot be used twice, even concurrently.
+func (u *User) UseTOTPCounter(db *gorm.DB, uid uuid.UUID, counter int64) (bool, error) {
+	db = db.Debug().Model(&User{}).Where("id = ? AND totp_last_counter < ?", uid, counter).UpdateColumn("totp_last_counter", counter)
+	if db.Error != nil {
+		return false, db.Error
+	}
+	return db.RowsAffected == 1, nil
+}
+
+// DisableTOTP turn 2FA off and forget the secret
+func (u *User) DisableTOTP(db *gorm.DB, uid uuid.UUID) error {
+	db = db.Debug().Model(&User{}).Where("id = ?", uid).Take(&User{}).UpdateColumns(
+		map[string]interface{}{
+			"totp_secret":       "",
+			"totp_enabled":      false,
+			"totp_last_counter": 0,
+			"updated_at":        time.Now(),
+		},
+	)
+	return db.Error
+}
+
+// FindUserByEmail find User by email
+func (u *User) FindUserByEmail(db *gorm.DB, email string) (*User, error) {
+	err := db.Debug().Model(User{}).Where("email = ?", email).Take(&u).Error
 	if err != nil {
-		log.Fatal(err)
+		return &User{}, err
+	}
+	return u, nil
+}
+
+// ValidRole check if the role is one we know
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleAdmin
+}
+
+// UpdateARole change the role of a user
+func (u *User) UpdateARole(db *gorm.DB, uid uuid.UUID, role string) (*User, error) {
+	if !ValidRole(role) {
+		return &User{}, errors.New("Invalid Role")
 	}
 	db = db.Debug().Model(&User{}).Where("id = ?", uid).Take(&User{}).UpdateColumns(
 		map[string]interface{}{
-			"password":  u.Password,
-			"fullname":  u.Fullname,
-			"nickname":  u.Nickname,
-			"email":     u.Email,
+			"role":       role,
 			"updated_at": time.Now(),
 		},
 	)
 	if db.Error != nil {
 		return &User{}, db.Error
 	}
-	// This is the display the updated user
-	err = db.Debug().Model(&User{}).Where("id = ?", uid).Take(&u).Error
+	err := db.Debug().Model(&User{}).Where("id = ?", uid).Take(&u).Error
 	if err != nil {
 		return &User{}, err
 	}
 	return u, nil
 }
 
+// DeleteAUser move the User to the trash, with their products. They are deleted at the same time,
+// so restoring the User brings back those and not the ones deleted before.
 func (u *User) DeleteAUser(db *gorm.DB, uid uuid.UUID) (int64, error) {
 
-	db = db.Debug().Model(&User{}).Where("id = ?", uid).Take(&User{}).Delete(&User{})
+	var deleted int64
+	err := transaction(db, func(tx *gorm.DB) error {
+		err := tx.Debug().Model(&User{}).Where("id = ?", uid).Take(&User{}).Error
+		if err != nil {
+			return err
+		}
+		pids := []uuid.UUID{}
+		err = tx.Debug().Model(&Product{}).Where("owner_id = ?", uid).Pluck("id", &pids).Error
+		if err != nil {
+			return err
+		}
+		err = releasePendingReservations(tx, pids)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		err = tx.Debug().Model(&Product{}).Where("owner_id = ?", uid).UpdateColumn("deleted_at", now).Error
+		if err != nil {
+			return err
+		}
+		for _, pid := range pids {
+			err = recordRevision(tx, pid, RevisionDelete, u.ActorID, nil, RevisionChanges{})
+			if err != nil {
+				return err
+			}
+		}
+		result := tx.Debug().Model(&User{}).Where("id = ?", uid).UpdateColumn("deleted_at", now)
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
 
-	if db.Error != nil {
-		return 0, db.Error
+// FindDeletedUsers get the Users in the trash, the last deleted first
+func (u *User) FindDeletedUsers(db *gorm.DB) (*[]User, error) {
+	users := []User{}
+	err := db.Debug().Unscoped().Model(&User{}).Where("deleted_at IS NOT NULL").Order("deleted_at desc").Limit(100).Find(&users).Error
+	if err != nil {
+		return &[]User{}, err
 	}
-	return db.RowsAffected, nil
-}
\ No newline at end of file
+	return &users, nil
+}
+
+// RestoreAUser take the User out of the trash, with the products deleted along with them
+func (u *User) RestoreAUser(db *gorm.DB, uid uuid.UUID) (*User, error) {
+
+	err := transaction(db, func(tx *gorm.DB) error {
+		err := tx.Debug().Unscoped().Model(&User{}).Where("id = ? AND deleted_at IS NOT NULL", uid).Take(&User{}).Error
+		if gorm.IsRecordNotFoundError(err) {
+			return ErrNotInTrash
+		}
+		if err != nil {
+			return err
+		}
+		pids := []uuid.UUID{}
+		err = tx.Debug().Unscoped().Model(&Product{}).
+			Where("owner_id = ? AND deleted_at = (SELECT deleted_at FROM users WHERE id = ?)", uid, uid).
+			Pluck("id", &pids).Error
+		if err != nil {
+			return err
+		}
+		if len(pids) > 0 {
+			err = tx.Debug().Unscoped().Model(&Product{}).Where("id IN (?)", pids).UpdateColumn("deleted_at", nil).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, pid := range pids {
+			err = recordRevision(tx, pid, RevisionRestore, u.ActorID, nil, RevisionChanges{})
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Debug().Unscoped().Model(&User{}).Where("id = ?", uid).UpdateColumn("deleted_at", nil).Error
+	})
+	if err != nil {
+		return &User{}, err
+	}
+	return u.FindUserByID(db, uid)
+}
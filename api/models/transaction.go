@@ -0,0 +1,16 @@
+package models
+
+import (
+	"database/sql"
+
+	"github.com/jinzhu/gorm"
+)
+
+// transaction run fc in a transaction, or in the one db is already in, so model methods
+// that need a transaction can also be called from a bigger one such as a migration
+func transaction(db *gorm.DB, fc func(tx *gorm.DB) error) error {
+	if _, ok := db.CommonDB().(*sql.Tx); ok {
+		return fc(db)
+	}
+	return db.Transaction(fc)
+}
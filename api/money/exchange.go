@@ -0,0 +1,61 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+)
+
+// ErrNoExchangeRate there is no rate between the two currencies
+var ErrNoExchangeRate = errors.New("Exchange Rate Not Found")
+
+// ExchangeRateProvider gives the rates amounts are converted between currencies with
+type ExchangeRateProvider interface {
+	// Rate how many units of to one unit of from is worth, ErrNoExchangeRate when it is unknown
+	Rate(from, to string) (float64, error)
+}
+
+// Conversion an amount converted from another currency, with the rate it was converted at
+type Conversion struct {
+	Money Money
+	Rate  float64
+}
+
+// Convert the amount in the currency to, rounded half away from zero to its minor unit
+func Convert(m Money, to string, rates ExchangeRateProvider) (Conversion, error) {
+	to = strings.ToUpper(strings.TrimSpace(to))
+	toExponent, ok := currencies[to]
+	if !ok {
+		return Conversion{}, ErrUnknownCurrency
+	}
+	fromExponent, ok := currencies[m.Currency]
+	if !ok {
+		return Conversion{}, ErrUnknownCurrency
+	}
+	if m.Currency == to {
+		return Conversion{Money: m, Rate: 1}, nil
+	}
+	rate, err := rates.Rate(m.Currency, to)
+	if err != nil {
+		return Conversion{}, err
+	}
+	if rate <= 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return Conversion{}, ErrNoExchangeRate
+	}
+	minor := math.Round(float64(m.Amount) * rate * math.Pow10(toExponent-fromExponent))
+	if math.IsNaN(minor) || math.Abs(minor) > math.MaxInt64/2 {
+		return Conversion{}, ErrInvalidAmount
+	}
+	return Conversion{Money: Money{Amount: int64(minor), Currency: to}, Rate: rate}, nil
+}
+
+// MarshalJSON write {"amount": "52.50", "currency": "BRL", "rate": 5.25}
+func (c Conversion) MarshalJSON() ([]byte, error) {
+	amount, _ := json.Marshal(c.Money.Decimal())
+	return json.Marshal(struct {
+		Amount   json.RawMessage `json:"amount"`
+		Currency string          `json:"currency"`
+		Rate     float64         `json:"rate"`
+	}{amount, c.Money.Currency, c.Rate})
+}
@@ -0,0 +1,222 @@
+// Package money holds exact amounts of money: integer minor units (cents) and an ISO 4217
+// currency, parsed from and formatted to decimal strings without going through floats.
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnknownCurrency the code is not an ISO 4217 currency we know
+	ErrUnknownCurrency = errors.New("Unknown Currency")
+	// ErrInvalidAmount the amount is not a plain decimal number, or has more decimals than its currency
+	ErrInvalidAmount = errors.New("Invalid Amount")
+	// ErrNegativeAmount the amount is below zero
+	ErrNegativeAmount = errors.New("Negative Amount")
+)
+
+// currencies the number of decimals of the minor unit of each ISO 4217 currency we accept
+var currencies = map[string]int{
+	"AED": 2, "AOA": 2, "ARS": 2, "AUD": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2,
+	"CLP": 0, "CNY": 2, "COP": 2, "CVE": 2, "CZK": 2, "DKK": 2, "EGP": 2, "EUR": 2,
+	"GBP": 2, "GHS": 2, "HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2, "INR": 2, "ISK": 0,
+	"JOD": 3, "JPY": 0, "KES": 2, "KRW": 0, "KWD": 3, "MAD": 2, "MXN": 2, "MYR": 2,
+	"MZN": 2, "NGN": 2, "NOK": 2, "NZD": 2, "OMR": 3, "PHP": 2, "PLN": 2, "QAR": 2,
+	"RON": 2, "RUB": 2, "SAR": 2, "SEK": 2, "SGD": 2, "THB": 2, "TND": 3, "TRY": 2,
+	"TWD": 2, "UAH": 2, "USD": 2, "VND": 0, "XAF": 0, "XOF": 0, "ZAR": 2,
+}
+
+// Money an exact amount, in the minor unit of its currency: 1050 BRL is R$ 10,50
+type Money struct {
+	Amount   int64  `gorm:"not null;default:0"`
+	Currency string `gorm:"size:3;not null;default:''"`
+}
+
+// New the Money of an amount in minor units
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// Exponent the number of decimals of the currency
+func Exponent(currency string) (int, bool) {
+	exponent, ok := currencies[strings.ToUpper(currency)]
+	return exponent, ok
+}
+
+// ValidCurrency check the code is an ISO 4217 currency we know
+func ValidCurrency(currency string) bool {
+	_, ok := Exponent(currency)
+	return ok
+}
+
+// DefaultCurrency the currency of amounts saved before prices had one, DEFAULT_CURRENCY or USD
+func DefaultCurrency() string {
+	if currency := strings.ToUpper(strings.TrimSpace(os.Getenv("DEFAULT_CURRENCY"))); ValidCurrency(currency) {
+		return currency
+	}
+	return "USD"
+}
+
+// Parse read a decimal amount such as "10.5" or "1299" in the currency. Only digits, an
+// optional leading minus and a dot are allowed, with no more decimals than the currency has.
+func Parse(amount, currency string) (Money, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	exponent, ok := currencies[currency]
+	if !ok {
+		return Money{}, ErrUnknownCurrency
+	}
+
+	s := strings.TrimSpace(amount)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+		if fraction == "" {
+			return Money{}, ErrInvalidAmount
+		}
+	}
+	if whole == "" || !digits(whole) || !digits(fraction) || len(fraction) > exponent {
+		return Money{}, ErrInvalidAmount
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	minor, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+// MustParse Parse for amounts known to be right, it panics on errors
+func MustParse(amount, currency string) Money {
+	m, err := Parse(amount, currency)
+	if err != nil {
+		panic(fmt.Sprintf("money: cannot parse %q %s: %v", amount, currency, err))
+	}
+	return m
+}
+
+// FromFloat the Money nearest to a float amount, only for amounts saved as floats before
+func FromFloat(amount float64, currency string) (Money, error) {
+	currency = strings.ToUpper(currency)
+	exponent, ok := currencies[currency]
+	if !ok {
+		return Money{}, ErrUnknownCurrency
+	}
+	minor := math.Round(amount * math.Pow10(exponent))
+	if math.IsNaN(minor) || math.Abs(minor) > math.MaxInt64/2 {
+		return Money{}, ErrInvalidAmount
+	}
+	return Money{Amount: int64(minor), Currency: currency}, nil
+}
+
+// IsZero a Money without currency, an amount that was never set
+func (m Money) IsZero() bool {
+	return m.Currency == ""
+}
+
+// Decimal the amount as a decimal string with every decimal of the currency, e.g. "10.50"
+func (m Money) Decimal() string {
+	exponent := currencies[m.Currency]
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+	}
+	s := strconv.FormatUint(uint64(abs(amount)), 10)
+	if exponent == 0 {
+		return sign + s
+	}
+	if len(s) <= exponent {
+		s = strings.Repeat("0", exponent-len(s)+1) + s
+	}
+	return sign + s[:len(s)-exponent] + "." + s[len(s)-exponent:]
+}
+
+// String the amount and its currency, e.g. "10.50 BRL"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Validate check the currency is known and the amount is not negative
+func (m Money) Validate() error {
+	if !ValidCurrency(m.Currency) {
+		return ErrUnknownCurrency
+	}
+	if m.Amount < 0 {
+		return ErrNegativeAmount
+	}
+	return nil
+}
+
+// jsonMoney the JSON form of Money, the amount is a decimal string so no client reads it as a float
+type jsonMoney struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+// MarshalJSON write {"amount": "10.50", "currency": "BRL"}, or null for a Money never set
+func (m Money) MarshalJSON() ([]byte, error) {
+	if m.IsZero() {
+		return []byte("null"), nil
+	}
+	amount, _ := json.Marshal(m.Decimal())
+	return json.Marshal(jsonMoney{Amount: amount, Currency: m.Currency})
+}
+
+// UnmarshalJSON read {"amount": "10.50", "currency": "BRL"}. The amount may also be a JSON
+// number, it is read from its text, so 10.5 is exact; exponents such as 1e3 are refused.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		*m = Money{}
+		return nil
+	}
+	value := jsonMoney{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return errors.New(`Invalid Money, expected {"amount": "10.50", "currency": "BRL"}`)
+	}
+	amount := string(bytes.TrimSpace(value.Amount))
+	if strings.HasPrefix(amount, `"`) {
+		err = json.Unmarshal(value.Amount, &amount)
+		if err != nil {
+			return ErrInvalidAmount
+		}
+	}
+	if amount == "" || amount == "null" {
+		return ErrInvalidAmount
+	}
+	parsed, err := Parse(amount, value.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int64) uint64 {
+	if n < 0 {
+		return uint64(-(n + 1)) + 1
+	}
+	return uint64(n)
+}
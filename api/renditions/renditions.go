@@ -0,0 +1,283 @@
+package renditions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // decode GIF uploads
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/storage"
+)
+
+// Preset a size renditions are made in, the width in pixels they are scaled down to
+type Preset struct {
+	Name  string
+	Width int
+}
+
+// DefaultPresets the sizes used when IMAGE_RENDITION_SIZES is not set
+var DefaultPresets = []Preset{
+	{Name: "thumbnail", Width: 150},
+	{Name: "medium", Width: 600},
+	{Name: "large", Width: 1200},
+}
+
+var presetName = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+
+// ParsePresets read presets written as name=width, separated by commas, thumbnail=150,large=1200
+func ParsePresets(s string) ([]Preset, error) {
+	presets := []Preset{}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		pair := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("Invalid image rendition size: %s", part)
+		}
+		name := strings.ToLower(strings.TrimSpace(pair[0]))
+		width, err := strconv.Atoi(strings.TrimSpace(pair[1]))
+		if !presetName.MatchString(name) || err != nil || width < 1 || width > 10000 || seen[name] {
+			return nil, fmt.Errorf("Invalid image rendition size: %s", part)
+		}
+		seen[name] = true
+		presets = append(presets, Preset{Name: name, Width: width})
+	}
+	return presets, nil
+}
+
+// Encoder write the image in a format
+type Encoder func(w io.Writer, img image.Image) error
+
+type format struct {
+	contentType string
+	ext         string
+	encode      Encoder
+}
+
+var (
+	jpegFormat = format{"image/jpeg", ".jpg", func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	}}
+	pngFormat   = format{"image/png", ".png", png.Encode}
+	webpEncoder Encoder
+)
+
+// SetWebPEncoder give the pipeline a WebP encoder, the standard library has none. WebP renditions
+// are made next to the others once one is set and IMAGE_RENDITION_WEBP is true.
+func SetWebPEncoder(encode Encoder) {
+	webpEncoder = encode
+}
+
+// pendingBatch how many pending images are read at a time
+const pendingBatch = 10
+
+// Pipeline makes the renditions of the uploaded product images in the background. The images
+// waiting for theirs are the ones whose status is pending, so the work left when the API stops
+// is picked up when it starts again.
+type Pipeline struct {
+	DB      *gorm.DB
+	Blobs   storage.BlobStore
+	Presets []Preset
+	// WebP make a WebP rendition next to the JPEG or PNG one, it needs SetWebPEncoder
+	WebP bool
+
+	wake chan struct{}
+}
+
+// NewPipeline create a Pipeline, it works once started
+func NewPipeline(db *gorm.DB, blobs storage.BlobStore, presets []Preset) *Pipeline {
+	return &Pipeline{DB: db, Blobs: blobs, Presets: presets, wake: make(chan struct{}, 1)}
+}
+
+// FromEnv the Pipeline configured by IMAGE_RENDITION_SIZES and IMAGE_RENDITION_WEBP
+func FromEnv(db *gorm.DB, blobs storage.BlobStore) (*Pipeline, error) {
+	presets := DefaultPresets
+	if sizes := os.Getenv("IMAGE_RENDITION_SIZES"); sizes != "" {
+		var err error
+		presets, err = ParsePresets(sizes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pipeline := NewPipeline(db, blobs, presets)
+	if os.Getenv("IMAGE_RENDITION_WEBP") == "true" {
+		if webpEncoder == nil {
+			return nil, errors.New("WebP renditions need an encoder, none is set")
+		}
+		pipeline.WebP = true
+	}
+	return pipeline, nil
+}
+
+// Start process the pending images when notified, and every interval for the ones queued by
+// another instance
+func (p *Pipeline) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			count, err := p.ProcessPending()
+			if err != nil {
+				log.Printf("cannot make the image renditions: %v", err)
+			}
+			if count > 0 {
+				log.Printf("made the renditions of %d images", count)
+			}
+			select {
+			case <-p.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Notify tell the pipeline images are pending, it never waits
+func (p *Pipeline) Notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ProcessPending make the renditions of the pending images until none is left, it returns how
+// many images were processed
+func (p *Pipeline) ProcessPending() (int, error) {
+	processed := 0
+	for {
+		productImage := models.ProductImage{}
+		images, err := productImage.FindPendingImages(p.DB, pendingBatch)
+		if err != nil {
+			return processed, err
+		}
+		if len(*images) == 0 {
+			return processed, nil
+		}
+		for i := range *images {
+			err = p.Process(&(*images)[i])
+			if err != nil {
+				return processed, err
+			}
+			processed++
+		}
+	}
+}
+
+// Process make the renditions of the image for the current presets and drop its previous ones.
+// An image that cannot be resized is marked failed, the error is only for the database.
+func (p *Pipeline) Process(productImage *models.ProductImage) error {
+	renditions, status := p.render(productImage)
+	replaced, err := productImage.ReplaceRenditions(p.DB, renditions, status)
+	if err == models.ErrImageNotFound {
+		p.deleteBlobs(renditions, nil)
+		return nil
+	}
+	// Queued again meanwhile, it is still pending and is processed again. The renditions it
+	// keeps may share keys with the ones just made.
+	if err == models.ErrRenditionsStale {
+		p.deleteBlobs(renditions, replaced)
+		p.Notify()
+		return nil
+	}
+	if err != nil {
+		p.deleteBlobs(renditions, nil)
+		return err
+	}
+	p.deleteBlobs(replaced, renditions)
+	return nil
+}
+
+// render resize the image for every preset and put the renditions in the blob store
+func (p *Pipeline) render(productImage *models.ProductImage) ([]models.ImageRendition, string) {
+	// The standard library cannot decode WebP
+	if productImage.ContentType == "image/webp" {
+		return nil, models.RenditionsUnsupported
+	}
+	data, err := p.Blobs.Get(productImage.Key)
+	if err != nil {
+		log.Printf("cannot read the image %s: %v", productImage.ID, err)
+		return nil, models.RenditionsFailed
+	}
+	// Images uploaded before the pixel cap may still be too big to decode
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil && TooManyPixels(config.Width, config.Height) {
+		log.Printf("cannot decode the image %s: %dx%d is more than %d pixels", productImage.ID, config.Width, config.Height, MaxPixels)
+		return nil, models.RenditionsFailed
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("cannot decode the image %s: %v", productImage.ID, err)
+		return nil, models.RenditionsFailed
+	}
+	// Converted once, every preset is resized from the same pixels
+	src := Premultiplied(decoded)
+
+	// PNG and GIF uploads keep their transparency
+	formats := []format{jpegFormat}
+	if productImage.ContentType != "image/jpeg" {
+		formats = []format{pngFormat}
+	}
+	if p.WebP && webpEncoder != nil {
+		formats = append(formats, format{"image/webp", ".webp", webpEncoder})
+	}
+
+	// The width is part of the key, so a preset resized never reuses a URL clients have cached
+	base := strings.TrimSuffix(productImage.Key, path.Ext(productImage.Key))
+	made := []models.ImageRendition{}
+	for _, preset := range p.Presets {
+		resized := Resize(src, preset.Width)
+		width, height := resized.Bounds().Dx(), resized.Bounds().Dy()
+		for _, f := range formats {
+			buf := bytes.Buffer{}
+			err = f.encode(&buf, resized)
+			if err == nil {
+				key := fmt.Sprintf("%s/%s-%d%s", base, preset.Name, width, f.ext)
+				err = p.Blobs.Put(key, buf.Bytes(), f.contentType)
+				made = append(made, models.ImageRendition{
+					Preset:      preset.Name,
+					ContentType: f.contentType,
+					Key:         key,
+					URL:         p.Blobs.URL(key),
+					Width:       width,
+					Height:      height,
+					Size:        int64(buf.Len()),
+				})
+			}
+			if err != nil {
+				log.Printf("cannot make the %s rendition of the image %s: %v", preset.Name, productImage.ID, err)
+				p.deleteBlobs(made, nil)
+				return nil, models.RenditionsFailed
+			}
+		}
+	}
+	return made, models.RenditionsReady
+}
+
+// deleteBlobs delete the blobs of the renditions, except the ones kept under the same key
+func (p *Pipeline) deleteBlobs(renditions []models.ImageRendition, kept []models.ImageRendition) {
+	keep := map[string]bool{}
+	for _, rendition := range kept {
+		keep[rendition.Key] = true
+	}
+	for _, rendition := range renditions {
+		if keep[rendition.Key] {
+			continue
+		}
+		err := p.Blobs.Delete(rendition.Key)
+		if err != nil {
+			log.Printf("cannot delete the blob %s: %v", rendition.Key, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package renditions
+
+import (
+	"image"
+	"image/draw"
+)
+
+// MaxPixels the most pixels an image may have. Decoding keeps every pixel in memory, so a
+// small file declaring huge dimensions could exhaust it.
+const MaxPixels = 40 * 1000 * 1000
+
+// TooManyPixels whether an image of the size has more than MaxPixels
+func TooManyPixels(width, height int) bool {
+	return int64(width)*int64(height) > MaxPixels
+}
+
+// Premultiplied the pixels of the image as premultiplied RGBA, so transparent ones do not darken
+// an average. An *image.RGBA is returned as it is, anything else is copied once.
+func Premultiplied(src image.Image) *image.RGBA {
+	if pixels, ok := src.(*image.RGBA); ok {
+		return pixels
+	}
+	bounds := src.Bounds()
+	pixels := image.NewRGBA(bounds)
+	draw.Draw(pixels, bounds, src, bounds.Min, draw.Src)
+	return pixels
+}
+
+// Resize scale the image down to width, keeping its aspect ratio. Each pixel is the average of the
+// pixels it covers, which keeps thin lines and text readable. Images no wider than width are
+// returned as they are. Callers resizing one image to several widths convert it with Premultiplied
+// first, so it is not copied for each of them.
+func Resize(src image.Image, width int) *image.RGBA {
+	pixels := Premultiplied(src)
+	bounds := pixels.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	if width <= 0 || width >= sw {
+		return pixels
+	}
+	height := sh * width / sw
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for dy := 0; dy < height; dy++ {
+		y0, y1 := span(dy, height, sh)
+		for dx := 0; dx < width; dx++ {
+			x0, x1 := span(dx, width, sw)
+			var sum [4]uint64
+			for y := y0; y < y1; y++ {
+				start := pixels.PixOffset(bounds.Min.X+x0, bounds.Min.Y+y)
+				row := pixels.Pix[start : start+(x1-x0)*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += uint64(row[i])
+					sum[1] += uint64(row[i+1])
+					sum[2] += uint64(row[i+2])
+					sum[3] += uint64(row[i+3])
+				}
+			}
+			count := uint64((x1 - x0) * (y1 - y0))
+			offset := dy*dst.Stride + dx*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8((sum[c] + count/2) / count)
+			}
+		}
+	}
+	return dst
+}
+
+// span the source pixels, [from, to), covered by pixel i of n once scaled from size
+func span(i, n, size int) (int, int) {
+	from, to := i*size/n, (i+1)*size/n
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
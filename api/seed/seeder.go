@@ -3,66 +3,80 @@ package seed
 import (
 	"log"
 	"github.com/google/uuid"
+	"github.com/arikardnoir/asiwaju/api/migrations"
 	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
 	"github.com/jinzhu/gorm"
 )
 
 var users = []models.User{
 	models.User{
-		ID:          uuid.Must(uuid.NewRandom()),
-		Fullname: "Eloisa da Silva",
-		Nickname: "elo.silva",
-		Email:    "eloisa@gmail.com",
-		Password: "password",
+		ID:            uuid.Must(uuid.NewRandom()),
+		Fullname:      "Eloisa da Silva",
+		Nickname:      "elo.silva",
+		Email:         "eloisa@gmail.com",
+		Password:      "password",
+		Role:          models.RoleAdmin,
+		EmailVerified: true,
 	},
 	models.User{
-		ID:          uuid.Must(uuid.NewRandom()),
-		Fullname: "Adriel Van-dunem",
-		Nickname: "adri.van",
-		Email:    "adriel@gmail.com",
-		Password: "password",
+		ID:            uuid.Must(uuid.NewRandom()),
+		Fullname:      "Adriel Van-dunem",
+		Nickname:      "adri.van",
+		Email:         "adriel@gmail.com",
+		Password:      "password",
+		EmailVerified: true,
 	},
 }
 
 var products = []models.Product{
 	models.Product{
 		ID:          uuid.Must(uuid.NewRandom()),
-		Name:   "AIR JORDAN 1 RETRO LOW OG EX",
-		Brand: "Nike",
-		Image:   "https://www.nike.com.br/air-jordan-1-retro-low-og-ex-023577.html?cor=ID#pid1",
-		Size:   "38;39;40;41;42",
-		Model:   "Air Jordan 1",
-		Price:   90.000,
+		Name:        "AIR JORDAN 1 RETRO LOW OG EX",
+		Brand:       "Nike",
+		Image:       "https://www.nike.com.br/air-jordan-1-retro-low-og-ex-023577.html?cor=ID#pid1",
+		Size:        "38;39;40;41;42",
+		Model:       "Air Jordan 1",
+		Price:       money.MustParse("90.00", "BRL"),
 		Description: "Chame-o de obra-prima inacabada. Esta versão trabalhada do AJ1 Low tem tudo a ver com bordas expostas e desgastadas, trazendo uma estética desconstruída para seu têni favorito.",
+		Public:      true,
 	},
 	models.Product{
 		ID:          uuid.Must(uuid.NewRandom()),
-		Name:   "Gomes Da Costa Atum Sólido em Óleo Delivery",
-		Brand:  "Gomes Da Costa",
-		Image:  "https://images.rappi.com.br/products/630151d9-9e33-460a-bed0-4cc527424a74.jpg?d=128x128&e=webp&q=70",
-		Size:   "",
-		Model:  "",
-		Price:  5.000,
+		Name:        "Gomes Da Costa Atum Sólido em Óleo Delivery",
+		Brand:       "Gomes Da Costa",
+		Image:       "https://images.rappi.com.br/products/630151d9-9e33-460a-bed0-4cc527424a74.jpg?d=128x128&e=webp&q=70",
+		Size:        "",
+		Model:       "",
+		Price:       money.MustParse("5.00", "BRL"),
 		Description: "Produzido com o lombo do atum, a parte mais nobre do peixe, e por isso é muito valorizado pela sua qualidade e sabor diferenciado.",
+		Public:      true,
 	},
 }
 
 func Load(db *gorm.DB) {
 
-	err := db.Debug().DropTableIfExists(&models.Product{}, &models.User{}).Error
+	err := db.Debug().DropTableIfExists("schema_migrations", "product_tags", &models.Tag{}, &models.ProductVariant{}, &models.StockMovement{}, &models.StockReservation{}, &models.ExchangeRate{}, &models.ProductImage{}, &models.ImageRendition{}, &models.ImportJob{}, &models.ProductRevision{}, &models.PriceChange{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.TokenCutoff{}, &models.APIKey{}, &models.OneTimeToken{}, &models.RecoveryCode{}, &models.LoginAttempt{}, &models.Product{}, &models.Category{}, &models.User{}).Error
 	if err != nil {
 		log.Fatalf("cannot drop table: %v", err)
 	}
-	err = db.Debug().AutoMigrate(&models.User{}, &models.Product{}).Error
+	err = db.Debug().AutoMigrate(&models.User{}, &models.Product{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.TokenCutoff{}, &models.APIKey{}, &models.OneTimeToken{}, &models.RecoveryCode{}, &models.LoginAttempt{}, &models.Category{}, &models.Tag{}, &models.ProductVariant{}, &models.StockMovement{}, &models.StockReservation{}, &models.ExchangeRate{}, &models.ProductImage{}, &models.ImageRendition{}, &models.ImportJob{}, &models.ProductRevision{}, &models.PriceChange{}).Error
 	if err != nil {
 		log.Fatalf("cannot migrate table: %v", err)
 	}
 
-	err = db.Debug().Model(&models.Product{}).AddForeignKey("owner_id", "users(id)", "cascade", "cascade").Error
+	// Users are deleted for good only by PurgeTrash, after their products, a cascade would skip
+	// the variants, movements, images and price history of the products
+	err = db.Debug().Model(&models.Product{}).AddForeignKey("owner_id", "users(id)", "restrict", "cascade").Error
 	if err != nil {
 		log.Fatalf("attaching foreign key error: %v", err)
 	}
 
+	err = models.MigrateProductSearch(db)
+	if err != nil {
+		log.Fatalf("cannot prepare the product search: %v", err)
+	}
+
 	for i := range users {
 		err = db.Debug().Model(&models.User{}).Create(&users[i]).Error
 		if err != nil {
@@ -75,4 +89,10 @@ func Load(db *gorm.DB) {
 			log.Fatalf("cannot seed products table: %v", err)
 		}
 	}
-}
\ No newline at end of file
+
+	// The seed sizes become variants, like the sizes of older databases
+	err = migrations.Run(db)
+	if err != nil {
+		log.Fatalf("cannot migrate the data: %v", err)
+	}
+}
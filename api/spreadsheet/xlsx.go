@@ -0,0 +1,165 @@
+// Package spreadsheet writes XLSX workbooks of one sheet, row by row, without holding them in memory.
+package spreadsheet
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The parts of the workbook around its sheet, which is written last as the rows come
+var workbookParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	{"xl/styles.xml", xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="1"><fill><patternFill patternType="none"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/></cellXfs>` +
+		`</styleSheet>`},
+}
+
+// XLSXWriter writes a workbook of one sheet. Cells are strings, numbers (ints, floats and
+// json.Number) or bools, anything else is written as its fmt string.
+type XLSXWriter struct {
+	archive *zip.Writer
+	sheet   *bufio.Writer
+	row     int
+}
+
+// NewXLSXWriter start a workbook whose sheet has the name
+func NewXLSXWriter(w io.Writer, name string) (*XLSXWriter, error) {
+	archive := zip.NewWriter(w)
+	for _, part := range workbookParts {
+		f, err := archive.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(f, part.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	f, err := archive.Create("xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(f, xml.Header+`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" `+
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`+
+		`<sheet name="`+escape(name)+`" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err = archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	sheet := bufio.NewWriter(f)
+	_, err = sheet.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return nil, err
+	}
+	return &XLSXWriter{archive: archive, sheet: sheet}, nil
+}
+
+// WriteRow add a row under the previous ones
+func (x *XLSXWriter) WriteRow(cells []interface{}) error {
+	x.row++
+	_, err := fmt.Fprintf(x.sheet, `<row r="%d">`, x.row)
+	if err != nil {
+		return err
+	}
+	for i, value := range cells {
+		ref := ColumnName(i) + strconv.Itoa(x.row)
+		var cell string
+		switch v := value.(type) {
+		case string:
+			if v == "" {
+				continue
+			}
+			cell = `<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">` + escape(v) + `</t></is></c>`
+		case bool:
+			b := "0"
+			if v {
+				b = "1"
+			}
+			cell = `<c r="` + ref + `" t="b"><v>` + b + `</v></c>`
+		case int:
+			cell = `<c r="` + ref + `"><v>` + strconv.Itoa(v) + `</v></c>`
+		case int64:
+			cell = `<c r="` + ref + `"><v>` + strconv.FormatInt(v, 10) + `</v></c>`
+		case float64:
+			cell = `<c r="` + ref + `"><v>` + strconv.FormatFloat(v, 'f', -1, 64) + `</v></c>`
+		case json.Number:
+			cell = `<c r="` + ref + `"><v>` + escape(string(v)) + `</v></c>`
+		default:
+			cell = `<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">` + escape(fmt.Sprint(v)) + `</t></is></c>`
+		}
+		_, err = x.sheet.WriteString(cell)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = x.sheet.WriteString(`</row>`)
+	return err
+}
+
+// Flush write the buffered rows to the underlying writer
+func (x *XLSXWriter) Flush() error {
+	err := x.sheet.Flush()
+	if err != nil {
+		return err
+	}
+	return x.archive.Flush()
+}
+
+// Close end the sheet and the workbook, it does not close the underlying writer
+func (x *XLSXWriter) Close() error {
+	_, err := x.sheet.WriteString(`</sheetData></worksheet>`)
+	if err != nil {
+		return err
+	}
+	err = x.sheet.Flush()
+	if err != nil {
+		return err
+	}
+	return x.archive.Close()
+}
+
+// ColumnName the letters of the column at index i, A for 0, Z for 25, AA for 26
+func ColumnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// escape the text for XML, characters XML cannot hold become U+FFFD
+func escape(s string) string {
+	b := strings.Builder{}
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MediaPath the path the API serves the blobs of a LocalStore under
+const MediaPath = "/media"
+
+// LocalStore a BlobStore in a directory of the local disk, for a single instance of the API.
+// It serves its blobs itself, under MediaPath.
+type LocalStore struct {
+	Dir     string
+	BaseURL string
+}
+
+// NewLocalStore create a LocalStore, and its directory when it does not exist
+func NewLocalStore(dir, baseURL string) (*LocalStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalStore{Dir: dir, BaseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+// Put implements BlobStore, the file is replaced at once so a reader never sees half of it
+func (s *LocalStore) Put(key string, data []byte, contentType string) error {
+	if !validKey(key) {
+		return errors.New("Invalid Blob Key")
+	}
+	file := s.path(key)
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".upload-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// Get implements BlobStore
+func (s *LocalStore) Get(key string) ([]byte, error) {
+	if !validKey(key) {
+		return nil, ErrNotFound
+	}
+	data, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Delete implements BlobStore
+func (s *LocalStore) Delete(key string) error {
+	if !validKey(key) {
+		return nil
+	}
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// URL implements BlobStore
+func (s *LocalStore) URL(key string) string {
+	return s.BaseURL + "/" + key
+}
+
+// ServeHTTP serve the blob of the path, relative to MediaPath. Directories are not listed.
+func (s *LocalStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(r.URL.Path, MediaPath)), "/")
+	data, err := s.Get(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(data))
+}
+
+func (s *LocalStore) path(key string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(key))
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Config the connection to an S3-compatible object store, AWS or a stand-in such as MinIO
+type S3Config struct {
+	// Endpoint the URL of the store, https://s3.<region>.amazonaws.com for AWS
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle address the bucket in the path, endpoint/bucket/key, instead of the host
+	PathStyle bool
+	// PublicURL the URL clients download the blobs from, a CDN for example. The bucket URL by default.
+	PublicURL string
+}
+
+// S3Store a BlobStore in a bucket of an S3-compatible object store, requests are signed with
+// AWS Signature Version 4
+type S3Store struct {
+	config   S3Config
+	endpoint *url.URL
+	Client   *http.Client
+}
+
+// NewS3Store create an S3Store
+func NewS3Store(config S3Config) (*S3Store, error) {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	}
+	if config.Bucket == "" || config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, errors.New("S3 needs a bucket, an access key id and a secret access key")
+	}
+	endpoint, err := url.Parse(strings.TrimRight(config.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("Invalid S3 endpoint: %s", config.Endpoint)
+	}
+	return &S3Store{config: config, endpoint: endpoint, Client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Put implements BlobStore
+func (s *S3Store) Put(key string, data []byte, contentType string) error {
+	if !validKey(key) {
+		return errors.New("Invalid Blob Key")
+	}
+	res, err := s.do("PUT", key, data, map[string]string{"Content-Type": contentType})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return s.failure("PUT", key, res)
+	}
+	return nil
+}
+
+// Get implements BlobStore
+func (s *S3Store) Get(key string) ([]byte, error) {
+	if !validKey(key) {
+		return nil, ErrNotFound
+	}
+	res, err := s.do("GET", key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, s.failure("GET", key, res)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// Delete implements BlobStore
+func (s *S3Store) Delete(key string) error {
+	if !validKey(key) {
+		return nil
+	}
+	res, err := s.do("DELETE", key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return s.failure("DELETE", key, res)
+	}
+	return nil
+}
+
+// URL implements BlobStore
+func (s *S3Store) URL(key string) string {
+	if s.config.PublicURL != "" {
+		return strings.TrimRight(s.config.PublicURL, "/") + "/" + escapeKey(key)
+	}
+	return s.objectURL(key).String()
+}
+
+// objectURL the URL of the object in the bucket
+func (s *S3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	if s.config.PathStyle {
+		u.Path = u.Path + "/" + s.config.Bucket + "/" + key
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = u.Path + "/" + key
+	}
+	u.RawPath = escapeKey(u.Path)
+	return &u
+}
+
+func (s *S3Store) do(method, key string, body []byte, headers map[string]string) (*http.Response, error) {
+	target := s.objectURL(key)
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	s.sign(req, body, time.Now().UTC())
+	return s.Client.Do(req)
+}
+
+// sign add the AWS Signature Version 4 of the request, over the host, the x-amz-* headers
+// and the content type
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		headers["content-type"] = contentType
+		names = append([]string{"content-type"}, names...)
+	}
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + strings.TrimSpace(headers[name]) + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapeKey(req.URL.Path),
+		req.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretAccessKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func (s *S3Store) failure(method, key string, res *http.Response) error {
+	body, _ := ioutil.ReadAll(res.Body)
+	if len(body) > 500 {
+		body = body[:500]
+	}
+	return fmt.Errorf("S3 %s %s failed with %s: %s", method, key, res.Status, strings.TrimSpace(string(body)))
+}
+
+// escapeKey percent-encode every path segment the way S3 signs it, keeping the slashes
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = strings.Replace(url.QueryEscape(part), "+", "%20", -1)
+	}
+	return strings.Join(parts, "/")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,66 @@
+// Package storage keeps uploaded files, such as product images, on the local disk or in an
+// S3-compatible object store.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrNotFound there is no blob with the key
+var ErrNotFound = errors.New("Blob Not Found")
+
+// BlobStore keeps blobs by key, keys are slash separated paths such as products/<id>/<image>.jpg
+type BlobStore interface {
+	// Put save the blob, replacing the one with the same key
+	Put(key string, data []byte, contentType string) error
+	// Get read the blob, ErrNotFound when there is none
+	Get(key string) ([]byte, error)
+	// Delete remove the blob, removing a missing blob is not an error
+	Delete(key string) error
+	// URL where clients download the blob from
+	URL(key string) string
+}
+
+// FromEnv the BlobStore configured by BLOB_STORE (local or s3) and the BLOB_* and S3_* variables
+func FromEnv() (BlobStore, error) {
+	switch strings.ToLower(os.Getenv("BLOB_STORE")) {
+	case "", "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		baseURL := os.Getenv("BLOB_BASE_URL")
+		if baseURL == "" {
+			baseURL = strings.TrimRight(os.Getenv("APP_URL"), "/") + MediaPath
+		}
+		return NewLocalStore(dir, baseURL)
+	case "s3":
+		return NewS3Store(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle:       os.Getenv("S3_PATH_STYLE") == "true",
+			PublicURL:       os.Getenv("S3_PUBLIC_URL"),
+		})
+	default:
+		return nil, fmt.Errorf("Unknown blob store: %s", os.Getenv("BLOB_STORE"))
+	}
+}
+
+// validKey refuse keys that could leave the store, or that are empty
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
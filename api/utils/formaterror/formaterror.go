@@ -16,8 +16,21 @@ func FormatError(err string) error {
 		return errors.New("Email Already Taken")
 	}
 
-	if strings.Contains(err, "hashedPassword") {
-		return errors.New("Incorrect Password")
+	if strings.Contains(err, "sku") {
+		return errors.New("SKU Already Taken")
 	}
+
+	if strings.Contains(err, "external_id") {
+		return errors.New("External ID Already Taken")
+	}
+
+	if strings.Contains(err, "variant_attributes") {
+		return errors.New("Variant Already Exists")
+	}
+
+	if strings.Contains(err, "slug") {
+		return errors.New("Slug Already Taken")
+	}
+
 	return errors.New("Incorrect Details")
 }
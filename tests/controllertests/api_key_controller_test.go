@@ -0,0 +1,160 @@
+package controllertests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/middlewares"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestAPIKeys(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	users, _, err := seedUsersAndProducts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth.SetAPIKeyStore(auth.NewDBAPIKeyStore(server.DB))
+
+	token, _, err := server.SignIn(users[0].Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token)
+
+	createKey := func(inputJSON string) (int, map[string]interface{}) {
+		req, err := http.NewRequest("POST", "/users/api-keys", bytes.NewBufferString(inputJSON))
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": users[0].ID.String()})
+		req.Header.Set("Authorization", tokenString)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.CreateAPIKey).ServeHTTP(rr, req)
+		responseMap := make(map[string]interface{})
+		err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
+		if err != nil {
+			t.Errorf("Cannot convert to json: %v", err)
+		}
+		return rr.Code, responseMap
+	}
+
+	code, responseMap := createKey(`{"name": "", "scopes": ["products:read"]}`)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.Equal(t, responseMap["error"], "Required Name")
+
+	code, responseMap = createKey(`{"name": "ci", "scopes": ["users:delete"]}`)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.Equal(t, responseMap["error"], "Invalid Scope")
+
+	code, responseMap = createKey(`{"name": "ci", "scopes": ["products:read"]}`)
+	assert.Equal(t, code, http.StatusCreated)
+	readKey := fmt.Sprintf("%v", responseMap["key"])
+
+	// A key created without scopes only gets the default ones
+	code, responseMap = createKey(`{"name": "default"}`)
+	assert.Equal(t, code, http.StatusCreated)
+	defaultKey := fmt.Sprintf("%v", responseMap["key"])
+	assert.Equal(t, responseMap["data"].(map[string]interface{})["scopes"], []interface{}{models.ScopeProductsRead})
+
+	samples := []struct {
+		handler    http.HandlerFunc
+		method     string
+		key        string
+		statusCode int
+	}{
+		{
+			handler:    middlewares.RequireScope(models.ScopeProductsRead)(server.GetProducts),
+			method:     "GET",
+			key:        readKey,
+			statusCode: http.StatusOK,
+		},
+		{
+			// The key was not given the write scope
+			handler:    middlewares.RequireScope(models.ScopeProductsWrite)(server.CreateProduct),
+			method:     "POST",
+			key:        readKey,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			handler:    middlewares.RequireScope(models.ScopeProductsRead)(server.GetProducts),
+			method:     "GET",
+			key:        readKey + "tampered",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			handler:    middlewares.RequireScope(models.ScopeProductsWrite)(server.CreateProduct),
+			method:     "POST",
+			key:        defaultKey,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			// API keys cannot manage the account
+			handler:    middlewares.RequireAccessToken(server.UpdateUser),
+			method:     "PUT",
+			key:        defaultKey,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			handler:    middlewares.RequireRole(models.RoleUser, models.RoleAdmin)(server.GetUsers),
+			method:     "GET",
+			key:        defaultKey,
+			statusCode: http.StatusForbidden,
+		},
+	}
+	for _, v := range samples {
+		req, err := http.NewRequest(v.method, "/products", bytes.NewBufferString(`{}`))
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		req.Header.Set("X-API-Key", v.key)
+		rr := httptest.NewRecorder()
+		v.handler.ServeHTTP(rr, req)
+		assert.Equal(t, rr.Code, v.statusCode)
+	}
+
+	// A revoked key stops working
+	apiKey := models.APIKey{}
+	keys, err := apiKey.FindUserAPIKeys(server.DB, users[0].ID)
+	if err != nil {
+		log.Fatalf("cannot find keys: %v\n", err)
+	}
+	assert.Equal(t, len(*keys), 2)
+	readKeyID := ""
+	for _, k := range *keys {
+		if k.Name == "ci" {
+			readKeyID = k.ID.String()
+		}
+	}
+
+	req, err := http.NewRequest("DELETE", "/users/api-keys", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": users[0].ID.String(), "keyID": readKeyID})
+	req.Header.Set("Authorization", tokenString)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.RevokeAPIKey).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+
+	req, err = http.NewRequest("GET", "/products", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	req.Header.Set("X-API-Key", readKey)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(server.GetProducts).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
@@ -0,0 +1,95 @@
+package controllertests
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestCatalog(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, products, err := seedUsersAndProducts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = server.DB.Model(&products[0]).UpdateColumn("public", true).Error
+	if err != nil {
+		log.Fatalf("cannot publish the product: %v", err)
+	}
+	variant := models.ProductVariant{
+		ID:         uuid.Must(uuid.NewRandom()),
+		ProductID:  products[0].ID,
+		Attributes: models.VariantAttributes{"size": "42"},
+		Stock:      3,
+	}
+	variant.Prepare()
+	_, err = variant.SaveVariant(server.DB)
+	if err != nil {
+		log.Fatalf("cannot seed the variant: %v", err)
+	}
+
+	// No token is needed
+	req, err := http.NewRequest("GET", "/catalog", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.GetOpenProducts).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	page := struct {
+		Data  []map[string]interface{} `json:"data"`
+		Total int                      `json:"total"`
+	}{}
+	err = json.Unmarshal(rr.Body.Bytes(), &page)
+	if err != nil {
+		t.Errorf("Cannot convert to json: %v", err)
+	}
+	assert.Equal(t, page.Total, 1)
+	assert.Equal(t, page.Data[0]["id"], products[0].ID.String())
+	// The catalog does not tell who owns the product
+	_, hasOwner := page.Data[0]["owner_id"]
+	assert.Equal(t, hasOwner, false)
+	// Nor the bookkeeping of its variants
+	variants := page.Data[0]["variants"].([]interface{})
+	assert.Equal(t, len(variants), 1)
+	fields := []string{}
+	for name := range variants[0].(map[string]interface{}) {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	assert.Equal(t, fields, []string{"attributes", "available", "id", "price"})
+	assert.Equal(t, variants[0].(map[string]interface{})["available"], 3.0)
+
+	samples := []struct {
+		id         string
+		statusCode int
+	}{
+		{id: products[0].ID.String(), statusCode: http.StatusOK},
+		// Private products are not in the catalog
+		{id: products[1].ID.String(), statusCode: http.StatusNotFound},
+		{id: "unknown", statusCode: http.StatusBadRequest},
+	}
+	for _, v := range samples {
+		req, err := http.NewRequest("GET", "/catalog", nil)
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": v.id})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.GetOpenProduct).ServeHTTP(rr, req)
+		assert.Equal(t, rr.Code, v.statusCode)
+	}
+}
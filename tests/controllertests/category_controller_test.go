@@ -0,0 +1,180 @@
+package controllertests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestCategoriesAndTags(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	users, products, err := seedUsersAndProducts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _, err := server.SignIn(users[0].Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token)
+
+	createCategory := func(name string, parentID *uuid.UUID) map[string]interface{} {
+		body := map[string]interface{}{"name": name}
+		if parentID != nil {
+			body["parent_id"] = parentID.String()
+		}
+		data, _ := json.Marshal(body)
+		req, err := http.NewRequest("POST", "/categories", bytes.NewBuffer(data))
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.CreateCategory).ServeHTTP(rr, req)
+		assert.Equal(t, rr.Code, http.StatusCreated)
+		category := map[string]interface{}{}
+		err = json.Unmarshal(rr.Body.Bytes(), &category)
+		if err != nil {
+			t.Errorf("Cannot convert to json: %v", err)
+		}
+		return category
+	}
+
+	food := createCategory("Alimentação", nil)
+	assert.Equal(t, food["slug"], "alimentacao")
+	foodID := uuid.MustParse(food["id"].(string))
+	fast := createCategory("Fast Food", &foodID)
+	assert.Equal(t, fast["depth"], float64(1))
+	fastID := uuid.MustParse(fast["id"].(string))
+
+	tag := models.Tag{ID: uuid.Must(uuid.NewRandom()), Name: "Promoção"}
+	tag.Prepare()
+	_, err = tag.SaveTag(server.DB)
+	if err != nil {
+		log.Fatalf("cannot seed the tag: %v", err)
+	}
+
+	// The first product goes in the subcategory with the tag, both are public
+	for i := range products {
+		err = server.DB.Model(&products[i]).UpdateColumn("public", true).Error
+		if err != nil {
+			log.Fatalf("cannot publish the product: %v", err)
+		}
+	}
+	err = server.DB.Model(&products[0]).UpdateColumn("category_id", fastID).Error
+	if err != nil {
+		log.Fatalf("cannot categorize the product: %v", err)
+	}
+	err = products[0].ReplaceProductTags(server.DB, []models.Tag{tag})
+	if err != nil {
+		log.Fatalf("cannot tag the product: %v", err)
+	}
+
+	// The category shows its breadcrumb and children
+	req, err := http.NewRequest("GET", "/categories", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": fastID.String()})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.GetCategory).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	category := struct {
+		Breadcrumb []models.CategoryCrumb `json:"breadcrumb"`
+		Children   []models.Category      `json:"children"`
+	}{}
+	err = json.Unmarshal(rr.Body.Bytes(), &category)
+	if err != nil {
+		t.Errorf("Cannot convert to json: %v", err)
+	}
+	assert.Equal(t, len(category.Breadcrumb), 2)
+	assert.Equal(t, category.Breadcrumb[0].ID, foodID)
+	assert.Equal(t, len(category.Children), 0)
+
+	samples := []struct {
+		query string
+		total int
+	}{
+		// The parent category takes in the products of its subcategories
+		{query: "category=" + foodID.String(), total: 1},
+		{query: "category=" + fastID.String(), total: 1},
+		{query: "tag=promocao", total: 1},
+		{query: "tag=unknown", total: 0},
+		{query: "", total: 2},
+	}
+	for _, v := range samples {
+		req, err := http.NewRequest("GET", "/catalog?"+v.query, nil)
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.GetOpenProducts).ServeHTTP(rr, req)
+		assert.Equal(t, rr.Code, http.StatusOK)
+
+		page := struct {
+			Data  []models.CatalogProduct `json:"data"`
+			Total int                     `json:"total"`
+		}{}
+		err = json.Unmarshal(rr.Body.Bytes(), &page)
+		if err != nil {
+			t.Errorf("Cannot convert to json: %v", err)
+		}
+		assert.Equal(t, page.Total, v.total)
+		if v.total == 1 {
+			assert.Equal(t, page.Data[0].ID, products[0].ID)
+			assert.Equal(t, len(page.Data[0].Breadcrumb), 2)
+			assert.Equal(t, page.Data[0].Tags, []string{"Promoção"})
+		}
+	}
+
+	// A category with children cannot be deleted
+	req, err = http.NewRequest("DELETE", "/categories", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": foodID.String()})
+	req.Header.Set("Authorization", tokenString)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(server.DeleteCategory).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusConflict)
+
+	// Deleting the leaf leaves its product uncategorized
+	req, err = http.NewRequest("DELETE", "/categories", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": fastID.String()})
+	req.Header.Set("Authorization", tokenString)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(server.DeleteCategory).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+
+	product := models.Product{}
+	err = server.DB.Model(&models.Product{}).Where("id = ?", products[0].ID).Take(&product).Error
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	assert.Equal(t, product.CategoryID, (*uuid.UUID)(nil))
+
+	// The product gets a revision for the category it lost
+	revisions, err := (&models.ProductRevision{}).FindProductRevisions(server.DB, product.ID, 0, 1)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	assert.Equal(t, len(*revisions), 1)
+	assert.Equal(t, (*revisions)[0].Action, models.RevisionUpdate)
+	assert.Equal(t, *(*revisions)[0].ActorID, users[0].ID)
+	assert.Equal(t, string((*revisions)[0].Changes["category_id"].To), "null")
+}
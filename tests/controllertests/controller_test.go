@@ -12,6 +12,7 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/arikardnoir/asiwaju/api/controllers"
 	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
 )
 
 var server = controllers.Server{}
@@ -59,11 +60,11 @@ func Database() {
 }
 
 func refreshUserTable() error {
-	err := server.DB.DropTableIfExists(&models.User{}).Error
+	err := server.DB.DropTableIfExists(&models.User{}, &models.RefreshToken{}, &models.OneTimeToken{}, &models.RecoveryCode{}).Error
 	if err != nil {
 		return err
 	}
-	err = server.DB.AutoMigrate(&models.User{}).Error
+	err = server.DB.AutoMigrate(&models.User{}, &models.RefreshToken{}, &models.OneTimeToken{}, &models.RecoveryCode{}).Error
 	if err != nil {
 		return err
 	}
@@ -126,11 +127,15 @@ func seedUsers() ([]models.User, error) {
 
 func refreshUserAndProductTable() error {
 
-	err := server.DB.DropTableIfExists(&models.User{}, &models.Product{}).Error
+	err := server.DB.DropTableIfExists("product_tags", &models.Tag{}, &models.Category{}, &models.ProductVariant{}, &models.StockMovement{}, &models.StockReservation{}, &models.ExchangeRate{}, &models.ProductImage{}, &models.ImageRendition{}, &models.ImportJob{}, &models.ProductRevision{}, &models.PriceChange{}, &models.User{}, &models.Product{}, &models.RefreshToken{}, &models.APIKey{}, &models.OneTimeToken{}, &models.RecoveryCode{}).Error
 	if err != nil {
 		return err
 	}
-	err = server.DB.AutoMigrate(&models.User{}, &models.Product{}).Error
+	err = server.DB.AutoMigrate(&models.User{}, &models.Product{}, &models.RefreshToken{}, &models.APIKey{}, &models.Category{}, &models.Tag{}, &models.ProductVariant{}, &models.StockMovement{}, &models.StockReservation{}, &models.ExchangeRate{}, &models.ProductImage{}, &models.ImageRendition{}, &models.ImportJob{}, &models.ProductRevision{}, &models.PriceChange{}, &models.OneTimeToken{}, &models.RecoveryCode{}).Error
+	if err != nil {
+		return err
+	}
+	err = models.MigrateProductSearch(server.DB)
 	if err != nil {
 		return err
 	}
@@ -159,7 +164,7 @@ func seedOneUserAndOneProduct() (models.User,models.Product, error) {
 		ID:       uuid.Must(uuid.NewRandom()),
 		Name:   			"BUFFET ALMOÇO NA MESA",
 		Brand:  			"Pizza Hut",
-		Price:  			40,
+		Price:  			money.MustParse("40", "BRL"),
 		Image:  			"https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg",
 		OwnerID: 			user.ID,
 		Description:  "Serviço exclusivo para consumo no serviço à mesa dos restaurantes aderentes. Válido de 2.ª a 6.ª feira, das 12:00h às 16:00h, exceto feriados. Imagens ilustrativas. IVA incluído à taxa legal em vigor.",
@@ -199,7 +204,7 @@ func seedUsersAndProducts() ([]models.User, []models.Product, error) {
 			ID:       uuid.Must(uuid.NewRandom()),
 			Name:    			"Shawarma de Frango",
 			Brand:  			"Alchaer Restaurante",
-			Price:				31.12,
+			Price:				money.MustParse("31.12", "BRL"),
 			Image:  			"https://images.rappi.com.br/products/06c0a5c9-9db5-4af9-b86b-da49927fb673-1673533770540.png?e=webp&d=511x511&q=85",
 			OwnerID: 			users[0].ID,
 			Description:  "Pão sírio assado na hora com peito de frango, picles, batata frita, pasta de alho e molho de romã.",
@@ -208,7 +213,7 @@ func seedUsersAndProducts() ([]models.User, []models.Product, error) {
 			ID:       uuid.Must(uuid.NewRandom()),
 			Name:    "MacBook Pro 13”",
 			Brand:  "Apple Inc.",
-			Price: 1299,
+			Price: money.MustParse("1299", "USD"),
 			Image:  "https://www.apple.com/v/macbook-pro/ah/images/overview/hero_13__d1tfa5zby7e6_large.jpg",
 			OwnerID: users[1].ID,
 			Description:  "The new M2 chip makes the 13‑inch MacBook Pro more capable than ever. The same compact design supports up to 20 hours of battery life1 and an active cooling system to sustain enhanced performance. Featuring a brilliant Retina display, a FaceTime HD camera, and studio‑quality mics, it’s our most portable pro laptop.",
@@ -229,3 +234,8 @@ func seedUsersAndProducts() ([]models.User, []models.Product, error) {
 	}
 	return users, products, nil
 }
+
+// moneyJSON the price as it comes back in a decoded JSON response
+func moneyJSON(m money.Money) map[string]interface{} {
+	return map[string]interface{}{"amount": m.Decimal(), "currency": m.Currency}
+}
@@ -0,0 +1,90 @@
+package controllertests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestExchangeRatesAndConvertedPrices(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, product, err := seedOneUserAndOneProduct()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token)
+
+	upload := func(body string) int {
+		req, err := http.NewRequest("PUT", "/admin/exchange-rates", bytes.NewBufferString(body))
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.UploadExchangeRates).ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, upload(`{"base": "USD", "rates": {"XYZ": 2}}`), http.StatusUnprocessableEntity)
+	assert.Equal(t, upload(`{"base": "USD", "rates": {"BRL": -5}}`), http.StatusUnprocessableEntity)
+	assert.Equal(t, upload(`{"base": "USD", "rates": {"USD": 3, "BRL": 5}}`), http.StatusUnprocessableEntity)
+	assert.Equal(t, upload(`{"base": "usd", "rates": {"brl": 5, "EUR": 0.9}}`), http.StatusOK)
+
+	samples := []struct {
+		query          string
+		acceptCurrency string
+		statusCode     int
+		converted      map[string]interface{}
+	}{
+		// The product costs 40 BRL
+		{query: "", statusCode: http.StatusOK},
+		{query: "?currency=usd", statusCode: http.StatusOK, converted: map[string]interface{}{"amount": "8.00", "currency": "USD", "rate": 0.2}},
+		{query: "?currency=BRL", statusCode: http.StatusOK, converted: map[string]interface{}{"amount": "40.00", "currency": "BRL", "rate": float64(1)}},
+		{acceptCurrency: "JPY;q=0.9, EUR", statusCode: http.StatusOK, converted: map[string]interface{}{"amount": "7.20", "currency": "EUR", "rate": 0.18}},
+		// A header the rates cannot serve is only ignored
+		{acceptCurrency: "JPY", statusCode: http.StatusOK},
+		{query: "?currency=XYZ", statusCode: http.StatusBadRequest},
+		{query: "?currency=JPY", statusCode: http.StatusUnprocessableEntity},
+	}
+	for _, v := range samples {
+		req, err := http.NewRequest("GET", "/products"+v.query, nil)
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": product.ID.String()})
+		req.Header.Set("Authorization", tokenString)
+		if v.acceptCurrency != "" {
+			req.Header.Set("Accept-Currency", v.acceptCurrency)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.GetProduct).ServeHTTP(rr, req)
+
+		responseMap := make(map[string]interface{})
+		err = json.Unmarshal(rr.Body.Bytes(), &responseMap)
+		if err != nil {
+			t.Errorf("Cannot convert to json: %v", err)
+		}
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == http.StatusOK {
+			assert.Equal(t, responseMap["price"], moneyJSON(product.Price))
+			if v.converted == nil {
+				assert.Equal(t, responseMap["converted_price"], nil)
+			} else {
+				assert.Equal(t, responseMap["converted_price"], v.converted)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package controllertests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestInventoryAndReservations(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	users, products, err := seedUsersAndProducts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _, err := server.SignIn(users[0].Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token)
+	pid := products[0].ID.String()
+
+	requestAs := func(token string, handler http.HandlerFunc, vars map[string]string, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/products/inventory", bytes.NewBufferString(body))
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		req = mux.SetURLVars(req, vars)
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	request := func(handler http.HandlerFunc, vars map[string]string, body string) *httptest.ResponseRecorder {
+		return requestAs(tokenString, handler, vars, body)
+	}
+	inventory := func() models.StockLevel {
+		rr := request(server.GetInventory, map[string]string{"id": pid}, "")
+		assert.Equal(t, rr.Code, http.StatusOK)
+		level := models.StockLevel{}
+		err := json.Unmarshal(rr.Body.Bytes(), &level)
+		if err != nil {
+			t.Errorf("Cannot convert to json: %v", err)
+		}
+		return level
+	}
+
+	samples := []struct {
+		body       string
+		statusCode int
+	}{
+		{body: `{"kind": "receive", "quantity": 5, "note": "First delivery"}`, statusCode: http.StatusCreated},
+		{body: `{"kind": "receive", "quantity": -1}`, statusCode: http.StatusUnprocessableEntity},
+		{body: `{"kind": "sell", "quantity": 1}`, statusCode: http.StatusUnprocessableEntity},
+		{body: `{"kind": "adjust", "quantity": -6}`, statusCode: http.StatusConflict},
+	}
+	for _, v := range samples {
+		rr := request(server.CreateStockMovement, map[string]string{"id": pid}, v.body)
+		assert.Equal(t, rr.Code, v.statusCode)
+	}
+	assert.Equal(t, inventory(), models.StockLevel{Stock: 5, Reserved: 0, Available: 5})
+
+	// Ten concurrent reservations of one unit for five units: only five win
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	codes := map[int]int{}
+	reservations := []string{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := request(server.CreateReservation, map[string]string{"id": pid}, `{"quantity": 1, "ttl_seconds": 60}`)
+			mu.Lock()
+			defer mu.Unlock()
+			codes[rr.Code]++
+			if rr.Code == http.StatusCreated {
+				reservation := models.StockReservation{}
+				_ = json.Unmarshal(rr.Body.Bytes(), &reservation)
+				reservations = append(reservations, reservation.ID.String())
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, codes[http.StatusCreated], 5)
+	assert.Equal(t, codes[http.StatusConflict], 5)
+	assert.Equal(t, inventory(), models.StockLevel{Stock: 5, Reserved: 5, Available: 0})
+
+	rr := request(server.ConfirmReservation, map[string]string{"id": pid, "reservationID": reservations[0]}, "")
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = request(server.ConfirmReservation, map[string]string{"id": pid, "reservationID": reservations[0]}, "")
+	assert.Equal(t, rr.Code, http.StatusConflict)
+	rr = request(server.ReleaseReservation, map[string]string{"id": pid, "reservationID": reservations[1]}, "")
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, inventory(), models.StockLevel{Stock: 4, Reserved: 3, Available: 1})
+
+	// The other user's product is out of reach
+	rr = request(server.CreateReservation, map[string]string{"id": products[1].ID.String()}, `{"quantity": 1}`)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+
+	// Any user may reserve units of a public product, only they or the owner close the reservation
+	err = server.DB.Model(&products[0]).UpdateColumn("public", true).Error
+	if err != nil {
+		log.Fatalf("cannot publish the product: %v", err)
+	}
+	buyerToken, _, err := server.SignIn(users[1].Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	buyerString := fmt.Sprintf("Bearer %v", buyerToken)
+	rr = requestAs(buyerString, server.CreateReservation, map[string]string{"id": pid}, `{"quantity": 1}`)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	buyerReservation := models.StockReservation{}
+	err = json.Unmarshal(rr.Body.Bytes(), &buyerReservation)
+	if err != nil {
+		t.Errorf("Cannot convert to json: %v", err)
+	}
+	assert.Equal(t, buyerReservation.UserID, users[1].ID)
+	rr = requestAs(buyerString, server.ReleaseReservation, map[string]string{"id": pid, "reservationID": reservations[2]}, "")
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = requestAs(buyerString, server.GetStockMovements, map[string]string{"id": pid}, "")
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = requestAs(buyerString, server.ConfirmReservation, map[string]string{"id": pid, "reservationID": buyerReservation.ID.String()}, "")
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, inventory(), models.StockLevel{Stock: 3, Reserved: 3, Available: 0})
+
+	// Every change is in the ledger: 1 receive, 6 reserves, 2 sells and 1 release
+	rr = request(server.GetStockMovements, map[string]string{"id": pid}, "")
+	assert.Equal(t, rr.Code, http.StatusOK)
+	movements := []models.StockMovement{}
+	err = json.Unmarshal(rr.Body.Bytes(), &movements)
+	if err != nil {
+		t.Errorf("Cannot convert to json: %v", err)
+	}
+	assert.Equal(t, len(movements), 10)
+}
@@ -10,7 +10,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/arikardnoir/asiwaju/api/controllers"
+	"github.com/arikardnoir/asiwaju/api/loginguard"
+	"github.com/arikardnoir/asiwaju/api/models"
 	"gopkg.in/go-playground/assert.v1"
 )
 
@@ -38,12 +43,12 @@ func TestSignIn(t *testing.T) {
 		{
 			email:        user.Email,
 			password:     "Wrong password",
-			errorMessage: "crypto/bcrypt: hashedPassword is not the hash of the given password",
+			errorMessage: "Invalid email or password",
 		},
 		{
 			email:        "Wrong email",
 			password:     "password",
-			errorMessage: "record not found",
+			errorMessage: "Invalid email or password",
 		},
 	}
 
@@ -51,7 +56,8 @@ func TestSignIn(t *testing.T) {
 
 		token, _, err := server.SignIn(v.email, v.password)
 		if err != nil {
-			assert.Equal(t, err, errors.New(v.errorMessage))
+			assert.Equal(t, errors.Is(err, controllers.ErrInvalidCredentials), true)
+			assert.Equal(t, err.Error(), v.errorMessage)
 		} else {
 			assert.NotEqual(t, token, "")
 		}
@@ -80,13 +86,13 @@ func TestLogin(t *testing.T) {
 		},
 		{
 			inputJSON:    `{"email": "kay.maziano@gmail.com", "password": "wrong password"}`,
-			statusCode:   422,
-			errorMessage: "Incorrect Password",
+			statusCode:   401,
+			errorMessage: "Invalid email or password",
 		},
 		{
 			inputJSON:    `{"email": "frank@gmail.com", "password": "password"}`,
-			statusCode:   422,
-			errorMessage: "Incorrect Details",
+			statusCode:   401,
+			errorMessage: "Invalid email or password",
 		},
 		{
 			inputJSON:    `{"email": "kangmail.com", "password": "password"}`,
@@ -125,7 +131,7 @@ func TestLogin(t *testing.T) {
 			assert.NotEqual(t, rr.Body.String(), "")
 		}
 
-		if v.statusCode == 422 && v.errorMessage != "" {
+		if v.statusCode != 200 && v.errorMessage != "" {
 			responseMap := make(map[string]interface{})
 			err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
 			if err != nil {
@@ -134,4 +140,248 @@ func TestLogin(t *testing.T) {
 			assert.Equal(t, responseMap["error"], v.errorMessage)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestRefreshToken(t *testing.T) {
+
+	err := refreshUserTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = seedOneUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/login", bytes.NewBufferString(`{"email": "kay.maziano@gmail.com", "password": "password"}`))
+	if err != nil {
+		t.Errorf("this is the error: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.Login).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	loginMap := make(map[string]interface{})
+	err = json.Unmarshal([]byte(rr.Body.String()), &loginMap)
+	if err != nil {
+		t.Errorf("Cannot convert to json: %v", err)
+	}
+	firstRefreshToken := fmt.Sprintf("%v", loginMap["refresh_token"])
+
+	refresh := func(refreshToken string) (int, map[string]interface{}) {
+		req, err := http.NewRequest("POST", "/token/refresh", bytes.NewBufferString(fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken)))
+		if err != nil {
+			t.Errorf("this is the error: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.RefreshToken).ServeHTTP(rr, req)
+		responseMap := make(map[string]interface{})
+		err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
+		if err != nil {
+			t.Errorf("Cannot convert to json: %v", err)
+		}
+		return rr.Code, responseMap
+	}
+
+	// A fresh refresh token is rotated into a new pair
+	code, responseMap := refresh(firstRefreshToken)
+	assert.Equal(t, code, http.StatusOK)
+	assert.NotEqual(t, responseMap["token"], "")
+	secondRefreshToken := fmt.Sprintf("%v", responseMap["refresh_token"])
+	assert.NotEqual(t, secondRefreshToken, firstRefreshToken)
+
+	// Reusing the rotated token revokes the whole family
+	code, responseMap = refresh(firstRefreshToken)
+	assert.Equal(t, code, http.StatusUnauthorized)
+	assert.Equal(t, responseMap["error"], "Refresh Token Reused")
+
+	code, responseMap = refresh(secondRefreshToken)
+	assert.Equal(t, code, http.StatusUnauthorized)
+	assert.Equal(t, responseMap["error"], "Invalid Refresh Token")
+
+	code, _ = refresh("this is not a refresh token")
+	assert.Equal(t, code, http.StatusUnauthorized)
+}
+
+func TestLogout(t *testing.T) {
+
+	err := refreshUserTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	firstToken, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	secondToken, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+
+	call := func(handler http.HandlerFunc, token string) int {
+		req, err := http.NewRequest("POST", "/logout", http.NoBody)
+		if err != nil {
+			t.Errorf("this is the error: %v", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Logging out revokes only the token that was used
+	assert.Equal(t, call(server.Logout, firstToken), http.StatusNoContent)
+	assert.Equal(t, call(server.Logout, firstToken), http.StatusUnauthorized)
+
+	// Logging out everywhere revokes the remaining tokens too
+	assert.Equal(t, call(server.LogoutAll, secondToken), http.StatusNoContent)
+	assert.Equal(t, call(server.LogoutAll, secondToken), http.StatusUnauthorized)
+
+	thirdToken, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	assert.Equal(t, call(server.Logout, thirdToken), http.StatusNoContent)
+}
+
+func TestJWKSAndKeyRotation(t *testing.T) {
+
+	err := refreshUserTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	oldToken, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	newKey, err := auth.Keys().Rotate()
+	if err != nil {
+		log.Fatalf("cannot rotate keys: %v\n", err)
+	}
+	newToken, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+
+	// Both the retired and the new key are published and keep verifying
+	req, err := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.JWKS).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	jwks := auth.JWKS{}
+	err = json.Unmarshal([]byte(rr.Body.String()), &jwks)
+	if err != nil {
+		t.Errorf("Cannot convert to json: %v", err)
+	}
+	kids := map[string]bool{}
+	for _, key := range jwks.Keys {
+		kids[key.Kid] = true
+	}
+	assert.Equal(t, kids[newKey.ID], true)
+	assert.Equal(t, len(jwks.Keys) >= 2, true)
+
+	for _, token := range []string{oldToken, newToken} {
+		req, err := http.NewRequest("GET", "/products", nil)
+		if err != nil {
+			t.Errorf("this is the error: %v", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+		uid, err := auth.ExtractTokenID(req)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, uid, user.ID)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+
+	err := refreshUserTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+	config := loginguard.DefaultConfig()
+	config.FreeAttempts = 2
+	config.BaseDelay = time.Hour
+	config.MaxDelay = time.Hour
+	config.MaxAccountFailures = 3
+	server.LoginGuard = loginguard.New(loginguard.NewMemoryStore(), config)
+	defer func() { server.LoginGuard = nil }()
+
+	login := func(password, ip string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/login", bytes.NewBufferString(fmt.Sprintf(`{"email": "%s", "password": "%s"}`, user.Email, password)))
+		if err != nil {
+			t.Errorf("this is the error: %v", err)
+		}
+		req.RemoteAddr = ip + ":1234"
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.Login).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The free attempts fail as usual
+	assert.NotEqual(t, login("wrong password", "10.0.0.1").Code, http.StatusOK)
+	assert.NotEqual(t, login("wrong password", "10.0.0.1").Code, http.StatusOK)
+
+	// Then every client has to wait, even with the right password
+	assert.NotEqual(t, login("wrong password", "10.0.0.2").Code, http.StatusTooManyRequests)
+	rr := login("password", "10.0.0.3")
+	assert.Equal(t, rr.Code, http.StatusTooManyRequests)
+	assert.NotEqual(t, rr.Header().Get("Retry-After"), "")
+
+	// The failures only lock the account for the IP they came from
+	lockConfig := config
+	lockConfig.FreeAttempts = 10
+	server.LoginGuard = loginguard.New(loginguard.NewMemoryStore(), lockConfig)
+	for i := 0; i < 3; i++ {
+		assert.NotEqual(t, login("wrong password", "10.0.0.1").Code, http.StatusOK)
+	}
+	rr = login("password", "10.0.0.1")
+	assert.Equal(t, rr.Code, http.StatusLocked)
+	assert.NotEqual(t, rr.Header().Get("Retry-After"), "")
+	assert.Equal(t, login("password", "10.0.0.4").Code, http.StatusOK)
+
+	// A successful login starts the count over
+	server.LoginGuard = loginguard.New(loginguard.NewMemoryStore(), config)
+	assert.NotEqual(t, login("wrong password", "10.0.0.1").Code, http.StatusOK)
+	assert.Equal(t, login("password", "10.0.0.1").Code, http.StatusOK)
+	assert.NotEqual(t, login("wrong password", "10.0.0.1").Code, http.StatusOK)
+	assert.NotEqual(t, login("wrong password", "10.0.0.1").Code, http.StatusTooManyRequests)
+	assert.Equal(t, login("wrong password", "10.0.0.1").Code, http.StatusTooManyRequests)
+
+	// Failures past the window are swept, unless they still lock
+	err = server.DB.DropTableIfExists(&models.LoginAttempt{}).AutoMigrate(&models.LoginAttempt{}).Error
+	if err != nil {
+		t.Fatalf("cannot refresh the login attempts: %v", err)
+	}
+	sweepConfig := config
+	sweepConfig.Window = time.Millisecond
+	sweepConfig.MaxIPFailures = 1
+	for _, store := range []loginguard.Store{loginguard.NewMemoryStore(), loginguard.NewDBStore(server.DB)} {
+		guard := loginguard.New(store, sweepConfig)
+		server.LoginGuard = guard
+		assert.NotEqual(t, login("wrong password", "10.0.0.5").Code, http.StatusOK)
+		time.Sleep(10 * time.Millisecond)
+		// The account and the account from the IP, the IP is locked
+		swept, err := guard.Sweep()
+		assert.Equal(t, err, nil)
+		assert.Equal(t, swept, int64(2))
+		swept, _ = guard.Sweep()
+		assert.Equal(t, swept, int64(0))
+	}
+}
@@ -0,0 +1,84 @@
+package controllertests
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/arikardnoir/asiwaju/api/mailer"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestPasswordReset(t *testing.T) {
+
+	err := refreshUserTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+	outbox := mailer.NewMemorySender()
+	server.Mailer = outbox
+	defer func() { server.Mailer = nil }()
+
+	oldToken, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+
+	post := func(handler http.HandlerFunc, inputJSON string) int {
+		req, err := http.NewRequest("POST", "/password", bytes.NewBufferString(inputJSON))
+		if err != nil {
+			t.Errorf("this is the error: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Unknown emails get the same answer and no email
+	assert.Equal(t, post(server.ForgotPassword, `{"email": "nobody@gmail.com"}`), http.StatusAccepted)
+	assert.Equal(t, len(outbox.Messages()), 0)
+
+	assert.Equal(t, post(server.ForgotPassword, fmt.Sprintf(`{"email": "%s"}`, user.Email)), http.StatusAccepted)
+	msg, ok := outbox.Last(user.Email)
+	assert.Equal(t, ok, true)
+	// The link opens the reset page of the frontend, which posts the new password
+	assert.Equal(t, strings.Contains(msg.Body, "/reset-password?token="), true)
+	match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(msg.Body)
+	assert.Equal(t, len(match), 2)
+	resetToken, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Errorf("cannot read the reset token: %v", err)
+	}
+
+	assert.Equal(t, post(server.ResetPassword, `{"token": "not a token", "password": "new password"}`), http.StatusBadRequest)
+	assert.Equal(t, post(server.ResetPassword, fmt.Sprintf(`{"token": "%s", "password": ""}`, resetToken)), http.StatusUnprocessableEntity)
+	assert.Equal(t, post(server.ResetPassword, fmt.Sprintf(`{"token": "%s", "password": "new password"}`, resetToken)), http.StatusOK)
+
+	// The token is single-use
+	assert.Equal(t, post(server.ResetPassword, fmt.Sprintf(`{"token": "%s", "password": "other password"}`, resetToken)), http.StatusBadRequest)
+
+	_, _, err = server.SignIn(user.Email, "password")
+	assert.NotEqual(t, err, nil)
+	_, _, err = server.SignIn(user.Email, "new password")
+	assert.Equal(t, err, nil)
+
+	// Sessions from before the reset are over
+	req, err := http.NewRequest("GET", "/products", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", oldToken))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.GetProducts).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
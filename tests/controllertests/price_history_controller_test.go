@@ -0,0 +1,171 @@
+package controllertests
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestPriceHistory(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token)
+
+	// Each product gets a history in January 2024, on top of the price it was created with now
+	day := func(d, hour int) time.Time {
+		return time.Date(2024, time.January, d, hour, 0, 0, 0, time.UTC)
+	}
+	seed := func(name string, history map[time.Time]string) models.Product {
+		product := models.Product{
+			ID:      uuid.Must(uuid.NewRandom()),
+			Name:    name,
+			Brand:   "Camil",
+			Price:   money.MustParse("1", "BRL"),
+			OwnerID: user.ID,
+		}
+		_, err := product.SaveProduct(server.DB)
+		if err != nil {
+			log.Fatalf("cannot seed the product: %v\n", err)
+		}
+		for changedAt, price := range history {
+			change := models.PriceChange{ID: uuid.Must(uuid.NewRandom()), ProductID: product.ID, Price: money.MustParse(price, "BRL"), ChangedAt: changedAt}
+			err = server.DB.Create(&change).Error
+			if err != nil {
+				log.Fatalf("cannot seed the price history: %v\n", err)
+			}
+		}
+		return product
+	}
+	rice := seed("Arroz", map[time.Time]string{day(1, 12): "10", day(3, 9): "12", day(3, 18): "9", day(10, 8): "11"})
+	beans := seed("Feijão", map[time.Time]string{day(1, 12): "20", day(5, 12): "10"})
+	seed("Sal", map[time.Time]string{day(1, 12): "5"})
+	seed("Açúcar", map[time.Time]string{day(4, 12): "8", day(6, 12): "10"})
+
+	get := func(handler http.HandlerFunc, url string, vars map[string]string, response interface{}) int {
+		req, _ := http.NewRequest("GET", url, nil)
+		req = mux.SetURLVars(req, vars)
+		req.Header.Set("Authorization", tokenString)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code == http.StatusOK {
+			err := json.Unmarshal(rr.Body.Bytes(), response)
+			if err != nil {
+				t.Errorf("Cannot convert to json: %v", err)
+			}
+		}
+		return rr.Code
+	}
+	vars := map[string]string{"id": rice.ID.String()}
+
+	changes := struct {
+		Data []models.PriceChange `json:"data"`
+	}{}
+	assert.Equal(t, get(server.GetPriceHistory, "/products/price-history?from=2024-01-03&to=2024-01-03", vars, &changes), http.StatusOK)
+	assert.Equal(t, len(changes.Data), 2)
+	assert.Equal(t, changes.Data[0].Price, money.MustParse("12", "BRL"))
+	assert.Equal(t, changes.Data[1].Price, money.MustParse("9", "BRL"))
+
+	// Long histories are paged
+	page := struct {
+		Data  []models.PriceChange `json:"data"`
+		Links struct {
+			Next *string `json:"next"`
+		} `json:"links"`
+	}{}
+	assert.Equal(t, get(server.GetPriceHistory, "/products/price-history?from=2024-01-01&to=2024-01-31&limit=3", vars, &page), http.StatusOK)
+	assert.Equal(t, len(page.Data), 3)
+	assert.NotEqual(t, page.Links.Next, nil)
+	assert.Equal(t, get(server.GetPriceHistory, *page.Links.Next, vars, &page), http.StatusOK)
+	assert.Equal(t, len(page.Data), 1)
+	assert.Equal(t, page.Data[0].Price, money.MustParse("11", "BRL"))
+	assert.Equal(t, page.Links.Next, (*string)(nil))
+	assert.Equal(t, get(server.GetPriceHistory, "/products/price-history?after="+beans.ID.String(), vars, &page), http.StatusBadRequest)
+
+	buckets := struct {
+		Data []models.PriceBucket `json:"data"`
+	}{}
+	brl := func(amount string) money.Money {
+		return money.MustParse(amount, "BRL")
+	}
+	assert.Equal(t, get(server.GetPriceHistory, "/products/price-history?interval=day&from=2024-01-01&to=2024-01-04", vars, &buckets), http.StatusOK)
+	assert.Equal(t, len(buckets.Data), 4)
+	assert.Equal(t, buckets.Data[1].Start, day(2, 0))
+	assert.Equal(t, buckets.Data[1].Close, brl("10"))
+	assert.Equal(t, buckets.Data[1].Changes, 0)
+	assert.Equal(t, buckets.Data[2], models.PriceBucket{Start: day(3, 0), Open: brl("10"), Close: brl("9"), Low: brl("9"), High: brl("12"), Changes: 2})
+
+	assert.Equal(t, get(server.GetPriceHistory, "/products/price-history?interval=week&from=2024-01-01&to=2024-01-14", vars, &buckets), http.StatusOK)
+	assert.Equal(t, len(buckets.Data), 2)
+	assert.Equal(t, buckets.Data[0], models.PriceBucket{Start: day(1, 0), Open: brl("10"), Close: brl("9"), Low: brl("9"), High: brl("12"), Changes: 3})
+	assert.Equal(t, buckets.Data[1], models.PriceBucket{Start: day(8, 0), Open: brl("9"), Close: brl("11"), Low: brl("9"), High: brl("11"), Changes: 1})
+
+	// Before its first price a product has no bucket
+	assert.Equal(t, get(server.GetPriceHistory, "/products/price-history?interval=month&from=2023-12-01&to=2024-01-31", vars, &buckets), http.StatusOK)
+	assert.Equal(t, len(buckets.Data), 1)
+	assert.Equal(t, buckets.Data[0].Changes, 4)
+
+	assert.Equal(t, get(server.GetPriceHistory, "/products/price-history?interval=year", vars, &buckets), http.StatusBadRequest)
+	assert.Equal(t, get(server.GetPriceHistory, "/products/price-history?interval=day&from=2000-01-01", vars, &buckets), http.StatusBadRequest)
+	// Without from the buckets of a product created long ago go back a bounded window
+	err = server.DB.Model(&models.Product{}).Where("id = ?", rice.ID).UpdateColumn("created_at", time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)).Error
+	if err != nil {
+		t.Fatalf("cannot age the product: %v", err)
+	}
+	assert.Equal(t, get(server.GetPriceHistory, "/products/price-history?interval=day", vars, &buckets), http.StatusOK)
+	assert.Equal(t, len(buckets.Data) <= models.DefaultPriceBuckets, true)
+
+	movers := struct {
+		Data []models.PriceMover `json:"data"`
+	}{}
+	assert.Equal(t, get(server.GetPriceMovers, "/products/price-movers?from=2024-01-02&to=2024-01-10", nil, &movers), http.StatusOK)
+	assert.Equal(t, len(movers.Data), 3)
+	assert.Equal(t, movers.Data[0].ProductID, beans.ID)
+	assert.Equal(t, movers.Data[0].Change, brl("-10"))
+	assert.Equal(t, movers.Data[0].ChangePercent, -50.0)
+	assert.Equal(t, movers.Data[1].ChangePercent, 25.0)
+	assert.Equal(t, movers.Data[2].Name, "Arroz")
+	assert.Equal(t, movers.Data[2].ChangePercent, 10.0)
+	assert.Equal(t, get(server.GetPriceMovers, "/products/price-movers?from=2024-01-02&to=2024-01-10&limit=1", nil, &movers), http.StatusOK)
+	assert.Equal(t, len(movers.Data), 1)
+
+	// Updates add to the history only when the price changes
+	count := func() int {
+		n := 0
+		server.DB.Model(&models.PriceChange{}).Where("product_id = ?", rice.ID).Count(&n)
+		return n
+	}
+	before := count()
+	rice.Name = "Arroz Integral"
+	_, err = rice.UpdateAProduct(server.DB, rice.ID)
+	if err != nil {
+		t.Fatalf("cannot update the product: %v", err)
+	}
+	assert.Equal(t, count(), before)
+	rice.Price = brl("13.50")
+	_, err = rice.UpdateAProduct(server.DB, rice.ID)
+	if err != nil {
+		t.Fatalf("cannot update the product: %v", err)
+	}
+	assert.Equal(t, count(), before+1)
+}
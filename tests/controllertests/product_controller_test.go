@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"gopkg.in/go-playground/assert.v1"
@@ -37,7 +38,7 @@ func TestCreateProduct(t *testing.T) {
 		statusCode   int
 		name         string
 		brand        string
-		price        float64
+		price        money.Money
 		image        string
 		owner_id     uuid.UUID
 		description  string
@@ -45,12 +46,12 @@ func TestCreateProduct(t *testing.T) {
 		errorMessage string
 	}{
 		{
-			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": 40, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
+			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": {"amount": "40", "currency": "BRL"}, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			statusCode:   201,
 			tokenGiven:   tokenString,
 			name:         "BUFFET ALMOÇO NA MESA",
 			brand:        "Pizza Hut",
-			price:        40,
+			price:        money.MustParse("40", "BRL"),
 			image:        "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg",
 			owner_id:     choosedID,
 			description:  "Serviço",
@@ -58,41 +59,68 @@ func TestCreateProduct(t *testing.T) {
 		},
 		{
 			// When no token is passed
-			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": 40, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
+			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": {"amount": "40", "currency": "BRL"}, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			statusCode:   401,
 			tokenGiven:   "",
 			errorMessage: "Unauthorized",
 		},
 		{
 			// When incorrect token is passed
-			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": 40, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
+			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": {"amount": "40", "currency": "BRL"}, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			statusCode:   401,
 			tokenGiven:   "This is an incorrect token",
 			errorMessage: "Unauthorized",
 		},
 		{
-			inputJSON:    `{"name":"", "brand":"Pizza Hut", "price": 40, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
+			inputJSON:    `{"name":"", "brand":"Pizza Hut", "price": {"amount": "40", "currency": "BRL"}, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			statusCode:   422,
 			tokenGiven:   tokenString,
 			errorMessage: "Required Name",
 		},
 		{
-			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"", "price": 40, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
+			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"", "price": {"amount": "40", "currency": "BRL"}, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			statusCode:   422,
 			tokenGiven:   tokenString,
 			errorMessage: "Required Brand",
 		},
 		{
-			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": 0.00, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
+			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": null, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			statusCode:   422,
 			tokenGiven:   tokenString,
 			errorMessage: "Required Price",
 		},
 		{
-			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": 40, "image": "", "description": "Serviço"}`,
+			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": {"amount": "0", "currency": "BRL"}, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			statusCode:   422,
 			tokenGiven:   tokenString,
-			errorMessage: "Required Image",
+			errorMessage: "Required Price",
+		},
+		{
+			// Image URLs are kept as they are, not HTML escaped
+			inputJSON:   `{"name":"Rodízio", "brand":"Pizza Hut", "price": {"amount": "40", "currency": "BRL"}, "image": "https://cdn.example.com/rodizio.jpg?w=200&h=200", "description": "Serviço"}`,
+			statusCode:  201,
+			tokenGiven:  tokenString,
+			name:        "Rodízio",
+			brand:       "Pizza Hut",
+			price:       money.MustParse("40", "BRL"),
+			image:       "https://cdn.example.com/rodizio.jpg?w=200&h=200",
+			description: "Serviço",
+		},
+		{
+			// Sizes are variants, a size string is ignored
+			inputJSON:   `{"name":"Tênis", "brand":"Nike", "price": {"amount": "40", "currency": "BRL"}, "size": "40;41", "description": "Tênis"}`,
+			statusCode:  201,
+			tokenGiven:  tokenString,
+			name:        "Tênis",
+			brand:       "Nike",
+			price:       money.MustParse("40", "BRL"),
+			description: "Tênis",
+		},
+		{
+			inputJSON:    `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": {"amount": "40", "currency": "BRL"}, "image": "javascript:alert(1)", "description": "Serviço"}`,
+			statusCode:   422,
+			tokenGiven:   tokenString,
+			errorMessage: "Invalid Image URL",
 		},
 	}
 	for _, v := range samples {
@@ -120,9 +148,12 @@ func TestCreateProduct(t *testing.T) {
 		if v.statusCode == 201 {
 			assert.Equal(t, responseMap["name"], v.name)
 			assert.Equal(t, responseMap["brand"], v.brand)
-			assert.Equal(t, responseMap["price"], v.price)
+			assert.Equal(t, responseMap["price"], moneyJSON(v.price))
 			assert.Equal(t, responseMap["image"], v.image)
 			assert.Equal(t, responseMap["description"], v.description)
+			_, hasSize := responseMap["size"]
+			assert.Equal(t, hasSize, false)
+			assert.Equal(t, responseMap["variants"], []interface{}{})
 		}
 		if v.statusCode == 401 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
 			assert.Equal(t, responseMap["error"], v.errorMessage)
@@ -160,12 +191,118 @@ func TestGetProducts(t *testing.T) {
 
 	handler.ServeHTTP(rr, req)
 
-	var products []models.Product
+	var page struct {
+		Data  []models.Product `json:"data"`
+		Total int              `json:"total"`
+	}
 	// trunk-ignore(golangci-lint/gosimple)
-	err = json.Unmarshal([]byte(rr.Body.String()), &products)
+	err = json.Unmarshal([]byte(rr.Body.String()), &page)
 
 	assert.Equal(t, rr.Code, http.StatusOK)
-	assert.Equal(t, len(products), 1)
+	assert.Equal(t, len(page.Data), 1)
+	assert.Equal(t, page.Total, 1)
+}
+
+func TestGetProductsPagination(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		product := models.Product{
+			ID:      uuid.Must(uuid.NewRandom()),
+			Name:    fmt.Sprintf("Product %d", i),
+			Brand:   "Asiwaju",
+			Price:   money.New(int64(i*1000), "BRL"),
+			Image:   "https://example.com/product.png",
+			OwnerID: user.ID,
+		}
+		if i > 3 {
+			product.Brand = "Other"
+		}
+		err = server.DB.Model(&models.Product{}).Create(&product).Error
+		if err != nil {
+			log.Fatalf("cannot seed products table: %v", err)
+		}
+	}
+	token, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+
+	type page struct {
+		Data  []models.Product `json:"data"`
+		Total int              `json:"total"`
+		Links struct {
+			Next string `json:"next"`
+			Prev string `json:"prev"`
+		} `json:"links"`
+	}
+	get := func(url string) (int, page) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.GetProducts).ServeHTTP(rr, req)
+		result := page{}
+		_ = json.Unmarshal(rr.Body.Bytes(), &result)
+		return rr.Code, result
+	}
+
+	code, first := get("/products?sort=price&limit=2")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, first.Total, 5)
+	assert.Equal(t, len(first.Data), 2)
+	assert.Equal(t, first.Data[0].Price, money.New(1000, "BRL"))
+	assert.Equal(t, first.Links.Prev, "")
+
+	_, second := get(first.Links.Next)
+	assert.Equal(t, len(second.Data), 2)
+	assert.Equal(t, second.Data[0].Price, money.New(3000, "BRL"))
+
+	_, last := get(second.Links.Next)
+	assert.Equal(t, len(last.Data), 1)
+	assert.Equal(t, last.Links.Next, "")
+
+	_, back := get(last.Links.Prev)
+	assert.Equal(t, len(back.Data), 2)
+	assert.Equal(t, back.Data[0].Price, money.New(3000, "BRL"))
+
+	_, filtered := get("/products?brand=asiwaju&min_price=15&price_currency=BRL&sort=-price")
+	assert.Equal(t, filtered.Total, 2)
+	assert.Equal(t, filtered.Data[0].Price, money.New(3000, "BRL"))
+
+	// Prices sort within their currency, 2500 JPY does not go between 20 and 30 BRL
+	yen := models.Product{
+		ID:      uuid.Must(uuid.NewRandom()),
+		Name:    "Product JPY",
+		Brand:   "Asiwaju",
+		Price:   money.New(2500, "JPY"),
+		Image:   "https://example.com/product.png",
+		OwnerID: user.ID,
+	}
+	err = server.DB.Model(&models.Product{}).Create(&yen).Error
+	if err != nil {
+		log.Fatalf("cannot seed products table: %v", err)
+	}
+	_, brl := get("/products?sort=price&limit=5")
+	assert.Equal(t, len(brl.Data), 5)
+	assert.Equal(t, brl.Data[4].Price, money.New(5000, "BRL"))
+	_, jpy := get(brl.Links.Next)
+	assert.Equal(t, len(jpy.Data), 1)
+	assert.Equal(t, jpy.Data[0].Price, money.New(2500, "JPY"))
+
+	code, _ = get("/products?sort=owner_id")
+	assert.Equal(t, code, http.StatusBadRequest)
+	code, _ = get("/products?sort=name&cursor=" + "bm90IGEgY3Vyc29y")
+	assert.Equal(t, code, http.StatusBadRequest)
 }
 func TestGetProductByID(t *testing.T) {
 
@@ -190,7 +327,7 @@ func TestGetProductByID(t *testing.T) {
 		statusCode   int
 		name         string
 		brand        string
-		price        float64
+		price        money.Money
 		image        string
 		owner_id     uuid.UUID
 		description  string
@@ -238,7 +375,7 @@ func TestGetProductByID(t *testing.T) {
 		if v.statusCode == 200 {
 			assert.Equal(t, product.Name, responseMap["name"])
 			assert.Equal(t, product.Brand, responseMap["brand"])
-			assert.Equal(t, product.Price, responseMap["price"])
+			assert.Equal(t, moneyJSON(product.Price), responseMap["price"])
 			assert.Equal(t, product.Image, responseMap["image"])
 			assert.Equal(t, product.Description, responseMap["description"])
 		}
@@ -281,7 +418,7 @@ func TestUpdateProduct(t *testing.T) {
 		statusCode   int
 		name         string
 		brand        string
-		price        float64
+		price        money.Money
 		image        string
 		owner_id     uuid.UUID
 		description  string
@@ -291,11 +428,11 @@ func TestUpdateProduct(t *testing.T) {
 		{
 			// Convert int64 to int first before converting to string
 			id:           AuthProductID,
-			updateJSON:   `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": 40, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
+			updateJSON:   `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": {"amount": "40", "currency": "BRL"}, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			statusCode:   200,
 			name:         "BUFFET ALMOÇO NA MESA",
 			brand:        "Pizza Hut",
-			price:        40,
+			price:        money.MustParse("40", "BRL"),
 			image:        "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg",
 			description:  "Serviço",
 			owner_id:     AuthProductOwnerID,
@@ -305,7 +442,7 @@ func TestUpdateProduct(t *testing.T) {
 		{
 			// When no token is provided
 			id:           AuthProductID,
-			updateJSON:   `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": 40, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
+			updateJSON:   `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": {"amount": "40", "currency": "BRL"}, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			tokenGiven:   "",
 			statusCode:   401,
 			errorMessage: "Unauthorized",
@@ -313,38 +450,38 @@ func TestUpdateProduct(t *testing.T) {
 		{
 			// When incorrect token is provided
 			id:           AuthProductID,
-			updateJSON:   `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": 40, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
+			updateJSON:   `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": {"amount": "40", "currency": "BRL"}, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			tokenGiven:   "this is an incorrect token",
 			statusCode:   401,
 			errorMessage: "Unauthorized",
 		},
 		{
 			id:           AuthProductID,
-			updateJSON:   `{"name":"", "brand":"Pizza Hut", "price": 40, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
+			updateJSON:   `{"name":"", "brand":"Pizza Hut", "price": {"amount": "40", "currency": "BRL"}, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			statusCode:   422,
 			tokenGiven:   tokenString,
 			errorMessage: "Required Name",
 		},
 		{
 			id:           AuthProductID,
-			updateJSON:   `{"name":"BUFFET ALMOÇO NA MESA", "brand":"", "price": 40, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
+			updateJSON:   `{"name":"BUFFET ALMOÇO NA MESA", "brand":"", "price": {"amount": "40", "currency": "BRL"}, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			statusCode:   422,
 			tokenGiven:   tokenString,
 			errorMessage: "Required Brand",
 		},
 		{
 			id:           AuthProductID,
-			updateJSON:   `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": 0.00, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
+			updateJSON:   `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": null, "image": "https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg", "description": "Serviço"}`,
 			statusCode:   422,
 			tokenGiven:   tokenString,
 			errorMessage: "Required Price",
 		},
 		{
 			id:           AuthProductID,
-			updateJSON:   `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": 40, "image": "", "description": "Serviço"}`,
+			updateJSON:   `{"name":"BUFFET ALMOÇO NA MESA", "brand":"Pizza Hut", "price": {"amount": "40", "currency": "BRL"}, "image": "javascript:alert(1)", "description": "Serviço"}`,
 			statusCode:   422,
 			tokenGiven:   tokenString,
-			errorMessage: "Required Image",
+			errorMessage: "Invalid Image URL",
 		},
 		{
 			id:           uuid.Nil,
@@ -378,7 +515,7 @@ func TestUpdateProduct(t *testing.T) {
 		if v.statusCode == 200 {
 			assert.Equal(t, responseMap["name"], v.name)
 			assert.Equal(t, responseMap["brand"], v.brand)
-			assert.Equal(t, responseMap["price"], v.price)
+			assert.Equal(t, responseMap["price"], moneyJSON(v.price))
 			assert.Equal(t, responseMap["image"], v.image)
 			assert.Equal(t, responseMap["description"], v.description)
 		}
@@ -490,3 +627,79 @@ func TestDeleteProduct(t *testing.T) {
 		}
 	}
 }
+
+func TestSearchProducts(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+	products := []models.Product{
+		models.Product{
+			Name:        "Salada de Frango",
+			Brand:       "Alchaer Restaurante",
+			Description: "Frango grelhado com folhas verdes e lascas de atum.",
+		},
+		models.Product{
+			Name:        "Gomes Da Costa Atum Sólido em Óleo",
+			Brand:       "Gomes Da Costa",
+			Description: "Produzido com o lombo do atum, a parte mais nobre do peixe.",
+		},
+		models.Product{
+			Name:  "MacBook Pro 13”",
+			Brand: "Apple Inc.",
+		},
+	}
+	for i := range products {
+		products[i].ID = uuid.Must(uuid.NewRandom())
+		products[i].Price = money.MustParse("10", "BRL")
+		products[i].Image = "https://example.com/product.png"
+		products[i].OwnerID = user.ID
+		err = server.DB.Model(&models.Product{}).Create(&products[i]).Error
+		if err != nil {
+			log.Fatalf("cannot seed products table: %v", err)
+		}
+	}
+	token, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+
+	search := func(query string) (int, []models.Product) {
+		req, err := http.NewRequest("GET", "/products/search?"+query, nil)
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.SearchProducts).ServeHTTP(rr, req)
+		result := struct {
+			Data []models.Product `json:"data"`
+		}{}
+		_ = json.Unmarshal(rr.Body.Bytes(), &result)
+		return rr.Code, result.Data
+	}
+
+	// Accents and case do not matter
+	code, found := search("q=solido+OLEO")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, len(found), 1)
+	assert.Equal(t, found[0].ID, products[1].ID)
+
+	// A match in the name ranks above one in the description
+	_, found = search("q=atum&lang=pt")
+	assert.Equal(t, len(found), 2)
+	assert.Equal(t, found[0].ID, products[1].ID)
+
+	_, found = search("q=apple")
+	assert.Equal(t, len(found), 1)
+
+	code, _ = search("q=")
+	assert.Equal(t, code, http.StatusBadRequest)
+	code, _ = search("q=atum&lang=klingon")
+	assert.Equal(t, code, http.StatusBadRequest)
+}
@@ -0,0 +1,125 @@
+package controllertests
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
+	"github.com/google/uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestExportProducts(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, _, err := seedOneUserAndOneProduct()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token)
+
+	sku := "CAFE-1"
+	products := []models.Product{
+		{Name: "=HYPERLINK(\"http://evil\")", Brand: "Pilão", Price: money.MustParse("15.90", "BRL"), SKU: &sku, Stock: 4, Public: true},
+		{Name: "Açúcar & Mel", Brand: "União", Price: money.MustParse("4.50", "BRL")},
+	}
+	for i := range products {
+		products[i].ID = uuid.Must(uuid.NewRandom())
+		products[i].OwnerID = user.ID
+		products[i].Prepare()
+		_, err = products[i].SaveProduct(server.DB)
+		if err != nil {
+			log.Fatalf("cannot seed products: %v\n", err)
+		}
+	}
+
+	export := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/products/export?"+query, nil)
+		req.Header.Set("Authorization", tokenString)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.ExportProducts).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// CSV has a header of every column, and quotes text a spreadsheet would run
+	rr := export("sort=name")
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, rr.Header().Get("Content-Type"), "text/csv; charset=utf-8")
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Errorf("Cannot read the csv: %v", err)
+	}
+	assert.Equal(t, len(records), 4)
+	assert.Equal(t, records[0], models.ProductColumns)
+	assert.Equal(t, records[1][3], "'=HYPERLINK(\"http://evil\")")
+	assert.Equal(t, records[1][1], "CAFE-1")
+	assert.Equal(t, records[1][8], "15.90")
+	assert.Equal(t, records[1][11], "true")
+	assert.Equal(t, records[2][3], "Açúcar & Mel")
+
+	// The filters of the listing apply
+	rr = export("format=csv&brand=Uni%C3%A3o")
+	records, _ = csv.NewReader(rr.Body).ReadAll()
+	assert.Equal(t, len(records), 2)
+	assert.Equal(t, records[1][0], products[1].ID.String())
+
+	rr = export("format=ndjson&min_price=10&max_price=20&price_currency=BRL")
+	assert.Equal(t, rr.Code, http.StatusOK)
+	lines := []models.ProductExport{}
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		line := models.ProductExport{}
+		err = json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			t.Errorf("Cannot convert to json: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	assert.Equal(t, len(lines), 1)
+	assert.Equal(t, lines[0].Name, "=HYPERLINK(\"http://evil\")")
+	assert.Equal(t, lines[0].Price, money.MustParse("15.90", "BRL"))
+
+	rr = export("format=xlsx&brand=Pil%C3%A3o")
+	assert.Equal(t, rr.Code, http.StatusOK)
+	body := rr.Body.Bytes()
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("Cannot read the xlsx: %v", err)
+	}
+	sheet := ""
+	for _, f := range archive.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			r, _ := f.Open()
+			b, _ := ioutil.ReadAll(r)
+			sheet = string(b)
+		}
+	}
+	assert.Equal(t, strings.Count(sheet, "<row "), 2)
+	assert.Equal(t, strings.Contains(sheet, `<c r="D2" t="inlineStr"><is><t xml:space="preserve">=HYPERLINK(&#34;http://evil&#34;)</t></is></c>`), true)
+	assert.Equal(t, strings.Contains(sheet, `<c r="I2"><v>15.90</v></c>`), true)
+
+	// An empty export still has its header
+	records, _ = csv.NewReader(export("brand=Nestle").Body).ReadAll()
+	assert.Equal(t, records, [][]string{models.ProductColumns})
+
+	assert.Equal(t, export("format=pdf").Code, http.StatusBadRequest)
+	assert.Equal(t, export("sort=colour").Code, http.StatusBadRequest)
+}
@@ -0,0 +1,337 @@
+package controllertests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/renditions"
+	"github.com/arikardnoir/asiwaju/api/storage"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// pngImage a blank PNG of the size
+func pngImage(width, height int) []byte {
+	buf := bytes.Buffer{}
+	_ = png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)))
+	return buf.Bytes()
+}
+
+// hugePNG a small PNG whose header declares the size, as a decompression bomb would
+func hugePNG(width, height int) []byte {
+	data := pngImage(1, 1)
+	// The IHDR chunk follows the 8 byte signature, its data starts with the width and height
+	binary.BigEndian.PutUint32(data[16:], uint32(width))
+	binary.BigEndian.PutUint32(data[20:], uint32(height))
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+// uploadImage post the data as the image field of a multipart form
+func uploadImage(pid string, token string, data []byte) *httptest.ResponseRecorder {
+	body := bytes.Buffer{}
+	form := multipart.NewWriter(&body)
+	// The declared type is not trusted, the content is sniffed
+	part, _ := form.CreateFormFile("image", "photo.jpg")
+	_, _ = part.Write(data)
+	_ = form.Close()
+
+	req, _ := http.NewRequest("POST", "/products/images", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", token)
+	req = mux.SetURLVars(req, map[string]string{"id": pid})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.UploadProductImage).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestProductImages(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, product, err := seedOneUserAndOneProduct()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token)
+	pid := product.ID.String()
+
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blobs, err := storage.NewLocalStore(dir, "http://localhost/media")
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.Blobs = blobs
+	defer func() { server.Blobs = nil }()
+
+	rr := uploadImage(pid, tokenString, pngImage(30, 20))
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	first := models.ProductImage{}
+	err = json.Unmarshal(rr.Body.Bytes(), &first)
+	if err != nil {
+		t.Errorf("Cannot convert to json: %v", err)
+	}
+	assert.Equal(t, first.ContentType, "image/png")
+	assert.Equal(t, first.Width, 30)
+	assert.Equal(t, first.Height, 20)
+	assert.Equal(t, first.Position, 0)
+	key := fmt.Sprintf("products/%s/%s.png", pid, first.ID)
+	assert.Equal(t, first.URL, "http://localhost/media/"+key)
+	stored, err := blobs.Get(key)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, stored, pngImage(30, 20))
+
+	rr = uploadImage(pid, tokenString, pngImage(10, 10))
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	second := models.ProductImage{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &second)
+	assert.Equal(t, second.Position, 1)
+
+	assert.Equal(t, uploadImage(pid, tokenString, []byte("<html><script>alert(1)</script></html>")).Code, http.StatusUnsupportedMediaType)
+	assert.Equal(t, uploadImage(pid, "", pngImage(10, 10)).Code, http.StatusUnauthorized)
+	os.Setenv("IMAGE_MAX_BYTES", "100")
+	assert.Equal(t, uploadImage(pid, tokenString, pngImage(300, 300)).Code, http.StatusRequestEntityTooLarge)
+	os.Unsetenv("IMAGE_MAX_BYTES")
+	assert.Equal(t, uploadImage(pid, tokenString, hugePNG(10000, 10000)).Code, http.StatusRequestEntityTooLarge)
+
+	request := func(method string, handler http.HandlerFunc, vars map[string]string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/products/images", bytes.NewBufferString(body))
+		req = mux.SetURLVars(req, vars)
+		req.Header.Set("Authorization", tokenString)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	order := fmt.Sprintf(`{"image_ids": ["%s", "%s"]}`, second.ID, first.ID)
+	rr = request("PUT", server.ReorderProductImages, map[string]string{"id": pid}, order)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	images := []models.ProductImage{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &images)
+	assert.Equal(t, len(images), 2)
+	assert.Equal(t, images[0].ID, second.ID)
+
+	twice := fmt.Sprintf(`{"image_ids": ["%s", "%s"]}`, second.ID, second.ID)
+	assert.Equal(t, request("PUT", server.ReorderProductImages, map[string]string{"id": pid}, twice).Code, http.StatusUnprocessableEntity)
+
+	rr = request("DELETE", server.DeleteProductImage, map[string]string{"id": pid, "imageID": first.ID.String()}, "")
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	_, err = blobs.Get(key)
+	assert.Equal(t, err, storage.ErrNotFound)
+	rr = request("DELETE", server.DeleteProductImage, map[string]string{"id": pid, "imageID": first.ID.String()}, "")
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+}
+
+func TestProductImageRenditions(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, product, err := seedOneUserAndOneProduct()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token)
+	pid := product.ID.String()
+
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blobs, err := storage.NewLocalStore(dir, "http://localhost/media")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Not started, the test processes the pending images itself
+	pipeline := renditions.NewPipeline(server.DB, blobs, []renditions.Preset{{Name: "thumbnail", Width: 10}, {Name: "large", Width: 1000}})
+	server.Blobs, server.Renditions = blobs, pipeline
+	defer func() { server.Blobs, server.Renditions = nil, nil }()
+
+	rr := uploadImage(pid, tokenString, pngImage(40, 20))
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	uploaded := models.ProductImage{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &uploaded)
+	assert.Equal(t, uploaded.RenditionStatus, models.RenditionsPending)
+	assert.Equal(t, len(uploaded.Srcset), 0)
+
+	processed, err := pipeline.ProcessPending()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, processed, 1)
+
+	productImage := models.ProductImage{}
+	found, err := productImage.FindImageByID(server.DB, product.ID, uploaded.ID)
+	if err != nil {
+		t.Fatalf("cannot find the image: %v", err)
+	}
+	assert.Equal(t, found.RenditionStatus, models.RenditionsReady)
+	assert.Equal(t, len(found.Renditions), 2)
+	base := fmt.Sprintf("http://localhost/media/products/%s/%s/", pid, uploaded.ID)
+	// The large preset is wider than the upload, which is not scaled up
+	assert.Equal(t, found.Srcset, map[string]string{"image/png": base + "thumbnail-10.png 10w, " + base + "large-40.png 40w"})
+	thumbnailKey := fmt.Sprintf("products/%s/%s/thumbnail-10.png", pid, uploaded.ID)
+	data, err := blobs.Get(thumbnailKey)
+	assert.Equal(t, err, nil)
+	thumbnail, err := png.Decode(bytes.NewReader(data))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, thumbnail.Bounds().Dx(), 10)
+	assert.Equal(t, thumbnail.Bounds().Dy(), 5)
+
+	// Queued again while its renditions are made, the image keeps its renditions and stays
+	// pending for the next run
+	stale, err := productImage.FindImageByID(server.DB, product.ID, uploaded.ID)
+	if err != nil {
+		t.Fatalf("cannot find the image: %v", err)
+	}
+	_, err = models.MarkImagesPending(server.DB, &product.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, pipeline.Process(stale), nil)
+	found, _ = productImage.FindImageByID(server.DB, product.ID, uploaded.ID)
+	assert.Equal(t, found.RenditionStatus, models.RenditionsPending)
+	assert.Equal(t, len(found.Renditions), 2)
+	_, err = blobs.Get(thumbnailKey)
+	assert.Equal(t, err, nil)
+	processed, _ = pipeline.ProcessPending()
+	assert.Equal(t, processed, 1)
+	found, _ = productImage.FindImageByID(server.DB, product.ID, uploaded.ID)
+	assert.Equal(t, found.RenditionStatus, models.RenditionsReady)
+
+	// The presets change, the renditions are made again and the old ones dropped
+	pipeline.Presets = []renditions.Preset{{Name: "thumbnail", Width: 20}}
+	req, _ := http.NewRequest("POST", "/admin/images/renditions", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(server.RegenerateAllImages).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusAccepted)
+	assert.Equal(t, rr.Body.String(), `{"queued":1}`+"\n")
+	processed, _ = pipeline.ProcessPending()
+	assert.Equal(t, processed, 1)
+	_, err = blobs.Get(thumbnailKey)
+	assert.Equal(t, err, storage.ErrNotFound)
+
+	req, _ = http.NewRequest("GET", "/products", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": pid})
+	req.Header.Set("Authorization", tokenString)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(server.GetProduct).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	shown := models.Product{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &shown)
+	assert.Equal(t, len(shown.Images), 1)
+	assert.Equal(t, shown.Images[0].Srcset, map[string]string{"image/png": base + "thumbnail-20.png 20w"})
+
+	req, _ = http.NewRequest("DELETE", "/products/images", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": pid, "imageID": uploaded.ID.String()})
+	req.Header.Set("Authorization", tokenString)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(server.DeleteProductImage).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	_, err = blobs.Get(fmt.Sprintf("products/%s/%s/thumbnail-20.png", pid, uploaded.ID))
+	assert.Equal(t, err, storage.ErrNotFound)
+}
+
+// fakeS3 a stand-in for an S3 bucket, keeping objects in memory
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-key/") ||
+		r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case "PUT":
+		f.objects[r.URL.Path] = body
+	case "GET":
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	case "DELETE":
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestProductImagesInS3(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, product, err := seedOneUserAndOneProduct()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+
+	bucket := &fakeS3{objects: map[string][]byte{}}
+	stand := httptest.NewServer(bucket)
+	defer stand.Close()
+	blobs, err := storage.NewS3Store(storage.S3Config{
+		Endpoint:        stand.URL,
+		Bucket:          "images",
+		AccessKeyID:     "test-key",
+		SecretAccessKey: "test-secret",
+		PathStyle:       true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.Blobs = blobs
+	defer func() { server.Blobs = nil }()
+
+	rr := uploadImage(product.ID.String(), fmt.Sprintf("Bearer %v", token), pngImage(8, 8))
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	created := models.ProductImage{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+	path := fmt.Sprintf("/images/products/%s/%s.png", product.ID, created.ID)
+	assert.Equal(t, created.URL, stand.URL+path)
+	assert.Equal(t, bucket.objects[path], pngImage(8, 8))
+
+	err = blobs.Delete(fmt.Sprintf("products/%s/%s.png", product.ID, created.ID))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(bucket.objects), 0)
+}
@@ -0,0 +1,132 @@
+package controllertests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestImportProducts(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, _, err := seedOneUserAndOneProduct()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token)
+
+	importFile := func(query, contentType, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/products/import?"+query, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", tokenString)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.ImportProducts).ServeHTTP(rr, req)
+		return rr
+	}
+	count := func() int {
+		n := 0
+		server.DB.Model(&models.Product{}).Where("owner_id = ?", user.ID).Count(&n)
+		return n
+	}
+	findBySKU := func(sku string) models.Product {
+		product := models.Product{}
+		server.DB.Model(&models.Product{}).Where("owner_id = ? AND sku = ?", user.ID, sku).Take(&product)
+		return product
+	}
+
+	csvFile := "\ufeffSKU,name,brand,price,currency,stock,public\n" +
+		"a-1,Arroz,Tio João,25.90,BRL,10,true\n" +
+		"a-2,,Camil,10,BRL,,\n" +
+		"a-3,Feijão,Camil,abc,BRL,,\n" +
+		"a-4,Feijão,Camil,8.5,BRL,3,false\n"
+
+	// A dry run reports every row and writes nothing
+	rr := importFile("dry_run=true", "text/csv", csvFile)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	report := models.ImportReport{}
+	err = json.Unmarshal(rr.Body.Bytes(), &report)
+	if err != nil {
+		t.Errorf("Cannot convert to json: %v", err)
+	}
+	assert.Equal(t, report.DryRun, true)
+	assert.Equal(t, report.Rows, 4)
+	assert.Equal(t, report.Created, 2)
+	assert.Equal(t, report.Failed, 2)
+	assert.Equal(t, report.Errors, models.ImportRowErrors{{Row: 2, Error: "Required Name"}, {Row: 3, Error: money.ErrInvalidAmount.Error()}})
+	assert.Equal(t, count(), 1)
+
+	rr = importFile("", "text/csv; charset=utf-8", csvFile)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	_ = json.Unmarshal(rr.Body.Bytes(), &report)
+	assert.Equal(t, report.Created, 2)
+	assert.Equal(t, count(), 3)
+	rice := findBySKU("A-1")
+	assert.Equal(t, rice.Name, "Arroz")
+	assert.Equal(t, rice.Price, money.MustParse("25.90", "BRL"))
+	assert.Equal(t, rice.Stock, 10)
+	assert.Equal(t, rice.Public, true)
+
+	// Without a key the same SKU is a conflict, with it the product is updated
+	rr = importFile("", "text/csv", "sku,name,brand,price,currency\na-1,Arroz,Tio João,27,BRL\n")
+	_ = json.Unmarshal(rr.Body.Bytes(), &report)
+	assert.Equal(t, report.Errors, models.ImportRowErrors{{Row: 1, Error: "SKU Already Taken"}})
+	rr = importFile("key=sku", "text/csv", "sku,price,currency\na-1,27.00,BRL\na-9,5,BRL\n")
+	assert.Equal(t, rr.Code, http.StatusOK)
+	_ = json.Unmarshal(rr.Body.Bytes(), &report)
+	assert.Equal(t, report.Updated, 1)
+	assert.Equal(t, report.Errors, models.ImportRowErrors{{Row: 2, Error: "Required Name"}})
+	rice = findBySKU("A-1")
+	assert.Equal(t, rice.Name, "Arroz")
+	assert.Equal(t, rice.Price, money.MustParse("27", "BRL"))
+	assert.Equal(t, rice.Stock, 10)
+
+	assert.Equal(t, importFile("", "text/csv", "name,colour\nArroz,white\n").Code, http.StatusUnprocessableEntity)
+	assert.Equal(t, importFile("", "text/plain", csvFile).Code, http.StatusUnsupportedMediaType)
+	assert.Equal(t, importFile("key=id", "text/csv", csvFile).Code, http.StatusBadRequest)
+
+	// A job imports in the background, its status tells how far it got
+	ndjson := `{"external_id": "sup-1", "name": "Café", "brand": "Pilão", "price": {"amount": "15", "currency": "BRL"}}` + "\n\n" +
+		`{"external_id": "sup-2", "name": "Açúcar", "brand": "União", "price": {"amount": "4.50", "currency": "BRL"}}` + "\n" +
+		`{"external_id": "sup-3", "name": ` + "\n"
+	rr = importFile("key=external_id&async=true", "application/x-ndjson", ndjson)
+	assert.Equal(t, rr.Code, http.StatusAccepted)
+	job := models.ImportJob{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &job)
+	assert.Equal(t, job.Status, models.ImportJobQueued)
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		req, _ := http.NewRequest("GET", "/products/import", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": job.ID.String()})
+		req.Header.Set("Authorization", tokenString)
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(server.GetImportJob).ServeHTTP(rr, req)
+		assert.Equal(t, rr.Code, http.StatusOK)
+		_ = json.Unmarshal(rr.Body.Bytes(), &job)
+		if job.Status == models.ImportJobDone || job.Status == models.ImportJobFailed {
+			break
+		}
+	}
+	assert.Equal(t, job.Status, models.ImportJobDone)
+	assert.Equal(t, job.Rows, 3)
+	assert.Equal(t, job.Created, 2)
+	assert.Equal(t, job.Failed, 1)
+	assert.Equal(t, job.Errors[0].Row, 3)
+	assert.Equal(t, count(), 5)
+}
@@ -0,0 +1,124 @@
+package controllertests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestProductRevisions(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token)
+
+	call := func(handler http.HandlerFunc, method, url string, vars map[string]string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, url, bytes.NewReader(data))
+		req = mux.SetURLVars(req, vars)
+		req.Header.Set("Authorization", tokenString)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	productJSON := func(name, price string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":  name,
+			"brand": "Pilão",
+			"price": moneyJSON(money.MustParse(price, "BRL")),
+		}
+	}
+
+	rr := call(server.CreateProduct, "POST", "/products", nil, productJSON("Café", "15.90"))
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	product := models.Product{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &product)
+	vars := map[string]string{"id": product.ID.String()}
+
+	assert.Equal(t, call(server.UpdateProduct, "PUT", "/products", vars, productJSON("Café", "17.50")).Code, http.StatusOK)
+	assert.Equal(t, call(server.UpdateProduct, "PUT", "/products", vars, productJSON("Café Tradicional", "17.50")).Code, http.StatusOK)
+	// Nothing changes, nothing is recorded
+	assert.Equal(t, call(server.UpdateProduct, "PUT", "/products", vars, productJSON("Café Tradicional", "17.50")).Code, http.StatusOK)
+
+	rr = call(server.GetProductRevisions, "GET", "/products/revisions", vars, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	revisions := []models.ProductRevision{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &revisions)
+	assert.Equal(t, len(revisions), 3)
+	assert.Equal(t, revisions[0].Number, 3)
+	assert.Equal(t, revisions[2].Action, models.RevisionCreate)
+	assert.Equal(t, *revisions[1].ActorID, user.ID)
+	assert.Equal(t, len(revisions[1].Changes), 1)
+	assert.Equal(t, string(revisions[1].Changes["price"].From), `{"amount":"15.90","currency":"BRL"}`)
+	assert.Equal(t, string(revisions[1].Changes["price"].To), `{"amount":"17.50","currency":"BRL"}`)
+
+	rr = call(server.GetProductRevisions, "GET", "/products/revisions?before=3&limit=1", vars, nil)
+	_ = json.Unmarshal(rr.Body.Bytes(), &revisions)
+	assert.Equal(t, len(revisions), 1)
+	assert.Equal(t, revisions[0].Number, 2)
+
+	// The diff of any two revisions, in either direction
+	rr = call(server.GetProductRevisions, "GET", "/products/revisions?from=3&to=1", vars, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	diff := struct {
+		Changes models.RevisionChanges `json:"changes"`
+	}{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &diff)
+	assert.Equal(t, len(diff.Changes), 2)
+	assert.Equal(t, string(diff.Changes["name"].From), `"Café Tradicional"`)
+	assert.Equal(t, string(diff.Changes["name"].To), `"Café"`)
+	assert.Equal(t, call(server.GetProductRevisions, "GET", "/products/revisions?from=1&to=9", vars, nil).Code, http.StatusNotFound)
+
+	// Restoring a revision is a revision too
+	rr = call(server.RevertProduct, "POST", "/products/revisions/restore", map[string]string{"id": product.ID.String(), "rev": "1"}, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	_ = json.Unmarshal(rr.Body.Bytes(), &product)
+	assert.Equal(t, product.Name, "Café")
+	assert.Equal(t, product.Price, money.MustParse("15.90", "BRL"))
+	rr = call(server.GetProductRevisions, "GET", "/products/revisions?limit=1", vars, nil)
+	_ = json.Unmarshal(rr.Body.Bytes(), &revisions)
+	assert.Equal(t, revisions[0].Number, 4)
+	assert.Equal(t, revisions[0].Action, models.RevisionRevert)
+	assert.Equal(t, *revisions[0].Reverts, 1)
+	assert.Equal(t, len(revisions[0].Changes), 2)
+	assert.Equal(t, call(server.RevertProduct, "POST", "/products/revisions/restore", map[string]string{"id": product.ID.String(), "rev": "9"}, nil).Code, http.StatusNotFound)
+
+	// A SKU taken by another product since cannot be restored
+	withSKU := productJSON("Café", "15.90")
+	withSKU["sku"] = "CAFE-500"
+	assert.Equal(t, call(server.UpdateProduct, "PUT", "/products", vars, withSKU).Code, http.StatusOK)
+	assert.Equal(t, call(server.UpdateProduct, "PUT", "/products", vars, productJSON("Café", "15.90")).Code, http.StatusOK)
+	assert.Equal(t, call(server.CreateProduct, "POST", "/products", nil, withSKU).Code, http.StatusCreated)
+	rr = call(server.RevertProduct, "POST", "/products/revisions/restore", map[string]string{"id": product.ID.String(), "rev": "5"}, nil)
+	assert.Equal(t, rr.Code, http.StatusConflict)
+	assert.Equal(t, strings.Contains(rr.Body.String(), "SKU Already Taken"), true)
+
+	assert.Equal(t, call(server.DeleteProduct, "DELETE", "/products", vars, nil).Code, http.StatusNoContent)
+	revision := models.ProductRevision{}
+	found, err := revision.FindProductRevisions(server.DB, product.ID, 0, 1)
+	if err != nil {
+		t.Fatalf("cannot find the revisions: %v", err)
+	}
+	assert.Equal(t, (*found)[0].Action, models.RevisionDelete)
+	assert.Equal(t, *(*found)[0].ActorID, user.ID)
+}
@@ -0,0 +1,151 @@
+package controllertests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arikardnoir/asiwaju/api/migrations"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestProductVariants(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	users, products, err := seedUsersAndProducts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _, err := server.SignIn(users[0].Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token)
+	otherToken, _, err := server.SignIn(users[1].Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	pid := products[0].ID.String()
+
+	request := func(method string, handler http.HandlerFunc, vars map[string]string, body string, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "/products/variants", bytes.NewBufferString(body))
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+		req = mux.SetURLVars(req, vars)
+		req.Header.Set("Authorization", token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := request("POST", server.CreateVariant, map[string]string{"id": pid}, `{"attributes": {"Size": "42", "color": "Azul"}, "price_override": {"amount": "35.50", "currency": "BRL"}, "stock": 4}`, tokenString)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	variant := models.ProductVariant{}
+	err = json.Unmarshal(rr.Body.Bytes(), &variant)
+	if err != nil {
+		t.Errorf("Cannot convert to json: %v", err)
+	}
+	assert.Equal(t, variant.Attributes, models.VariantAttributes{"size": "42", "color": "Azul"})
+	assert.Equal(t, variant.SKU, models.DefaultVariantSKU(products[0].ID, variant.Attributes))
+	assert.Equal(t, variant.PriceOverride, money.New(3550, "BRL"))
+	assert.Equal(t, variant.Stock, 4)
+	vid := variant.ID.String()
+
+	samples := []struct {
+		method     string
+		handler    http.HandlerFunc
+		vars       map[string]string
+		body       string
+		token      string
+		statusCode int
+	}{
+		// The same attributes again
+		{method: "POST", handler: server.CreateVariant, vars: map[string]string{"id": pid}, body: `{"attributes": {"size": "42", "color": "Azul"}, "sku": "OTHER"}`, token: tokenString, statusCode: http.StatusInternalServerError},
+		{method: "POST", handler: server.CreateVariant, vars: map[string]string{"id": pid}, body: `{"attributes": {}}`, token: tokenString, statusCode: http.StatusUnprocessableEntity},
+		{method: "POST", handler: server.CreateVariant, vars: map[string]string{"id": pid}, body: `{"attributes": {"size": "41"}, "stock": -1}`, token: tokenString, statusCode: http.StatusUnprocessableEntity},
+		// The product is priced in BRL
+		{method: "POST", handler: server.CreateVariant, vars: map[string]string{"id": pid}, body: `{"attributes": {"size": "41"}, "price_override": {"amount": "7", "currency": "USD"}}`, token: tokenString, statusCode: http.StatusUnprocessableEntity},
+		{method: "POST", handler: server.CreateVariant, vars: map[string]string{"id": pid}, body: `{"attributes": {"size": "41"}, "price_override": {"amount": "7.999", "currency": "BRL"}}`, token: tokenString, statusCode: http.StatusUnprocessableEntity},
+		{method: "POST", handler: server.CreateVariant, vars: map[string]string{"id": pid}, body: `{"attributes": {"size": "41"}}`, token: "", statusCode: http.StatusUnauthorized},
+		// The second product belongs to another user
+		{method: "GET", handler: server.GetVariants, vars: map[string]string{"id": products[1].ID.String()}, token: tokenString, statusCode: http.StatusNotFound},
+		{method: "GET", handler: server.GetVariant, vars: map[string]string{"id": pid, "variantID": vid}, token: tokenString, statusCode: http.StatusOK},
+		{method: "GET", handler: server.GetVariant, vars: map[string]string{"id": products[1].ID.String(), "variantID": vid}, token: tokenString, statusCode: http.StatusNotFound},
+		{method: "PUT", handler: server.UpdateVariant, vars: map[string]string{"id": pid, "variantID": vid}, body: `{"attributes": {"size": "43"}, "sku": "air-43", "stock": 9}`, token: tokenString, statusCode: http.StatusOK},
+		// Variant SKUs are unique per product, another seller may use the same one
+		{method: "POST", handler: server.CreateVariant, vars: map[string]string{"id": products[1].ID.String()}, body: `{"attributes": {"size": "43"}, "sku": "AIR-43"}`, token: "Bearer " + otherToken, statusCode: http.StatusCreated},
+		{method: "DELETE", handler: server.DeleteVariant, vars: map[string]string{"id": pid, "variantID": vid}, token: tokenString, statusCode: http.StatusNoContent},
+		{method: "DELETE", handler: server.DeleteVariant, vars: map[string]string{"id": pid, "variantID": vid}, token: tokenString, statusCode: http.StatusNotFound},
+	}
+	for _, v := range samples {
+		rr := request(v.method, v.handler, v.vars, v.body, v.token)
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.method == "PUT" && v.statusCode == http.StatusOK {
+			updated := models.ProductVariant{}
+			err = json.Unmarshal(rr.Body.Bytes(), &updated)
+			if err != nil {
+				t.Errorf("Cannot convert to json: %v", err)
+			}
+			assert.Equal(t, updated.SKU, "AIR-43")
+			// The stock only moves through the ledger
+			assert.Equal(t, updated.Stock, 4)
+		}
+	}
+}
+
+func TestSplitProductSizes(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, products, err := seedUsersAndProducts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = server.DB.DropTableIfExists("schema_migrations").Error
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = server.DB.Model(&products[0]).UpdateColumn("size", "38;39; 40;38").Error
+	if err != nil {
+		log.Fatalf("cannot set the size: %v", err)
+	}
+
+	// Running twice does not split the sizes twice
+	for i := 0; i < 2; i++ {
+		err = migrations.Run(server.DB)
+		if err != nil {
+			t.Errorf("this is the error: %v\n", err)
+		}
+	}
+
+	variant := models.ProductVariant{}
+	variants, err := variant.FindProductVariants(server.DB, products[0].ID)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	sizes := []string{}
+	for _, v := range *variants {
+		sizes = append(sizes, v.Attributes["size"])
+	}
+	assert.Equal(t, len(sizes), 3)
+
+	product := models.Product{}
+	err = server.DB.Model(&models.Product{}).Where("id = ?", products[0].ID).Take(&product).Error
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+	assert.Equal(t, product.Size, "")
+}
@@ -0,0 +1,174 @@
+package controllertests
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestTrash(t *testing.T) {
+
+	err := refreshUserAndProductTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, product, err := seedOneUserAndOneProduct()
+	if err != nil {
+		log.Fatal(err)
+	}
+	second := models.Product{
+		ID:      uuid.Must(uuid.NewRandom()),
+		Name:    "Café",
+		Brand:   "Pilão",
+		Price:   money.MustParse("15.90", "BRL"),
+		OwnerID: user.ID,
+	}
+	_, err = second.SaveProduct(server.DB)
+	if err != nil {
+		log.Fatalf("cannot seed the product: %v\n", err)
+	}
+	signIn := func() string {
+		token, _, err := server.SignIn(user.Email, "password")
+		if err != nil {
+			log.Fatalf("cannot login: %v\n", err)
+		}
+		return fmt.Sprintf("Bearer %v", token)
+	}
+	tokenString := signIn()
+
+	call := func(handler http.HandlerFunc, method string, vars map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/", nil)
+		req = mux.SetURLVars(req, vars)
+		req.Header.Set("Authorization", tokenString)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	trash := func() []models.Product {
+		rr := call(server.GetTrash, "GET", nil)
+		assert.Equal(t, rr.Code, http.StatusOK)
+		response := struct {
+			Data []models.Product `json:"data"`
+		}{}
+		err := json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil {
+			t.Errorf("Cannot convert to json: %v", err)
+		}
+		return response.Data
+	}
+
+	// A product cannot be created in the trash
+	past := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	third := models.Product{
+		ID:        uuid.Must(uuid.NewRandom()),
+		Name:      "Açúcar",
+		Brand:     "União",
+		Price:     money.MustParse("4.50", "BRL"),
+		OwnerID:   user.ID,
+		DeletedAt: &past,
+	}
+	third.Prepare()
+	_, err = third.SaveProduct(server.DB)
+	if err != nil {
+		log.Fatalf("cannot seed the product: %v\n", err)
+	}
+	assert.Equal(t, len(trash()), 0)
+	server.DB.Unscoped().Delete(&third)
+
+	rr := call(server.DeleteProduct, "DELETE", map[string]string{"id": product.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	assert.Equal(t, call(server.GetProduct, "GET", map[string]string{"id": product.ID.String()}).Code, http.StatusNotFound)
+	products, err := (&models.Product{}).FindAllProducts(server.DB, user.ID)
+	if err != nil {
+		t.Fatalf("cannot find the products: %v", err)
+	}
+	assert.Equal(t, len(*products), 1)
+	trashed := trash()
+	assert.Equal(t, len(trashed), 1)
+	assert.Equal(t, trashed[0].ID, product.ID)
+	assert.NotEqual(t, trashed[0].DeletedAt, nil)
+
+	rr = call(server.RestoreProduct, "POST", map[string]string{"id": product.ID.String()})
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, len(trash()), 0)
+	assert.Equal(t, call(server.GetProduct, "GET", map[string]string{"id": product.ID.String()}).Code, http.StatusOK)
+	assert.Equal(t, call(server.RestoreProduct, "POST", map[string]string{"id": product.ID.String()}).Code, http.StatusNotFound)
+
+	// Deleting the owner takes their products along, restoring them brings back only those
+	assert.Equal(t, call(server.DeleteProduct, "DELETE", map[string]string{"id": product.ID.String()}).Code, http.StatusNoContent)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, call(server.DeleteUser, "DELETE", map[string]string{"id": user.ID.String()}).Code, http.StatusNoContent)
+	_, err = (&models.User{}).FindUserByID(server.DB, user.ID)
+	assert.NotEqual(t, err, nil)
+	_, _, err = server.SignIn(user.Email, "password")
+	assert.NotEqual(t, err, nil)
+	_, err = (&models.Product{}).RestoreAProduct(server.DB, product.ID, user.ID)
+	assert.Equal(t, err, models.ErrOwnerDeleted)
+
+	admin := models.User{
+		ID:       uuid.Must(uuid.NewRandom()),
+		Fullname: "Ana Admin",
+		Nickname: "ana.admin",
+		Email:    "ana.admin@gmail.com",
+		Password: "password",
+		Role:     models.RoleAdmin,
+	}
+	_, err = admin.SaveUser(server.DB)
+	if err != nil {
+		log.Fatalf("cannot seed the admin: %v\n", err)
+	}
+	adminToken, _, err := server.SignIn(admin.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString = fmt.Sprintf("Bearer %v", adminToken)
+	assert.Equal(t, call(server.RestoreUser, "POST", map[string]string{"id": user.ID.String()}).Code, http.StatusOK)
+	tokenString = signIn()
+	trashed = trash()
+	assert.Equal(t, len(trashed), 1)
+	assert.Equal(t, trashed[0].ID, product.ID)
+
+	// Purging deletes for good what was deleted before the time
+	purge, err := models.PurgeTrash(server.DB, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("cannot purge the trash: %v", err)
+	}
+	assert.Equal(t, purge.Products, 0)
+	purge, err = models.PurgeTrash(server.DB, time.Now())
+	if err != nil {
+		t.Fatalf("cannot purge the trash: %v", err)
+	}
+	assert.Equal(t, purge.Products, 1)
+	assert.Equal(t, purge.Users, 0)
+	count := 0
+	server.DB.Unscoped().Model(&models.Product{}).Where("owner_id = ?", user.ID).Count(&count)
+	assert.Equal(t, count, 1)
+	assert.Equal(t, len(trash()), 0)
+
+	// A purged user leaves nothing behind
+	err = (&models.RecoveryCode{}).ReplaceRecoveryCodes(server.DB, user.ID, []string{"hash"})
+	if err != nil {
+		t.Fatalf("cannot seed the recovery codes: %v", err)
+	}
+	assert.Equal(t, call(server.DeleteUser, "DELETE", map[string]string{"id": user.ID.String()}).Code, http.StatusNoContent)
+	purge, err = models.PurgeTrash(server.DB, time.Now())
+	if err != nil {
+		t.Fatalf("cannot purge the trash: %v", err)
+	}
+	assert.Equal(t, purge.Products, 1)
+	assert.Equal(t, purge.Users, 1)
+	server.DB.Model(&models.RecoveryCode{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Equal(t, count, 0)
+	server.DB.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Count(&count)
+	assert.Equal(t, count, 0)
+}
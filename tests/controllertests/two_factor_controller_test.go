@@ -0,0 +1,119 @@
+package controllertests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arikardnoir/asiwaju/api/auth"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestTwoFactorLogin(t *testing.T) {
+
+	err := refreshUserTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatal(err)
+	}
+	token, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+
+	call := func(handler http.HandlerFunc, method, inputJSON string) (int, map[string]interface{}) {
+		req, err := http.NewRequest(method, "/users", bytes.NewBufferString(inputJSON))
+		if err != nil {
+			t.Errorf("this is the error: %v", err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": user.ID.String()})
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		response := map[string]interface{}{}
+		_ = json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code, response
+	}
+
+	code, response := call(server.EnrollTwoFactor, "POST", "")
+	assert.Equal(t, code, http.StatusOK)
+	secret := response["secret"].(string)
+	assert.NotEqual(t, response["otpauth_uri"], "")
+
+	// Logging in with the password only works until 2FA is confirmed
+	_, _, err = server.SignIn(user.Email, "password")
+	assert.Equal(t, err, nil)
+
+	code, _ = call(server.ConfirmTwoFactor, "POST", `{"code": "000000x"}`)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	previous, err := auth.TOTPCode(secret, auth.TOTPCounter(time.Now())-1)
+	if err != nil {
+		t.Errorf("cannot generate a code: %v", err)
+	}
+	code, response = call(server.ConfirmTwoFactor, "POST", fmt.Sprintf(`{"code": "%s"}`, previous))
+	assert.Equal(t, code, http.StatusOK)
+	recoveryCodes := response["recovery_codes"].([]interface{})
+	assert.Equal(t, len(recoveryCodes), 10)
+
+	login := func(handler http.HandlerFunc, inputJSON string) (int, map[string]interface{}) {
+		req, err := http.NewRequest("POST", "/login", bytes.NewBufferString(inputJSON))
+		if err != nil {
+			t.Errorf("this is the error: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		response := map[string]interface{}{}
+		_ = json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code, response
+	}
+
+	code, response = login(server.Login, fmt.Sprintf(`{"email": "%s", "password": "password"}`, user.Email))
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, response["two_factor_required"], true)
+	assert.Equal(t, response["token"], nil)
+	challenge := response["challenge_token"].(string)
+
+	// The challenge is no access token
+	req, err := http.NewRequest("GET", "/products", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", challenge))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.GetProducts).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+
+	// The code that confirmed the secret cannot be used again
+	code, _ = login(server.LoginTwoFactor, fmt.Sprintf(`{"challenge_token": "%s", "code": "%s"}`, challenge, previous))
+	assert.Equal(t, code, http.StatusUnauthorized)
+
+	current, err := auth.TOTPCode(secret, auth.TOTPCounter(time.Now()))
+	if err != nil {
+		t.Errorf("cannot generate a code: %v", err)
+	}
+	code, response = login(server.LoginTwoFactor, fmt.Sprintf(`{"challenge_token": "%s", "code": "%s"}`, challenge, current))
+	assert.Equal(t, code, http.StatusOK)
+	assert.NotEqual(t, response["token"], nil)
+	assert.NotEqual(t, response["refresh_token"], nil)
+
+	// Recovery codes are single-use
+	code, _ = login(server.LoginTwoFactor, fmt.Sprintf(`{"challenge_token": "%s", "recovery_code": "%s"}`, challenge, recoveryCodes[0]))
+	assert.Equal(t, code, http.StatusOK)
+	code, _ = login(server.LoginTwoFactor, fmt.Sprintf(`{"challenge_token": "%s", "recovery_code": "%s"}`, challenge, recoveryCodes[0]))
+	assert.Equal(t, code, http.StatusUnauthorized)
+
+	code, _ = call(server.DisableTwoFactor, "DELETE", fmt.Sprintf(`{"recovery_code": "%s"}`, recoveryCodes[1]))
+	assert.Equal(t, code, http.StatusNoContent)
+	_, _, err = server.SignIn(user.Email, "password")
+	assert.Equal(t, err, nil)
+}
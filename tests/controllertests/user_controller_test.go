@@ -12,6 +12,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/arikardnoir/asiwaju/api/middlewares"
 	"github.com/arikardnoir/asiwaju/api/models"
 	"gopkg.in/go-playground/assert.v1"
 )
@@ -38,6 +39,15 @@ func TestCreateUser(t *testing.T) {
 			email:        "jesl@gmail.com",
 			errorMessage: "",
 		},
+		{
+			// Verification, 2FA and the role cannot be set on signup
+			inputJSON:    `{"fullname":"Ana Lopes", "nickname":"ana", "email": "ana@gmail.com", "password": "password", "role": "admin", "email_verified": true, "email_verified_at": "2020-01-01T00:00:00Z", "totp_enabled": true}`,
+			statusCode:   201,
+			fullname:     "Ana Lopes",
+			nickname:     "ana",
+			email:        "ana@gmail.com",
+			errorMessage: "",
+		},
 		{
 			inputJSON:    `{"fullname":"Jamal Emery Lopes", "nickname":"Kan", "email": "kangmail.com", "password": "password"}`,
 			statusCode:   422,
@@ -86,6 +96,10 @@ func TestCreateUser(t *testing.T) {
 			assert.Equal(t, responseMap["fullname"], v.fullname)
 			assert.Equal(t, responseMap["nickname"], v.nickname)
 			assert.Equal(t, responseMap["email"], v.email)
+			assert.Equal(t, responseMap["role"], "user")
+			assert.Equal(t, responseMap["email_verified"], false)
+			assert.Equal(t, responseMap["email_verified_at"], nil)
+			assert.Equal(t, responseMap["totp_enabled"], false)
 		}
 		if v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
 			assert.Equal(t, responseMap["error"], v.errorMessage)
@@ -130,6 +144,10 @@ func TestGetUserByID(t *testing.T) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	token, _, err := server.SignIn(user.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
 	userSample := []struct {
 		id           uuid.UUID
 		statusCode   int
@@ -146,8 +164,9 @@ func TestGetUserByID(t *testing.T) {
 			email:      user.Email,
 		},
 		{
+			// Ordinary users only read their own account
 			id:         uuid.Nil,
-			statusCode: 404,
+			statusCode: 401,
 		},
 	}
 	for _, v := range userSample {
@@ -159,6 +178,7 @@ func TestGetUserByID(t *testing.T) {
 
 		convertID := v.id
 		req = mux.SetURLVars(req, map[string]string{"id": convertID.String()})
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(server.GetUser)
 		handler.ServeHTTP(rr, req)
@@ -175,6 +195,8 @@ func TestGetUserByID(t *testing.T) {
 			assert.Equal(t, user.Fullname, responseMap["fullname"])
 			assert.Equal(t, user.Nickname, responseMap["nickname"])
 			assert.Equal(t, user.Email, responseMap["email"])
+			_, hasPassword := responseMap["password"]
+			assert.Equal(t, hasPassword, false)
 		}
 	}
 }
@@ -409,3 +431,84 @@ func TestDeleteUser(t *testing.T) {
 		}
 	}
 }
+
+func TestAdminManagesUsers(t *testing.T) {
+
+	err := refreshUserTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	users, err := seedUsers()
+	if err != nil {
+		log.Fatalf("Error seeding user: %v\n", err)
+	}
+	admin := users[0]
+	err = server.DB.Model(&models.User{}).Where("id = ?", admin.ID).UpdateColumn("role", models.RoleAdmin).Error
+	if err != nil {
+		log.Fatalf("cannot make admin: %v\n", err)
+	}
+
+	adminToken, _, err := server.SignIn(admin.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	userToken, _, err := server.SignIn(users[1].Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+
+	samples := []struct {
+		handler    http.HandlerFunc
+		id         uuid.UUID
+		body       string
+		tokenGiven string
+		statusCode int
+	}{
+		{
+			// An ordinary user cannot promote themselves
+			handler:    middlewares.RequireRole(models.RoleAdmin)(server.UpdateUserRole),
+			id:         users[1].ID,
+			body:       `{"role": "admin"}`,
+			tokenGiven: userToken,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			handler:    middlewares.RequireRole(models.RoleAdmin)(server.UpdateUserRole),
+			id:         users[1].ID,
+			body:       `{"role": "superuser"}`,
+			tokenGiven: adminToken,
+			statusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			handler:    middlewares.RequireRole(models.RoleAdmin)(server.GetUsers),
+			tokenGiven: userToken,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			handler:    middlewares.RequireRole(models.RoleAdmin)(server.GetUsers),
+			tokenGiven: adminToken,
+			statusCode: http.StatusOK,
+		},
+		{
+			// Admins may delete any user
+			handler:    server.DeleteUser,
+			id:         users[1].ID,
+			tokenGiven: adminToken,
+			statusCode: http.StatusNoContent,
+		},
+	}
+
+	for _, v := range samples {
+
+		req, err := http.NewRequest("PUT", "/users", bytes.NewBufferString(v.body))
+		if err != nil {
+			t.Errorf("This is the error: %v\n", err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": v.id.String()})
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", v.tokenGiven))
+
+		rr := httptest.NewRecorder()
+		v.handler.ServeHTTP(rr, req)
+		assert.Equal(t, rr.Code, v.statusCode)
+	}
+}
@@ -0,0 +1,94 @@
+package controllertests
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/arikardnoir/asiwaju/api/mailer"
+	"github.com/arikardnoir/asiwaju/api/middlewares"
+	"github.com/arikardnoir/asiwaju/api/models"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestEmailVerification(t *testing.T) {
+
+	err := refreshUserTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	outbox := mailer.NewMemorySender()
+	server.Mailer = outbox
+	defer func() { server.Mailer = nil }()
+
+	req, err := http.NewRequest("POST", "/users", bytes.NewBufferString(`{"fullname":"Pet", "nickname":"pet", "email": "pet@gmail.com", "password": "password"}`))
+	if err != nil {
+		t.Errorf("this is the error: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.CreateUser).ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+
+	// Unverified users may log in, but not create products
+	token, user, err := server.SignIn("pet@gmail.com", "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	assert.Equal(t, user.EmailVerified, false)
+
+	createProduct := func(token string) int {
+		req, err := http.NewRequest("POST", "/products", bytes.NewBufferString(`{}`))
+		if err != nil {
+			t.Errorf("this is the error: %v", err)
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+		rr := httptest.NewRecorder()
+		handler := middlewares.SetMiddlewareAuthentication(middlewares.RequireVerifiedEmail(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}))
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, createProduct(token), http.StatusForbidden)
+
+	msg, ok := outbox.Last("pet@gmail.com")
+	assert.Equal(t, ok, true)
+	match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(msg.Body)
+	assert.Equal(t, len(match), 2)
+	verifyToken, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Errorf("cannot read the verification token: %v", err)
+	}
+
+	verify := func(token string) int {
+		req, err := http.NewRequest("GET", "/verify-email?token="+url.QueryEscape(token), nil)
+		if err != nil {
+			t.Errorf("this is the error: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.VerifyEmail).ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, verify("not a token"), http.StatusBadRequest)
+	assert.Equal(t, verify(verifyToken), http.StatusOK)
+	// The token is single-use
+	assert.Equal(t, verify(verifyToken), http.StatusBadRequest)
+
+	verified := models.User{}
+	_, err = verified.FindUserByID(server.DB, user.ID)
+	if err != nil {
+		log.Fatalf("cannot find the user: %v\n", err)
+	}
+	assert.Equal(t, verified.EmailVerified, true)
+
+	token, _, err = server.SignIn("pet@gmail.com", "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	assert.Equal(t, createProduct(token), http.StatusCreated)
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/arikardnoir/asiwaju/api/controllers"
 	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
 )
 
 var server = controllers.Server{}
@@ -118,11 +119,11 @@ func seedUsers() error {
 
 func refreshUserAndProductTable() error {
 
-	err := server.DB.DropTableIfExists(&models.User{}, &models.Product{}).Error
+	err := server.DB.DropTableIfExists("product_tags", &models.Tag{}, &models.ProductVariant{}, &models.StockReservation{}, &models.ProductImage{}, &models.ImageRendition{}, &models.ProductRevision{}, &models.PriceChange{}, &models.User{}, &models.Product{}).Error
 	if err != nil {
 		return err
 	}
-	err = server.DB.AutoMigrate(&models.User{}, &models.Product{}).Error
+	err = server.DB.AutoMigrate(&models.User{}, &models.Product{}, &models.ProductVariant{}, &models.StockReservation{}, &models.ProductImage{}, &models.ImageRendition{}, &models.ProductRevision{}, &models.PriceChange{}, &models.Tag{}).Error
 	if err != nil {
 		return err
 	}
@@ -151,7 +152,7 @@ func seedOneUserAndOneProduct() (models.Product, error) {
 		ID:       uuid.Must(uuid.NewRandom()),
 		Name:   			"BUFFET ALMOÇO NA MESA",
 		Brand:  			"Pizza Hut",
-		Price:  			40,
+		Price:  			money.MustParse("40", "BRL"),
 		Image:  			"https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg",
 		OwnerID: 			user.ID,
 		Description:  "Serviço exclusivo para consumo no serviço à mesa dos restaurantes aderentes. Válido de 2.ª a 6.ª feira, das 12:00h às 16:00h, exceto feriados. Imagens ilustrativas. IVA incluído à taxa legal em vigor.",
@@ -191,16 +192,17 @@ func seedUsersAndProducts() ([]models.User, []models.Product, error) {
 			ID:       uuid.Must(uuid.NewRandom()),
 			Name:    			"Shawarma de Frango",
 			Brand:  			"Alchaer Restaurante",
-			Price:				31.12,
+			Price:				money.MustParse("31.12", "BRL"),
 			Image:  			"https://images.rappi.com.br/products/06c0a5c9-9db5-4af9-b86b-da49927fb673-1673533770540.png?e=webp&d=511x511&q=85",
 			OwnerID: 			users[0].ID,
 			Description:  "Pão sírio assado na hora com peito de frango, picles, batata frita, pasta de alho e molho de romã.",
+			Public:       true,
 		},
 		models.Product{
 			ID:       uuid.Must(uuid.NewRandom()),
 			Name:    "MacBook Pro 13”",
 			Brand:  "Apple Inc.",
-			Price: 1299,
+			Price: money.MustParse("1299", "USD"),
 			Image:  "https://www.apple.com/v/macbook-pro/ah/images/overview/hero_13__d1tfa5zby7e6_large.jpg",
 			OwnerID: users[1].ID,
 			Description:  "The new M2 chip makes the 13‑inch MacBook Pro more capable than ever. The same compact design supports up to 20 hours of battery life1 and an active cooling system to sustain enhanced performance. Featuring a brilliant Retina display, a FaceTime HD camera, and studio‑quality mics, it’s our most portable pro laptop.",
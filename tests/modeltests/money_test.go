@@ -0,0 +1,61 @@
+package modeltests
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/arikardnoir/asiwaju/api/money"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestParseMoney(t *testing.T) {
+
+	samples := []struct {
+		amount   string
+		currency string
+		minor    int64
+		decimal  string
+		err      error
+	}{
+		{amount: "90", currency: "brl", minor: 9000, decimal: "90.00"},
+		{amount: "90.000", currency: "BRL", err: money.ErrInvalidAmount},
+		{amount: "0.05", currency: "USD", minor: 5, decimal: "0.05"},
+		{amount: "1299", currency: "JPY", minor: 1299, decimal: "1299"},
+		{amount: "12.5", currency: "JPY", err: money.ErrInvalidAmount},
+		{amount: "1.234", currency: "KWD", minor: 1234, decimal: "1.234"},
+		{amount: "-3.10", currency: "EUR", minor: -310, decimal: "-3.10"},
+		{amount: "1e3", currency: "USD", err: money.ErrInvalidAmount},
+		{amount: "1,50", currency: "USD", err: money.ErrInvalidAmount},
+		{amount: "10.", currency: "USD", err: money.ErrInvalidAmount},
+		{amount: "10", currency: "XYZ", err: money.ErrUnknownCurrency},
+	}
+	for _, v := range samples {
+		m, err := money.Parse(v.amount, v.currency)
+		assert.Equal(t, err, v.err)
+		if v.err == nil {
+			assert.Equal(t, m.Amount, v.minor)
+			assert.Equal(t, m.Decimal(), v.decimal)
+		}
+	}
+	assert.Equal(t, money.New(-310, "EUR").Validate(), money.ErrNegativeAmount)
+	assert.Equal(t, money.New(0, "EUR").Validate(), nil)
+}
+
+func TestMoneyJSON(t *testing.T) {
+
+	data, err := json.Marshal(money.New(1050, "BRL"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(data), `{"amount":"10.50","currency":"BRL"}`)
+
+	m := money.Money{}
+	err = json.Unmarshal([]byte(`{"amount": 10.5, "currency": "BRL"}`), &m)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, m, money.New(1050, "BRL"))
+
+	err = json.Unmarshal([]byte(`{"amount": "10.505", "currency": "BRL"}`), &m)
+	assert.Equal(t, err, money.ErrInvalidAmount)
+
+	err = json.Unmarshal([]byte(`null`), &m)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, m.IsZero(), true)
+}
@@ -8,6 +8,7 @@ import (
 
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"github.com/arikardnoir/asiwaju/api/models"
+	"github.com/arikardnoir/asiwaju/api/money"
 	"gopkg.in/go-playground/assert.v1"
 )
 
@@ -26,7 +27,8 @@ func TestFindAllProducts(t *testing.T) {
 		t.Errorf("this is the error getting the products: %v\n", err)
 		return
 	}
-	assert.Equal(t, len(*products), 2)
+	// Only the public product is open
+	assert.Equal(t, len(*products), 1)
 }
 
 func TestSaveProduct(t *testing.T) {
@@ -45,7 +47,7 @@ func TestSaveProduct(t *testing.T) {
 		ID:       uuid.Must(uuid.NewRandom()),
 		Name:   			"BUFFET ALMOÇO NA MESA",
 		Brand:  			"Pizza Hut",
-		Price:  			40,
+		Price:  			money.MustParse("40", "BRL"),
 		Image:  			"https://www.pizzahut.pt/wp-content/uploads/BUFFET_ALMOCO_na_mesa_8_95_30_junho-scaled.jpg",
 		OwnerID: 			user.ID,
 		Description:  "Serviço exclusivo para consumo no serviço à mesa dos restaurantes aderentes. Válido de 2.ª a 6.ª feira, das 12:00h às 16:00h, exceto feriados. Imagens ilustrativas. IVA incluído à taxa legal em vigor.",
@@ -101,7 +103,7 @@ func TestUpdateAProduct(t *testing.T) {
 		ID:       		product.ID,
 		Name:    			"Shawarma de Frango",
 		Brand:  			"Alchaer Restaurante",
-		Price:				31.12,
+		Price:				money.MustParse("31.12", "BRL"),
 		Image:  			"https://images.rappi.com.br/products/06c0a5c9-9db5-4af9-b86b-da49927fb673-1673533770540.png?e=webp&d=511x511&q=85",
 		OwnerID: 			product.OwnerID,
 		Description:  "Pão sírio assado na hora com peito de frango, picles, batata frita, pasta de alho e molho de romã.",
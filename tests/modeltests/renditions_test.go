@@ -0,0 +1,43 @@
+package modeltests
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/arikardnoir/asiwaju/api/renditions"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestParsePresets(t *testing.T) {
+
+	presets, err := renditions.ParsePresets("Thumbnail=150, large = 1200")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, presets, []renditions.Preset{{Name: "thumbnail", Width: 150}, {Name: "large", Width: 1200}})
+
+	for _, invalid := range []string{"", "thumbnail", "thumbnail=0", "thumbnail=wide", "a b=10", "small=10,small=20"} {
+		_, err = renditions.ParsePresets(invalid)
+		assert.NotEqual(t, err, nil)
+	}
+}
+
+func TestResize(t *testing.T) {
+
+	// Black and white columns average to grey
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			if x%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+	resized := renditions.Resize(src, 2)
+	assert.Equal(t, resized.Bounds(), image.Rect(0, 0, 2, 1))
+	assert.Equal(t, resized.RGBAAt(0, 0), color.RGBA{128, 128, 128, 255})
+
+	// Never scaled up
+	assert.Equal(t, renditions.Resize(src, 10).Bounds(), image.Rect(0, 0, 4, 2))
+}